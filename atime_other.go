@@ -0,0 +1,14 @@
+//go:build !(linux || openbsd || dragonfly || solaris || darwin || freebsd || netbsd || windows)
+
+package lock
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the modification time of the file, the
+// access time not being available on this platform
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
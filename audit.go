@@ -0,0 +1,193 @@
+package lock
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	auditFileName = ".audit.jsonl"
+
+	// DefaultAuditMaxSize is the size in bytes above which the audit log is rotated
+	DefaultAuditMaxSize = 1 << 20
+
+	// auditRotations is the number of rotated audit logs kept
+	auditRotations = 3
+)
+
+// Audit events
+const (
+	AuditAcquire = "acquire"
+	AuditRelease = "release"
+	AuditTimeout = "timeout"
+	AuditBreak   = "break"
+	AuditStale   = "stale"
+
+	// AuditTakeover is the removal, by the first waiter, of a lock whose
+	// holder was unreachable for Configuration.TakeoverAfter
+	AuditTakeover = "takeover"
+)
+
+// AuditRecord is a line of the audit log of a lock
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Lock   string    `json:"lock"`
+	ID     string    `json:"id,omitempty"`
+	Node   string    `json:"node"`
+	User   string    `json:"user"`
+	PID    int       `json:"pid"`
+	Detail string    `json:"detail,omitempty"`
+
+	// Waited is, for an acquisition, how long the lock was waited for,
+	// 0 if it was available at once
+	Waited time.Duration `json:"waited,omitempty"`
+}
+
+// History returns the audit records of the named lock, oldest first,
+// including those in rotated logs.
+func History(dir, name string) ([]AuditRecord, error) {
+	ndir := nameDir(dir, name)
+
+	var records []AuditRecord
+	for i := auditRotations; i >= 0; i-- {
+		recs, err := readAudit(auditPath(ndir, i))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// audit appends a record to the audit log of the lock name directory,
+// if auditing is enabled in the configuration or, for that lock, by
+// the existence of its audit log. Failures are logged but not returned,
+// auditing should not prevent locking.
+func audit(ndir, event, name, id, detail string) {
+	currentConfig().audit(ndir, event, name, id, detail)
+}
+
+func (c *Configuration) audit(ndir, event, name, id, detail string) {
+	c.auditRecord(ndir, c.newAuditRecord(event, name, id, detail))
+}
+
+// auditRecord appends the record like audit, and reports it as event
+func (c *Configuration) auditRecord(ndir string, rec AuditRecord) {
+	c.event(ndir, rec)
+
+	if _, err := c.filesystem().Stat(auditPath(ndir, 0)); err != nil && !c.Audit {
+		return
+	}
+
+	if err := c.appendAudit(auditPath(ndir, 0), rec); err != nil {
+		c.logger().Warn("failed to write audit log", "dir", ndir, "error", err)
+	}
+}
+
+// writeAudit appends a record to the audit log of the lock name directory,
+// whether auditing is enabled or not.
+func writeAudit(ndir, event, name, id, detail string) error {
+	c := currentConfig()
+	rec := c.newAuditRecord(event, name, id, detail)
+	c.event(ndir, rec)
+	return c.appendAudit(auditPath(ndir, 0), rec)
+}
+
+// newAuditRecord returns a record of the event by the current process
+func (c *Configuration) newAuditRecord(event, name, id, detail string) AuditRecord {
+	return AuditRecord{
+		Time:   c.clock().Now().UTC(),
+		Event:  event,
+		Lock:   name,
+		ID:     id,
+		Node:   currentNode(),
+		User:   currentUser(),
+		PID:    os.Getpid(),
+		Detail: detail,
+	}
+}
+
+func (c *Configuration) appendAudit(path string, rec AuditRecord) error {
+	maxSize := c.AuditMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultAuditMaxSize
+	}
+
+	fsys := c.filesystem()
+	info, err := fsys.Stat(path)
+	if err == nil && info.Size() > maxSize {
+		rotateAudit(fsys, filepath.Dir(path))
+		err = os.ErrNotExist
+	}
+	created := err != nil
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := fsys.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, c.fileMode())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if created {
+		fsys.Chmod(path, c.fileMode())
+	}
+
+	// a single write per record, so that concurrent appends don't interleave
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// rotateAudit shifts the audit logs, dropping the oldest
+func rotateAudit(fsys FS, ndir string) {
+	for i := auditRotations - 1; i >= 0; i-- {
+		fsys.Rename(auditPath(ndir, i), auditPath(ndir, i+1))
+	}
+}
+
+func readAudit(path string) ([]AuditRecord, error) {
+	data, err := fsys().ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// skip lines torn by a crash
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func auditPath(ndir string, rotation int) string {
+	if rotation == 0 {
+		return filepath.Join(ndir, auditFileName)
+	}
+	return filepath.Join(ndir, fmt.Sprintf("%s.%d", auditFileName, rotation))
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// held are the locks acquired with AutoRelease and not yet released
+var held = struct {
+	sync.Mutex
+	locks   map[string]*Lock
+	handler sync.Once
+}{locks: map[string]*Lock{}}
+
+// autoRelease registers the lock for release on process termination
+func autoRelease(l *Lock) {
+	held.Lock()
+	held.locks[l.path] = l
+	held.Unlock()
+
+	held.handler.Do(func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			sig := <-sigs
+			logger().Info("terminating on signal, releasing held locks", "signal", sig.String())
+			ReleaseHeld()
+
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		}()
+	})
+}
+
+// forget unregisters a released lock
+func forget(l *Lock) {
+	held.Lock()
+	delete(held.locks, l.path)
+	held.Unlock()
+}
+
+// ReleaseHeld releases all the locks acquired with AutoRelease and still
+// held by this process. Call it (e.g. deferred in main) before a normal exit,
+// or use Exit.
+func ReleaseHeld() {
+	held.Lock()
+	locks := make([]*Lock, 0, len(held.locks))
+	for _, l := range held.locks {
+		locks = append(locks, l)
+	}
+	held.Unlock()
+
+	for _, l := range locks {
+		if err := l.Release(); err != nil && !os.IsNotExist(err) {
+			logger().Error("failed to auto-release lock", "lock", l.name(), "id", l.ID(), "error", err)
+		}
+	}
+}
+
+// Exit releases the locks held with AutoRelease, then exits with the given code
+func Exit(code int) {
+	ReleaseHeld()
+	os.Exit(code)
+}
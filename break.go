@@ -0,0 +1,98 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const tombstoneFileType = ".tombstone"
+
+// Tombstone is left in place of a lock broken by someone other than its holder
+type Tombstone struct {
+	ID       string    `json:"id"`
+	Holder   string    `json:"holder"`
+	BrokenBy string    `json:"broken_by"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// Break removes the lock with the given ID held by someone else. The break
+// is always recorded in the audit log of the lock, with the identity of the
+// breaker. If tombstone is true, a tombstone file is left next to the
+// removed lock, so that the holder can find out its lock was broken.
+func Break(dir, id, reason string, tombstone bool) error {
+	// forged or tampered locks are those most in need of breaking: their
+	// signature is not checked, their holder being recorded as unverified
+	lck, err := findLock(id, dir)
+	if err != nil {
+		return err
+	}
+	holder := lck.node()
+	var sigErr *SignatureErr
+	if err := lck.verify(); errors.As(err, &sigErr) {
+		holder += " (unverified: " + sigErr.Reason + ")"
+	} else if err != nil {
+		holder += " (unverified: " + err.Error() + ")"
+	}
+
+	breaker := currentUser() + "@" + currentNode()
+	if tombstone {
+		t := Tombstone{
+			ID:       id,
+			Holder:   holder,
+			BrokenBy: breaker,
+			Time:     clock().Now().UTC(),
+			Reason:   reason,
+		}
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(lck.tombstonePath(), data); err != nil {
+			return fmt.Errorf("unable to write tombstone for lock %s: %v", id, err)
+		}
+	}
+
+	if err := lck.Remove(); err != nil {
+		return fmt.Errorf("unable to break lock %s: %v", id, err)
+	}
+
+	detail := fmt.Sprintf("held by %s, broken by %s", holder, breaker)
+	if len(reason) > 0 {
+		detail += ": " + reason
+	}
+	if err := writeAudit(lck.dir(), AuditBreak, lck.name(), id, detail); err != nil {
+		return fmt.Errorf("lock %s broken, but failed to record it in the audit log: %v", id, err)
+	}
+
+	logger().Warn("lock broken", "lock", lck.name(), "id", id, "by", breaker)
+	return nil
+}
+
+// Broken returns the tombstone left if the lock was broken by someone
+// else, nil if there is none.
+func (l *Lock) Broken() (*Tombstone, error) {
+	data, err := l.conf().filesystem().ReadFile(l.tombstonePath())
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var t Tombstone
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid tombstone for lock %s: %v", l.ID(), err)
+	}
+	return &t, nil
+}
+
+func (e *entry) tombstonePath() string {
+	return strings.TrimSuffix(e.path, e.filetype()) + tombstoneFileType
+}
@@ -0,0 +1,67 @@
+package lock
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budget is a total amount of time to spend acquiring a lock, shared
+// across several Acquire calls, e.g. when a caller retries after a
+// transient failure. Pass the same Budget in each Configuration so that
+// retries don't multiply the configured MaxWait.
+type Budget struct {
+	deadline time.Time
+	clock    Clock
+}
+
+// NewBudget returns a Budget expiring after the given duration from now
+// on the system clock, see NewBudgetClock
+func NewBudget(total time.Duration) *Budget {
+	return NewBudgetClock(total, nil)
+}
+
+// NewBudgetClock returns a Budget expiring after the given duration from
+// now on the given clock, the system one if nil, which should be the Clock
+// of the configurations using the budget
+func NewBudgetClock(total time.Duration, clk Clock) *Budget {
+	if clk == nil {
+		clk = realClock{}
+	}
+	return &Budget{clk.Now().Add(total), clk}
+}
+
+// Remaining returns the time left in the budget
+func (b *Budget) Remaining() time.Duration {
+	clk := b.clock
+	if clk == nil {
+		clk = realClock{}
+	}
+	left := b.deadline.Sub(clk.Now())
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Exhausted tells if the budget is all spent
+func (b *Budget) Exhausted() bool {
+	return b.Remaining() == 0
+}
+
+// ErrBudgetExhausted is returned when Acquire is called with an already spent budget
+var ErrBudgetExhausted = fmt.Errorf("acquire budget exhausted")
+
+// BudgetError wraps an error returned by Acquire when a Budget is
+// in use, exposing how much of the budget is left for further retries.
+type BudgetError struct {
+	Err       error
+	Remaining time.Duration
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%v (remaining budget %v)", e.Err, e.Remaining.Round(time.Second))
+}
+
+func (e *BudgetError) Unwrap() error {
+	return e.Err
+}
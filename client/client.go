@@ -0,0 +1,149 @@
+// Package client provides a single locking interface over the local lock
+// directory and the HTTP and gRPC lock servers, so that code can switch
+// between them through configuration only.
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// Lock is a held lock, wherever it lives
+type Lock interface {
+	ID() string
+	Renew(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+// Locker acquires locks. Of the configuration, only the name, poll interval,
+// maximum wait, priority and metadata are used by the remote lockers.
+// The ctx deadline, if any, further bounds the wait.
+type Locker interface {
+	Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error)
+	Close() error
+}
+
+// New returns the Locker for the given target:
+//
+//	/path/to/dir or file:///path/to/dir  the local lock directory
+//	http://host:port or https://...      an HTTP lock server (lock serve), its
+//	                                     token given as password: https://:token@host
+//	grpc://host:port                     a gRPC lock server (lock serve --grpc-addr), its
+//	                                     token given as password: grpc://:token@host
+//	unix:///path/to/socket               a local lock daemon (lock daemon)
+//	memcached://host:port[/prefix]       memcached keys, see DefaultMemcachedTTL
+//	local+<target>                       a local flock first, then the target, see NewDouble
+func New(target string) (Locker, error) {
+	if shared, ok := strings.CutPrefix(target, "local+"); ok {
+		l, err := New(shared)
+		if err != nil {
+			return nil, err
+		}
+		return NewDouble("", l), nil
+	}
+
+	if !strings.Contains(target, "://") {
+		return &localLocker{dir: target}, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock target %s: %v", target, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &localLocker{dir: u.Path}, nil
+	case "http", "https":
+		token, _ := u.User.Password()
+		u.User = nil
+		return newHTTPLocker(strings.TrimRight(u.String(), "/"), token), nil
+	case "grpc":
+		token, _ := u.User.Password()
+		return newGRPCLocker(u.Host, token)
+	case "unix":
+		return newUnixLocker(u.Path), nil
+	case "memcached":
+		return newMemcachedLocker(u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	default:
+		return nil, fmt.Errorf("unsupported lock target scheme %s", u.Scheme)
+	}
+}
+
+// maxWait returns the maximum wait of the configuration, bounded by the ctx deadline
+func maxWait(ctx context.Context, cfg lock.Configuration) time.Duration {
+	wait := cfg.MaxWait
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); wait < 0 || left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// seconds returns the duration in whole seconds, as sent to the lock
+// servers, rounding up so that a short positive duration is not lost.
+// Negative durations, i.e. WaitForever, are sent as -1.
+func seconds(d time.Duration) int {
+	if d < 0 {
+		return -1
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// ----------------------------------------------------------------------
+
+type localLocker struct {
+	dir string
+}
+
+func (l *localLocker) Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error) {
+	cfg.Dir = l.dir
+	cfg.MaxWait = maxWait(ctx, cfg)
+
+	lck, err := lock.AcquireContext(ctx, cfg.Name, lock.WithConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return &localLock{lck}, nil
+}
+
+func (l *localLocker) Close() error {
+	return nil
+}
+
+type localLock struct {
+	lck *lock.Lock
+}
+
+func (l *localLock) ID() string {
+	return l.lck.ID()
+}
+
+func (l *localLock) Renew(context.Context) error {
+	return l.lck.Renew()
+}
+
+func (l *localLock) Release(context.Context) error {
+	return l.lck.Release()
+}
+
+// remoteErr is an error reported by a lock server, matching with
+// errors.Is the lock package sentinel error of its kind, if known
+type remoteErr struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteErr) Error() string {
+	return e.msg
+}
+
+func (e *remoteErr) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
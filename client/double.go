@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// DefaultFlockDir is the local directory of the flock files of the
+// double lockers, see NewDouble
+var DefaultFlockDir = filepath.Join(os.TempDir(), "lock-flock")
+
+// doubleLocker takes a local flock before acquiring the lock from the
+// shared locker, so that the processes of a node coordinate cheaply
+// among themselves and only one of them at a time contends on the
+// shared store
+type doubleLocker struct {
+	dir    string
+	shared Locker
+}
+
+// NewDouble returns a Locker requiring both an flock on a file of the
+// local directory, DefaultFlockDir if empty, and the lock of the shared
+// locker, taken in this order and released in the reverse one
+func NewDouble(dir string, shared Locker) Locker {
+	if len(dir) == 0 {
+		dir = DefaultFlockDir
+	}
+	return &doubleLocker{dir, shared}
+}
+
+func (d *doubleLocker) Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error) {
+	if err := lock.ValidateName(cfg.Name); err != nil {
+		return nil, err
+	}
+
+	wait := maxWait(ctx, cfg)
+	started := time.Now()
+
+	f, err := d.flock(ctx, cfg.Name, cfg.PollInterval, wait)
+	if err != nil {
+		return nil, err
+	}
+
+	// the time spent on the local lock counts towards the maximum wait
+	if wait >= 0 {
+		cfg.MaxWait = max(wait-time.Since(started), 0)
+	}
+	shared, err := d.shared.Acquire(ctx, cfg)
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, err
+	}
+	return &doubleLock{shared, f}, nil
+}
+
+// flock opens the flock file of the lock name and locks it, polling
+// until it is free or the maximum wait elapsed
+func (d *doubleLocker) flock(ctx context.Context, name string, poll, wait time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(d.dir, 0777); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.dir, url.PathEscape(strings.Trim(name, "/"))+".flock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to lock %s: %v", path, err)
+		}
+		if locked {
+			return f, nil
+		}
+
+		if wait >= 0 && time.Since(started) >= wait {
+			f.Close()
+			return nil, &lock.TimeoutErr{MaxWait: wait, Waiting: "waiting for another process of this node"}
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (d *doubleLocker) Close() error {
+	return d.shared.Close()
+}
+
+type doubleLock struct {
+	shared Lock
+	f      *os.File
+}
+
+func (l *doubleLock) ID() string {
+	return l.shared.ID()
+}
+
+func (l *doubleLock) Renew(ctx context.Context) error {
+	return l.shared.Renew(ctx)
+}
+
+// Release releases the shared lock, then the local one whatever the outcome
+func (l *doubleLock) Release(ctx context.Context) error {
+	err := l.shared.Release(ctx)
+	return errors.Join(err, unlockFile(l.f), l.f.Close())
+}
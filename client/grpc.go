@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/lockgrpc"
+	"github.com/brinick/lock/lockpb"
+)
+
+type grpcLocker struct {
+	client *lockgrpc.Client
+}
+
+func newGRPCLocker(addr, token string) (*grpcLocker, error) {
+	var opts []grpc.DialOption
+	if len(token) > 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()), lockgrpc.WithToken(token))
+	}
+	c, err := lockgrpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcLocker{c}, nil
+}
+
+func (g *grpcLocker) Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error) {
+	info, err := g.client.Acquire(ctx, &lockpb.AcquireRequest{
+		Name:         cfg.Name,
+		PollInterval: int32(seconds(cfg.PollInterval)),
+		MaxWait:      int32(seconds(cfg.MaxWait)),
+		Priority:     int32(cfg.Priority),
+		Metadata:     cfg.Metadata,
+	}, cfg.OnWaiting)
+	if err != nil {
+		return nil, grpcErr(err)
+	}
+	return &grpcLock{g.client, info.Id}, nil
+}
+
+func (g *grpcLocker) Close() error {
+	return g.client.Close()
+}
+
+type grpcLock struct {
+	client *lockgrpc.Client
+	id     string
+}
+
+func (l *grpcLock) ID() string {
+	return l.id
+}
+
+func (l *grpcLock) Renew(ctx context.Context) error {
+	return grpcErr(l.client.Renew(ctx, l.id))
+}
+
+func (l *grpcLock) Release(ctx context.Context) error {
+	return grpcErr(l.client.Release(ctx, l.id))
+}
+
+// grpcErr makes the error of a gRPC lock server match the lock error of its code
+func grpcErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var kind error
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		kind = lock.ErrTimeout
+	case codes.NotFound:
+		kind = lock.ErrNotFound
+	case codes.PermissionDenied:
+		kind = lock.ErrNotOwner
+	case codes.ResourceExhausted:
+		kind = lock.ErrQueueFull
+	case codes.Unavailable:
+		kind = lock.ErrBackend
+	default:
+		return err
+	}
+	return &remoteErr{err.Error(), kind}
+}
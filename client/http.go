@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/server"
+)
+
+type httpLocker struct {
+	base   string
+	client *http.Client
+
+	// token is sent as a bearer token, if set
+	token string
+}
+
+func newHTTPLocker(base, token string) *httpLocker {
+	return &httpLocker{base, &http.Client{}, token}
+}
+
+// newUnixLocker returns a locker talking HTTP to a lock daemon on a Unix socket
+func newUnixLocker(socket string) *httpLocker {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &httpLocker{"http://unix", &http.Client{Transport: transport}, ""}
+}
+
+func (h *httpLocker) Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error) {
+	body, err := json.Marshal(server.AcquireRequest{
+		Name:         cfg.Name,
+		PollInterval: seconds(cfg.PollInterval),
+		MaxWait:      seconds(maxWait(ctx, cfg)),
+		Priority:     cfg.Priority,
+		Metadata:     cfg.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info lock.Info
+	if err := h.call(ctx, http.MethodPost, "/locks", body, http.StatusCreated, &info); err != nil {
+		return nil, err
+	}
+	return &httpLock{h, info.ID}, nil
+}
+
+func (h *httpLocker) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *httpLocker) call(ctx context.Context, method, path string, body []byte, want int, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, h.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(h.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return &remoteErr{fmt.Sprintf("lock server request failed: %v", err), lock.ErrBackend}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		var e server.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return &remoteErr{fmt.Sprintf("lock server returned %s: %s", resp.Status, e.Error), statusKind(resp.StatusCode)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type httpLock struct {
+	locker *httpLocker
+	id     string
+}
+
+func (l *httpLock) ID() string {
+	return l.id
+}
+
+func (l *httpLock) Renew(ctx context.Context) error {
+	return l.locker.call(ctx, http.MethodPost, "/locks/"+l.id+"/renew", nil, http.StatusNoContent, nil)
+}
+
+func (l *httpLock) Release(ctx context.Context) error {
+	return l.locker.call(ctx, http.MethodDelete, "/locks/"+l.id, nil, http.StatusNoContent, nil)
+}
+
+// statusKind returns the lock error matching the HTTP status of a lock server
+func statusKind(status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return lock.ErrNotFound
+	case http.StatusForbidden:
+		return lock.ErrNotOwner
+	case http.StatusTooManyRequests:
+		return lock.ErrQueueFull
+	case http.StatusServiceUnavailable:
+		return lock.ErrBackend
+	default:
+		return nil
+	}
+}
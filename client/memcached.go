@@ -0,0 +1,379 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// DefaultMemcachedTTL is the expiry of the memcached locks when the
+// configuration has no TTL: memcached has no notion of a dead holder,
+// so a lock not renewed within it is lost
+const DefaultMemcachedTTL = time.Minute
+
+// memcachedTimeout bounds the connection to memcached and each command,
+// unless the context of the call ends earlier
+const memcachedTimeout = 10 * time.Second
+
+// memcachedLocker takes the locks as memcached keys, created with the
+// atomic add command so that only one client gets each. There is no
+// queue: waiters poll, so priorities are ignored, and MaxLocks is 1.
+type memcachedLocker struct {
+	addr   string
+	prefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// connMu guards conn against the deadline set when a context is done
+	connMu sync.Mutex
+}
+
+func newMemcachedLocker(addr, prefix string) *memcachedLocker {
+	if len(prefix) == 0 {
+		prefix = "lock/"
+	}
+	return &memcachedLocker{addr: addr, prefix: prefix}
+}
+
+// memcachedValue is the value of a lock key
+type memcachedValue struct {
+	ID       string            `json:"id"`
+	Node     string            `json:"node"`
+	PID      int               `json:"pid"`
+	Created  time.Time         `json:"created"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (m *memcachedLocker) Acquire(ctx context.Context, cfg lock.Configuration) (Lock, error) {
+	if err := lock.ValidateName(cfg.Name); err != nil {
+		return nil, err
+	}
+	key, err := m.key(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	node, _ := os.Hostname()
+	value, err := json.Marshal(memcachedValue{id, node, os.Getpid(), time.Now().UTC(), cfg.Metadata})
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultMemcachedTTL
+	}
+	poll := cfg.PollInterval
+	if poll <= 0 {
+		poll = lock.DefaultPollTime
+	}
+
+	wait := maxWait(ctx, cfg)
+	started := time.Now()
+	for {
+		added, err := m.add(ctx, key, value, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			return &memcachedLock{m, key, id, ttl}, nil
+		}
+
+		if wait >= 0 && time.Since(started) >= wait {
+			return nil, &lock.TimeoutErr{MaxWait: wait, Waiting: "waiting for existing lock to be released"}
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *memcachedLocker) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reset()
+}
+
+// key returns the memcached key of the lock name, which must not exceed
+// 250 bytes or hold spaces or control characters
+func (m *memcachedLocker) key(name string) (string, error) {
+	key := m.prefix + name
+	if len(key) > 250 {
+		return "", fmt.Errorf("memcached key %s longer than 250 bytes", key)
+	}
+	if strings.IndexFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		return "", fmt.Errorf("invalid memcached key %q, spaces and control characters are not allowed", key)
+	}
+	return key, nil
+}
+
+// add stores the value unless the key exists, telling if it was stored
+func (m *memcachedLocker) add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := m.store(ctx, "add", key, value, expiration(ttl), 0)
+	if err != nil {
+		return false, err
+	}
+	switch reply {
+	case "STORED":
+		return true, nil
+	case "NOT_STORED":
+		return false, nil
+	default:
+		return false, m.replyErr(reply)
+	}
+}
+
+// gets returns the value of the key and its CAS unique, found false if absent
+func (m *memcachedLocker) gets(ctx context.Context, key string) (value []byte, cas uint64, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer m.bound(ctx, &err)()
+	if err := m.send(ctx, fmt.Sprintf("gets %s\r\n", key), nil); err != nil {
+		return nil, 0, false, err
+	}
+
+	line, err := m.readLine()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if line == "END" {
+		return nil, 0, false, nil
+	}
+
+	// VALUE <key> <flags> <bytes> <cas unique>
+	fields := strings.Fields(line)
+	if len(fields) != 5 || fields[0] != "VALUE" {
+		m.reset()
+		return nil, 0, false, m.replyErr(line)
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil {
+		m.reset()
+		return nil, 0, false, m.replyErr(line)
+	}
+	if cas, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		m.reset()
+		return nil, 0, false, m.replyErr(line)
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(m.rw, data); err != nil {
+		m.reset()
+		return nil, 0, false, memcachedErr(err)
+	}
+	if end, err := m.readLine(); err != nil || end != "END" {
+		m.reset()
+		return nil, 0, false, m.replyErr(end)
+	}
+	return data[:size], cas, true, nil
+}
+
+// compareAndSwap stores the value if the key was not changed since read
+// with the given CAS unique, returning the memcached reply: STORED,
+// EXISTS or NOT_FOUND
+func (m *memcachedLocker) compareAndSwap(ctx context.Context, key string, value []byte, exptime int64, cas uint64) (string, error) {
+	reply, err := m.store(ctx, "cas", key, value, exptime, cas)
+	if err != nil {
+		return "", err
+	}
+	switch reply {
+	case "STORED", "EXISTS", "NOT_FOUND":
+		return reply, nil
+	default:
+		return "", m.replyErr(reply)
+	}
+}
+
+// store runs a storage command, returning its reply
+func (m *memcachedLocker) store(ctx context.Context, cmd, key string, value []byte, exptime int64, cas uint64) (reply string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	defer m.bound(ctx, &err)()
+	line := fmt.Sprintf("%s %s 0 %d %d", cmd, key, exptime, len(value))
+	if cmd == "cas" {
+		line += fmt.Sprintf(" %d", cas)
+	}
+	if err := m.send(ctx, line+"\r\n", value); err != nil {
+		return "", err
+	}
+	return m.readLine()
+}
+
+// bound makes the I/O of the command fail once the context is done, the
+// error of the command being then that of the context. The returned
+// function, to be deferred, stops watching the context.
+func (m *memcachedLocker) bound(ctx context.Context, err *error) func() {
+	stop := context.AfterFunc(ctx, func() {
+		m.deadline(time.Now())
+	})
+	return func() {
+		stop()
+		if *err != nil && ctx.Err() != nil {
+			*err = ctx.Err()
+		}
+	}
+}
+
+// deadline sets the deadline of the connection, if any, possibly while
+// a command is running
+func (m *memcachedLocker) deadline(t time.Time) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.conn != nil {
+		m.conn.SetDeadline(t)
+	}
+}
+
+// send writes the command, and the data block if not nil, connecting first if needed
+func (m *memcachedLocker) send(ctx context.Context, cmd string, data []byte) error {
+	if m.conn == nil {
+		d := net.Dialer{Timeout: memcachedTimeout}
+		conn, err := d.DialContext(ctx, "tcp", m.addr)
+		if err != nil {
+			return memcachedErr(err)
+		}
+		m.connMu.Lock()
+		m.conn = conn
+		m.connMu.Unlock()
+		m.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	}
+
+	// a hung server must not block the caller forever
+	deadline := time.Now().Add(memcachedTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	m.deadline(deadline)
+	if ctx.Err() != nil {
+		// done before the deadline was set, overriding that of bound
+		m.deadline(time.Now())
+	}
+
+	m.rw.WriteString(cmd)
+	if data != nil {
+		m.rw.Write(data)
+		m.rw.WriteString("\r\n")
+	}
+	if err := m.rw.Flush(); err != nil {
+		m.reset()
+		return memcachedErr(err)
+	}
+	return nil
+}
+
+func (m *memcachedLocker) readLine() (string, error) {
+	line, err := m.rw.ReadString('\n')
+	if err != nil {
+		m.reset()
+		return "", memcachedErr(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// reset closes the connection, the next command reconnecting
+func (m *memcachedLocker) reset() error {
+	if m.conn == nil {
+		return nil
+	}
+	err := m.conn.Close()
+	m.connMu.Lock()
+	m.conn = nil
+	m.connMu.Unlock()
+	m.rw = nil
+	return err
+}
+
+func (m *memcachedLocker) replyErr(reply string) error {
+	return &remoteErr{fmt.Sprintf("unexpected memcached reply from %s: %q", m.addr, reply), lock.ErrBackend}
+}
+
+func memcachedErr(err error) error {
+	return &remoteErr{fmt.Sprintf("memcached request failed: %v", err), lock.ErrBackend}
+}
+
+// expiration returns the memcached expiration time of the TTL, in
+// seconds, rounded up. Past 30 days memcached takes it as a Unix time.
+func expiration(ttl time.Duration) int64 {
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if secs > 30*24*3600 {
+		return time.Now().Add(ttl).Unix()
+	}
+	return secs
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type memcachedLock struct {
+	locker *memcachedLocker
+	key    string
+	id     string
+	ttl    time.Duration
+}
+
+func (l *memcachedLock) ID() string {
+	return l.id
+}
+
+// Renew pushes back the expiry of the key by the TTL, if still held
+func (l *memcachedLock) Renew(ctx context.Context) error {
+	return l.update(ctx, expiration(l.ttl))
+}
+
+// Release expires the key at once, if still held
+func (l *memcachedLock) Release(ctx context.Context) error {
+	// a negative expiration time expires the item immediately
+	return l.update(ctx, -1)
+}
+
+// update stores the value of the lock again with the given expiration time,
+// with cas so that a lock lapsed and taken by someone else is left alone
+func (l *memcachedLock) update(ctx context.Context, exptime int64) error {
+	for {
+		value, cas, found, err := l.locker.gets(ctx, l.key)
+		if err != nil {
+			return err
+		}
+
+		var v memcachedValue
+		if !found || json.Unmarshal(value, &v) != nil || v.ID != l.id {
+			return &lock.NotFoundErr{ID: l.id, Dir: "memcached://" + l.locker.addr, What: "lock"}
+		}
+
+		reply, err := l.locker.compareAndSwap(ctx, l.key, value, exptime, cas)
+		if err != nil {
+			return err
+		}
+		if reply == "STORED" {
+			return nil
+		}
+		// changed or removed meanwhile, check again who holds it
+	}
+}
@@ -0,0 +1,49 @@
+package lock
+
+import "time"
+
+// Clock tells the time and waits, so that tests can simulate
+// timeouts and long waits without sleeping for real
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clock returns the configured Clock, the system clock if not set
+func (c *Configuration) clock() Clock {
+	if c.Clock == nil {
+		return realClock{}
+	}
+	return c.Clock
+}
+
+// since returns the time elapsed since t according to the clock
+func (c *Configuration) since(t time.Time) time.Duration {
+	return c.clock().Now().Sub(t)
+}
+
+// anchor returns the wall clock time t as a time of the clock, carrying
+// the monotonic reading of the real clock, so that the durations measured
+// from it are not shifted by steps of the wall clock, e.g. by NTP. Times
+// in the future count as now.
+func (c *Configuration) anchor(t time.Time) time.Time {
+	return c.clock().Now().Add(-max(c.since(t), 0))
+}
+
+func clock() Clock {
+	return currentConfig().clock()
+}
+
+func since(t time.Time) time.Duration {
+	return currentConfig().since(t)
+}
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/brinick/lock"
+	"github.com/brinick/lock/lockgrpc"
+	"github.com/brinick/lock/metrics"
+	"github.com/brinick/lock/mqtt"
+	"github.com/brinick/lock/server"
+	"github.com/brinick/lock/systemd"
 )
 
 func createApp() *cli.App {
@@ -15,83 +29,1527 @@ func createApp() *cli.App {
 		Usage: "Create/Delete locks",
 		Commands: []*cli.Command{
 			acquireCmd(),
+			runCmd(),
+			moduleCmd(),
+			gateCmd(),
 			deleteCmd(),
+			renewCmd(),
+			touchCmd(),
+			statsCmd(),
+			statusCmd(),
+			reportCmd(),
+			historyCmd(),
+			listCmd(),
+			serveCmd(),
+			daemonCmd(),
+			watchCmd(),
+			breakCmd(),
+			infoCmd(),
+			exportCmd(),
+			importCmd(),
+			purgeCmd(),
+			existsCmd(),
+			verifyCmd(),
+			recoverCmd(),
+			completionCmd(),
 		},
 	}
 
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "Serve Prometheus metrics on this address (e.g. :9090) while the command runs",
+		},
+		&cli.BoolFlag{
+			Name:        "quiet",
+			Aliases:     []string{"q"},
+			Usage:       "Do not report errors, only fail with the exit code",
+			Destination: &quiet,
+		},
+		&cli.StringFlag{
+			Name:        "log-target",
+			Usage:       "Log the lock events to stderr (with -v), syslog or journal (the systemd journal)",
+			Value:       "stderr",
+			Destination: &logTarget,
+		},
+		&cli.StringFlag{
+			Name:        "event-log",
+			Usage:       "Append every lock event as a JSON line to this file, e.g. for ELK or Loki",
+			Destination: &eventLog,
+		},
+		&cli.StringFlag{
+			Name:        "journal",
+			Usage:       "Record the lock operations in progress in this file, suffixed by the PID of each process, to recover them after a crash",
+			Destination: &journalFile,
+		},
+		&cli.StringSliceFlag{
+			Name:  "webhook",
+			Usage: "POST the lock events as JSON to this URL (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "webhook-events",
+			Usage: "The comma-separated events posted to the webhooks, e.g. acquire,release,timeout,break,stale, all if not given",
+		},
+		&cli.StringFlag{
+			Name:    "webhook-secret",
+			Usage:   "Sign the webhook payloads with this secret (HMAC-SHA256 in X-Lock-Signature)",
+			EnvVars: []string{"LOCK_WEBHOOK_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:  "mqtt",
+			Usage: "Publish the lock events to this MQTT broker, as mqtt[s]://[user[:password]@]host[:port][/topic][?qos=1], the topic defaulting to " + mqtt.DefaultTopic,
+		},
+		&cli.StringFlag{
+			Name:        "textfile",
+			Usage:       "Write the lock and queue gauges to this node_exporter textfile collector file (*.prom) on each change",
+			Destination: &textfile,
+		},
+		&cli.StringFlag{
+			Name:  "fs-profile",
+			Usage: "Adapt the file operations to the filesystem of the lock directory: default, or smb for CIFS/SMB mounts",
+			Value: "default",
+		},
+		&countFlag{cli.GenericFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Log the lock events on stderr, -vv to also log the debug ones",
+			Value:   &verbose,
+		}},
+	}
+	app.Before = func(c *cli.Context) error {
+		switch val := c.String("fs-profile"); val {
+		case "default":
+		case "smb":
+			fsProfile = lock.FSProfileSMB
+		default:
+			return usageError("Invalid --fs-profile %q, expected default or smb", val)
+		}
+		lock.SetFSProfile(fsProfile)
+		lock.SetEventLog(eventLog)
+		lock.SetJournal(journalFile)
+
+		webhooks = nil
+		for _, url := range c.StringSlice("webhook") {
+			hook := lock.Webhook{URL: url, Secret: c.String("webhook-secret")}
+			if events := c.String("webhook-events"); len(events) > 0 {
+				hook.Events = strings.Split(events, ",")
+			}
+			webhooks = append(webhooks, hook)
+		}
+		lock.SetWebhooks(webhooks)
+
+		eventSinks = nil
+		if broker := strArg(c, "mqtt", ""); len(broker) > 0 {
+			p, err := mqtt.New(broker)
+			if err != nil {
+				return usageError("%v", err)
+			}
+			publisher = p
+			eventSinks = append(eventSinks, p)
+		}
+		lock.SetEventSinks(eventSinks)
+
+		return startMetrics(c)
+	}
+	markCommandsParsed(app.Commands)
+
+	app.CustomAppHelpTemplate = cli.AppHelpTemplate + "\n" + exitCodesHelp + "\n"
+	app.UseShortOptionHandling = true
 	app.EnableBashCompletion = true
 	return app
 }
 
-func acquireCmd() *cli.Command {
+// registry collects the metrics of the lock operations, nil unless --metrics-addr is given
+var registry *metrics.Registry
+
+func startMetrics(c *cli.Context) error {
+	addr := strArg(c, "metrics-addr", "")
+	if len(addr) == 0 {
+		return nil
+	}
+
+	registry = metrics.New()
+	go func() {
+		if err := registry.ListenAndServe(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "Metrics server on %s failed: %v\n", addr, err)
+		}
+	}()
+	return nil
+}
+
+// lockMetrics returns the metrics registry as lock.Metrics, nil if not enabled
+func lockMetrics() lock.Metrics {
+	if registry == nil {
+		return nil
+	}
+	return registry
+}
+
+func acquireCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "acquire",
+		Usage: "Acquire the lock",
+		Flags: append(acquireFlags(),
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report whether the lock could be obtained now, and its queue",
+			},
+
+			&cli.StringFlag{
+				Name:  "token-file",
+				Usage: "Also write the lock ID, then its name, directory, path and metadata as key=value lines, to this file",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			cfg, err := acquireConfig(c)
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("dry-run") {
+				return dryRun(cfg)
+			}
+
+			lck, err := acquireLock(c, cfg)
+			if err != nil {
+				return err
+			}
+
+			// the lock is useless to the caller without its token
+			if path := strArg(c, "token-file", ""); len(path) > 0 {
+				if err := writeTokenFile(path, lck, cfg.Name, cfg.Dir); err != nil {
+					lck.ForceRelease()
+					return err
+				}
+			}
+
+			fmt.Print(lck.ID())
+			return nil
+		},
+	}
+}
+
+// acquireFlags returns the flags of the commands acquiring a lock
+func acquireFlags() []cli.Flag {
+	return append(locknameFlags(),
+		lockdirFlag(),
+		&cli.StringFlag{
+			Name:        "poll-interval",
+			Aliases:     []string{"i", "lock.poll"},
+			Usage:       "Poll interval between lock checks, e.g. 500ms or 30s (plain numbers are secs)",
+			DefaultText: lock.DefaultPollTime.String(),
+		},
+
+		&cli.StringFlag{
+			Name:        "max-wait",
+			Usage:       "Maximum time to wait for lock, e.g. 90s or 5m (plain numbers are secs), 0 to fail at once if not available, or forever",
+			Aliases:     []string{"w", "lock.max-wait"},
+			DefaultText: lock.DefaultMaxWait.String(),
+		},
+
+		&cli.StringFlag{
+			Name:  "request-ttl",
+			Usage: "Ignore and remove the requests of waiters not seen alive for this long, e.g. 10m (well above the poll interval)",
+		},
+
+		&cli.StringFlag{
+			Name:  "ttl",
+			Usage: "Let the lock expire, and be removed by waiters, this long after it was last renewed or touched, e.g. 10m",
+		},
+
+		&cli.IntFlag{
+			Name:  "skip-after-polls",
+			Usage: "Skip in the queue the requests of waiters not seen alive for this many poll intervals",
+		},
+
+		&cli.BoolFlag{
+			Name:  "backoff",
+			Usage: "Retry with an exponential backoff from 1s up to the poll interval, instead of at the poll interval",
+		},
+
+		&cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "Metadata to store in the lock, as key=value (repeatable)",
+		},
+		keyFileFlag(),
+		signingKeyFileFlag(),
+
+		&cli.BoolFlag{
+			Name:  "audit",
+			Usage: "Record lock events in an audit log in the lock directory",
+		},
+
+		&cli.BoolFlag{
+			Name:  "hierarchical",
+			Usage: "Make the lock conflict with locks on its parent and child names (e.g. a/b with a and a/b/c)",
+		},
+
+		&cli.StringFlag{
+			Name:  "notify-after",
+			Usage: "Send an alert with the holders and queue depth if the lock is waited for longer than this, e.g. 10m",
+		},
+		&cli.StringFlag{
+			Name:    "notify-slack",
+			Usage:   "The Slack incoming webhook URL the --notify-after alert is posted to",
+			EnvVars: []string{"LOCK_SLACK_WEBHOOK"},
+		},
+		&cli.StringFlag{
+			Name:  "notify-smtp",
+			Usage: "The host:port of the SMTP server the --notify-after alert is e-mailed through, authenticating as $LOCK_SMTP_USER with $LOCK_SMTP_PASSWORD if set",
+		},
+		&cli.StringFlag{
+			Name:  "notify-from",
+			Usage: "The sender of the --notify-after e-mail",
+			Value: "lock@localhost",
+		},
+		&cli.StringSliceFlag{
+			Name:  "notify-to",
+			Usage: "A recipient of the --notify-after e-mail (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:        "order-by",
+			Usage:       "Order the queue by the client creation time (client) or the file server time (fs)",
+			DefaultText: "client",
+		},
+
+		&cli.StringFlag{
+			Name:  "liveness-url",
+			Usage: "Remove locks whose holder node does not answer 2xx on this URL, e.g. http://{node}:8080/healthz",
+		},
+
+		&cli.StringFlag{
+			Name:  "liveness-cmd",
+			Usage: "Remove locks for which this command, e.g. \"ssh {node} test -e /run/job-{id}\", exits with 1",
+		},
+		&cli.StringFlag{
+			Name:  "takeover-after",
+			Usage: "Take over the lock when first in queue if its holder did not renew it, nor was found alive by the liveness check, for this long, e.g. 10m",
+		},
+		&cli.BoolFlag{
+			Name:  "preflight",
+			Usage: "Check the lock directory is writable before queuing, failing at once if not",
+		},
+		&cli.StringFlag{
+			Name:  "expect-fs",
+			Usage: "The comma-separated expected filesystem types of the lock directory, e.g. nfs,cifs, checked before queuing",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-free-mb",
+			Usage: "The free space, in MiB, needed in the lock directory, checked before queuing",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-free-inodes",
+			Usage: "The free inodes needed in the lock directory, checked before queuing",
+		},
+
+		&cli.IntFlag{
+			Name:        "entry-format",
+			Usage:       "Format of the written lock files: 2, or 1 while older clients share the directory",
+			DefaultText: fmt.Sprintf("%d", lock.DefaultEntryFormat),
+		},
+
+		&cli.IntFlag{
+			Name:  "max-queue",
+			Usage: "Fail at once if this many requests are already pending on the lock",
+		},
+		&cli.IntFlag{
+			Name:        "max-locks",
+			Usage:       "Number of locks which can be held at the same time on the name",
+			DefaultText: fmt.Sprintf("%d", lock.DefaultMaxLocks),
+		},
+
+		&cli.StringFlag{
+			Name:        "protocol",
+			Usage:       "Make the lock creation exclusive by checking then creating (create) or with an NFS-safe mutex taken by hard link (hardlink) or mkdir (mkdir)",
+			DefaultText: "create",
+		},
+
+		&cli.IntFlag{
+			Name:  "priority",
+			Usage: "Priority of the request, higher priorities are served first",
+		},
+
+		&cli.BoolFlag{
+			Name:  "shared",
+			Usage: "Take the lock in shared (read) mode, held concurrently by the shared holders and excluding the exclusive ones",
+		},
+
+		&cli.BoolFlag{
+			Name:  "exclusive",
+			Usage: "Take the lock in exclusive (write) mode, the default",
+		},
+
+		&cli.StringFlag{
+			Name:        "file-mode",
+			Usage:       "Octal permission of the created lock files, whatever the umask",
+			DefaultText: fmt.Sprintf("%o", lock.DefaultFileMode),
+		},
+
+		&cli.StringFlag{
+			Name:        "dir-mode",
+			Usage:       "Octal permission of the created lock directories, whatever the umask",
+			DefaultText: fmt.Sprintf("%o", lock.DefaultDirMode),
+		},
+
+		&cli.BoolFlag{
+			Name:  "show-position",
+			Usage: "Report the position in the queue on stderr while waiting",
+		},
+	)
+}
+
+// acquireConfig returns the configuration of the lock given by the acquireFlags
+func acquireConfig(c *cli.Context) (*lock.Configuration, error) {
+	meta, err := metaArg(c, "meta")
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := cipherArg(c)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := signingKeyArg(c)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := lockName(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ordering, err := orderingArg(c, "order-by")
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("shared") && c.Bool("exclusive") {
+		return nil, usageError("Please give only one of --shared and --exclusive")
+	}
+
+	if f := intArg(c, "entry-format", int(lock.DefaultEntryFormat)); f != 1 && f != 2 {
+		return nil, usageError("Invalid --entry-format %d, expected 1 or 2", f)
+	}
+
+	protocol, err := protocolArg(c, "protocol")
+	if err != nil {
+		return nil, err
+	}
+
+	fileMode, err := modeArg(c, "file-mode")
+	if err != nil {
+		return nil, err
+	}
+
+	dirMode, err := modeArg(c, "dir-mode")
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval, err := durationArg(c, "poll-interval", lock.DefaultPollTime)
+	if err != nil {
+		return nil, err
+	}
+
+	maxWait := lock.WaitForever
+	if val := strArg(c, "max-wait", ""); val != "forever" && val != "-1" {
+		maxWait, err = durationArg(c, "max-wait", lock.DefaultMaxWait)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	requestTTL, err := durationArg(c, "request-ttl", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := durationArg(c, "ttl", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	notifyAfter, err := durationArg(c, "notify-after", 0)
+	if err != nil {
+		return nil, err
+	}
+	takeoverAfter, err := durationArg(c, "takeover-after", 0)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := notifierArg(c)
+	if err != nil {
+		return nil, err
+	}
+	if notifyAfter > 0 && notifier == nil {
+		return nil, usageError("--notify-after needs --notify-slack or --notify-smtp")
+	}
+
+	cfg := &lock.Configuration{
+		Dir:            strArg(c, "dir", lock.DefaultDir),
+		Name:           name,
+		PollInterval:   pollInterval,
+		MaxWait:        maxWait,
+		Priority:       c.Int("priority"),
+		Shared:         c.Bool("shared"),
+		Ordering:       ordering,
+		Protocol:       protocol,
+		MaxLocks:       intArg(c, "max-locks", lock.DefaultMaxLocks),
+		MaxQueue:       c.Int("max-queue"),
+		RequestTTL:     requestTTL,
+		TTL:            ttl,
+		SkipAfterPolls: c.Int("skip-after-polls"),
+		EntryFormat:    lock.EntryFormat(intArg(c, "entry-format", int(lock.DefaultEntryFormat))),
+		Hierarchical:   c.Bool("hierarchical"),
+		Audit:          c.Bool("audit"),
+		Metadata:       meta,
+		Cipher:         cipher,
+		SigningKey:     signingKey,
+		FileMode:       fileMode,
+		DirMode:        dirMode,
+		Metrics:        lockMetrics(),
+		Logger:         eventLogger,
+		FS:             dirFS(),
+		FSProfile:      fsProfile,
+		EventLog:       eventLog,
+		Journal:        journalFile,
+		Webhooks:       webhooks,
+		EventSinks:     eventSinks,
+		NotifyAfter:    notifyAfter,
+		TakeoverAfter:  takeoverAfter,
+		Notifier:       notifier,
+	}
+
+	if url := strArg(c, "liveness-url", ""); len(url) > 0 {
+		cfg.Liveness = lock.HTTPChecker{URL: url}
+	}
+	if cmd := strArg(c, "liveness-cmd", ""); len(cmd) > 0 {
+		cfg.Liveness = lock.CommandChecker{Command: strings.Fields(cmd)}
+	}
+
+	preflight := lock.Preflight{
+		MinFreeBytes:  c.Uint64("min-free-mb") << 20,
+		MinFreeInodes: c.Uint64("min-free-inodes"),
+	}
+	if types := strArg(c, "expect-fs", ""); len(types) > 0 {
+		preflight.FSTypes = strings.Split(types, ",")
+	}
+	if c.Bool("preflight") || len(preflight.FSTypes) > 0 || preflight.MinFreeBytes > 0 || preflight.MinFreeInodes > 0 {
+		cfg.Preflight = &preflight
+	}
+
+	if c.Bool("backoff") {
+		cfg.Retry = lock.ExponentialBackoff{
+			Initial: time.Second,
+			Max:     cfg.PollInterval,
+			Jitter:  0.2,
+		}
+	}
+	return cfg, nil
+}
+
+// acquireLock acquires the lock, reporting the position in the queue while
+// waiting, and removing the request if interrupted
+func acquireLock(c *cli.Context, cfg *lock.Configuration) (*lock.Lock, error) {
+	// remove the pending request rather than leave it blocking the queue
+	cancel := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		close(cancel)
+	}()
+	cfg.Cancel = cancel
+
+	if c.Bool("show-position") {
+		cfg.OnWaiting = func(pos int) {
+			fmt.Fprintf(os.Stderr, "Position in queue: %d\n", pos)
+		}
+	}
+
+	// the progress line is cleared before anything else is printed
+	var prog *progress
+	if !c.Bool("show-position") {
+		prog = startProgress(cfg.Dir, cfg.Name)
+		cfg.OnWaiting = prog.setPosition
+	}
+
+	// the queue changes while waiting, not only once acquired
+	if onWaiting, last := cfg.OnWaiting, 0; len(textfile) > 0 {
+		cfg.OnWaiting = func(pos int) {
+			if onWaiting != nil {
+				onWaiting(pos)
+			}
+			if pos != last {
+				last = pos
+				updateTextfile(c)
+			}
+		}
+	}
+
+	lck, err := lock.Acquire(cfg)
+	prog.Stop()
+	return lck, err
+}
+
+func dryRun(cfg *lock.Configuration) error {
+	report, err := lock.DryRun(cfg)
+	if err != nil {
+		return err
+	}
+
+	if report.Available {
+		fmt.Printf("Lock %s is available\n", report.Name)
+	} else {
+		fmt.Printf("Lock %s is not available, a request would be at position %d\n", report.Name, report.Position)
+	}
+
+	for _, i := range report.Holders {
+		printInfo("lock", i)
+	}
+	for _, i := range report.Queue {
+		printInfo("request", i)
+	}
+	return nil
+}
+
+func deleteCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "delete",
+		Aliases:   []string{"release"},
+		Usage:     "Delete the lock, or with --all every lock and request of a name",
+		ArgsUsage: "<id>|-",
+		Flags: append(locknameFlags(),
+			lockdirFlag(),
+			keyFileFlag(),
+			signingKeyFileFlag(),
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Delete the lock even if owned by another user or wrongly signed",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Delete every lock and request of the --name, whoever holds them, e.g. to unwedge a queue",
+			},
+			&cli.StringFlag{
+				Name:  "token-file",
+				Usage: "Read the lock ID, and its directory unless --dir is given, from this file written by acquire --token-file",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			lockdir := strArg(c, "dir", lock.DefaultDir)
+			if c.Bool("all") {
+				return releaseAll(c, lockdir)
+			}
+
+			id, err := releaseID(c, &lockdir)
+			if err != nil {
+				return err
+			}
+
+			// the metadata holds the owner and signature
+			if err := setKeys(c); err != nil {
+				return err
+			}
+
+			lck, err := lock.WithID(id, lockdir)
+			if err != nil {
+				return fmt.Errorf("Failed to find lock with ID %s, cannot delete", id)
+			}
+
+			release := lck.Release
+			if c.Bool("force") {
+				release = lck.ForceRelease
+			}
+
+			if err := release(); err != nil {
+				return fmt.Errorf("Unable to remove lock %s: %v", lck.Path(), err)
+			}
+			return nil
+		},
+	}
+}
+
+// releaseID returns the ID of the lock to release, given as argument, or
+// read from stdin with - or from the --token-file, which also gives the
+// lock directory if not set on the command line
+func releaseID(c *cli.Context, lockdir *string) (string, error) {
+	path := strArg(c, "token-file", "")
+	switch {
+	case len(path) > 0 && c.Args().Len() > 0:
+		return "", usageError("Please give either the lock UUID or --token-file, not both")
+	case len(path) == 0 && c.Args().Len() != 1:
+		return "", usageError("Please give one argument: the UUID of the lock, or - to read it from stdin")
+	case len(path) == 0 && c.Args().First() != "-":
+		return c.Args().First(), nil
+	case len(path) == 0:
+		path = "-"
+	}
+
+	id, fields, err := readToken(path)
+	if err != nil {
+		return "", err
+	}
+	if dir, ok := fields["dir"]; ok && !c.IsSet("dir") {
+		*lockdir = dir
+	}
+	return id, nil
+}
+
+func releaseAll(c *cli.Context, lockdir string) error {
+	if c.Args().Len() > 0 {
+		return usageError("Please give either the lock UUID or --all, not both")
+	}
+
+	named := false
+	for _, f := range []string{"name", "name-from-path", "name-from-git", "name-from-url"} {
+		named = named || c.IsSet(f)
+	}
+	if !named {
+		return usageError("Please give the name of the locks to delete with --all")
+	}
+
+	name, err := lockName(c)
+	if err != nil {
+		return err
+	}
+
+	removed, err := lock.ReleaseAll(name, lockdir)
+	fmt.Printf("Removed %d lock(s) and request(s) of %s\n", removed, name)
+	return err
+}
+
+func existsCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "exists",
+		Usage:     "Exit with 0 if a lock with the name, or the given ID, is held, else 1",
+		ArgsUsage: "[<id>]",
+		Flags: append(locknameFlags(),
+			lockdirFlag(),
+			&cli.BoolFlag{
+				Name:  "print",
+				Usage: "Print the locks found",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			dir := strArg(c, "dir", lock.DefaultDir)
+
+			var found []lock.Info
+			switch c.Args().Len() {
+			case 0:
+				name, err := lockName(c)
+				if err != nil {
+					return err
+				}
+				found = lock.Held(dir, name)
+			case 1:
+				id := c.Args().First()
+				for _, i := range lock.List(dir) {
+					if i.ID == id {
+						found = append(found, i)
+					}
+				}
+			default:
+				return usageError("Please give at most one argument: the ID of the lock")
+			}
+
+			if c.Bool("print") {
+				for _, i := range found {
+					printInfo("lock", i)
+				}
+			}
+			if len(found) == 0 {
+				return &silentErr{}
+			}
+			return nil
+		},
+	}
+}
+
+func verifyCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "verify",
+		Usage: "Report the lock and request files not following the naming scheme, exiting with 1 if any",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			dir := strArg(c, "dir", lock.DefaultDir)
+
+			errs := lock.Malformed(dir)
+			for _, err := range errs {
+				if quiet {
+					break
+				}
+				fmt.Println(err)
+			}
+			if len(errs) > 0 {
+				return &silentErr{}
+			}
+			return nil
+		},
+	}
+}
+
+func recoverCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "recover",
+		Usage: "Roll back the acquisitions and complete the releases left in the --journal by the processes which are not running anymore",
+		Action: func(c *cli.Context) error {
+			if len(journalFile) == 0 {
+				return usageError("Please give the journal to recover with --journal")
+			}
+
+			recovered, err := lock.Recover()
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, r := range recovered {
+				action := r.Action
+				if r.Err != nil {
+					action = fmt.Sprintf("failed: %v", r.Err)
+					failed++
+				}
+				if !quiet {
+					fmt.Printf("%-8s %s: %s\n", r.Op, r.Path, action)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to recover %d operation(s), kept in the journal", failed)
+			}
+			return nil
+		},
+	}
+}
+
+func renewCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "renew",
+		Usage: "Renew a held lock, signalling its holder is still alive, and release it if it loses a split brain (more locks held than allowed)",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			keyFileFlag(),
+			signingKeyFileFlag(),
+			&cli.IntFlag{
+				Name:        "max-locks",
+				Usage:       "Number of locks which can be held at the same time on the name",
+				DefaultText: fmt.Sprintf("%d", lock.DefaultMaxLocks),
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return usageError("Please give one argument: the UUID of the lock")
+			}
+
+			if err := setKeys(c); err != nil {
+				return err
+			}
+
+			lock.SetMaxLocks(intArg(c, "max-locks", lock.DefaultMaxLocks))
+			lck, err := lock.Attach(c.Args().First(), strArg(c, "dir", lock.DefaultDir))
+			if err != nil {
+				return err
+			}
+
+			if err := lck.Renew(); err != nil {
+				return err
+			}
+			return lck.Resolve()
+		},
+	}
+}
+
+func touchCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "touch",
+		Usage:     "Signal the holder of a lock is alive, pushing back its expiry if it has one",
+		ArgsUsage: "<id>",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			keyFileFlag(),
+			signingKeyFileFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return usageError("Please give one argument: the UUID of the lock")
+			}
+
+			// the expiry is rewritten with the metadata, which may be encrypted
+			if err := setKeys(c); err != nil {
+				return err
+			}
+
+			lck, err := lock.Attach(c.Args().First(), strArg(c, "dir", lock.DefaultDir))
+			if err != nil {
+				return err
+			}
+
+			return lck.Touch()
+		},
+	}
+}
+
+func statsCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: "Show lock hold time and handoff latency statistics",
+		Flags: append(locknameFlags(),
+			lockdirFlag(),
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Summarize every lock name: holders, waiters, and hold time and contention over the --window, from the audit logs",
+			},
+			&cli.StringFlag{
+				Name:        "window",
+				Usage:       "Summarize the audit log over this period, e.g. 24h or 168h (plain numbers are secs), for the --name if given else all",
+				DefaultText: "24h",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.Bool("all") || c.IsSet("window") {
+				return summarize(c)
+			}
+
+			name, err := lockName(c)
+			if err != nil {
+				return err
+			}
+
+			stats, err := lock.ReadStats(strArg(c, "dir", lock.DefaultDir), name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Lock: %s\n", name)
+			printLatency("Hold time", stats.Hold)
+			printLatency("Handoff latency", stats.Handoff)
+			return nil
+		},
+	}
+}
+
+// summarize prints the Summary of the lock names, or of the given name only
+func summarize(c *cli.Context) error {
+	window, err := durationArg(c, "window", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	dir := strArg(c, "dir", lock.DefaultDir)
+	var summaries []lock.Summary
+	if c.Bool("all") {
+		summaries, err = lock.Summarize(dir, window)
+	} else {
+		var name string
+		if name, err = lockName(c); err == nil {
+			var s lock.Summary
+			s, err = lock.SummarizeName(dir, name, window)
+			summaries = append(summaries, s)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-24s %-8s %-8s %-9s %-9s %-11s %-10s %s\n",
+		"NAME", "HOLDERS", "WAITERS", "ACQUIRED", "TIMEOUTS", "CONTENTION", "HOLD MEAN", "HOLD P95")
+	for _, s := range summaries {
+		fmt.Printf("%-24s %-8d %-8d %-9d %-9d %-11s %-10v %v\n",
+			s.Name, s.Holders, s.Waiters, s.Acquisitions, s.Timeouts,
+			fmt.Sprintf("%.0f%%", s.Contention*100), s.HoldMean.Round(time.Second), s.HoldP95.Round(time.Second))
+	}
+	return nil
+}
+
+func statusCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Show the current state of a lock",
+		Flags: append(locknameFlags(), lockdirFlag(), formatFlag()),
+		Action: func(c *cli.Context) error {
+			tmpl, err := formatArg(c)
+			if err != nil {
+				return err
+			}
+
+			name, err := lockName(c)
+			if err != nil {
+				return err
+			}
+
+			dir := strArg(c, "dir", lock.DefaultDir)
+			wait, err := lock.EstimateWait(dir, name)
+			if err != nil {
+				return err
+			}
+
+			if tmpl != nil {
+				status := lockStatus{Name: name, EstimatedWait: wait}
+				for _, i := range lock.Held(dir, name) {
+					status.Held = append(status.Held, newEntryRow("lock", i))
+				}
+				return printFormatted(tmpl, status)
+			}
+
+			fmt.Printf("Lock: %s\n", name)
+			if wait == 0 {
+				fmt.Println("Estimated wait: unknown or none")
+			} else {
+				fmt.Printf("Estimated wait: %v\n", wait.Round(time.Second))
+			}
+			return nil
+		},
+	}
+}
+
+func reportCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "report",
+		Usage: "Report lock usage across the lock directory",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.BoolFlag{
+				Name:  "by-owner",
+				Usage: "Group currently held locks and past hold time by owner node",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Bool("by-owner") {
+				return usageError("Please choose a report type, e.g. --by-owner")
+			}
+
+			reports, err := lock.ReportByOwner(strArg(c, "dir", lock.DefaultDir))
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%-30s %-8s %-14s %s\n", "OWNER", "HOLDS", "HOLD TIME", "HELD NOW")
+			for _, r := range reports {
+				fmt.Printf("%-30s %-8d %-14v %s\n",
+					r.Node, r.HoldCount, r.HoldTime.Round(time.Second), strings.Join(r.Held, ","))
+			}
+			return nil
+		},
+	}
+}
+
+func historyCmd() *cli.Command {
 	return &cli.Command{
-		Name:  "acquire",
-		Usage: "Acquire the lock",
+		Name:      "history",
+		Usage:     "Show the audit log of a lock",
+		ArgsUsage: "<name>",
 		Flags: []cli.Flag{
 			lockdirFlag(),
-			locknameFlag(),
-			&cli.IntFlag{
-				Name:        "poll-interval",
-				Aliases:     []string{"i", "lock.poll"},
-				Usage:       "Poll interval between lock checks, in secs",
-				DefaultText: fmt.Sprintf("%d", lock.DefaultPollTime),
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return usageError("Please give one argument: the name of the lock")
+			}
+
+			records, err := lock.History(strArg(c, "dir", lock.DefaultDir), c.Args().First())
+			if err != nil {
+				return err
+			}
+
+			for _, r := range records {
+				detail := r.Detail
+				if r.Waited > 0 {
+					detail = strings.TrimSpace(fmt.Sprintf("waited %v %s", r.Waited.Round(time.Millisecond), detail))
+				}
+				fmt.Printf("%s %-8s %-32s %s@%s[%d] %s\n",
+					r.Time.Local().Format(time.RFC3339), r.Event, r.ID, r.User, r.Node, r.PID, detail)
+			}
+			return nil
+		},
+	}
+}
+
+func listCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List the held locks and pending requests",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			formatFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			dir := strArg(c, "dir", lock.DefaultDir)
+
+			tmpl, err := formatArg(c)
+			if err != nil {
+				return err
+			}
+			if tmpl != nil {
+				return listFormatted(tmpl, dir)
+			}
+
+			fmt.Printf("%-8s %-32s %-24s %-20s %-8s %s\n", "TYPE", "ID", "NAME", "NODE", "AGE", "TTL")
+			for _, i := range lock.List(dir) {
+				printInfo("lock", i)
+			}
+			for _, i := range lock.ListRequests(dir) {
+				printInfo("request", i)
+			}
+			for _, err := range lock.Malformed(dir) {
+				if quiet {
+					break
+				}
+				fmt.Fprintf(os.Stderr, "Ignored: %v\n", err)
+			}
+			return nil
+		},
+	}
+}
+
+func listFormatted(tmpl *template.Template, dir string) error {
+	var rows []entryRow
+	for _, i := range lock.List(dir) {
+		rows = append(rows, newEntryRow("lock", i))
+	}
+	for _, i := range lock.ListRequests(dir) {
+		rows = append(rows, newEntryRow("request", i))
+	}
+
+	for _, row := range rows {
+		if err := printFormatted(tmpl, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printInfo(kind string, i lock.Info) {
+	ttl := "-"
+	if i.Expiry != nil {
+		ttl = ttlRemaining(*i.Expiry).String()
+	}
+	fmt.Printf("%-8s %-32s %-24s %-20s %-8v %s\n",
+		kind, i.ID, i.Name, i.Node, time.Since(i.Created).Round(time.Second), ttl)
+}
+
+// ttlRemaining returns the time left until the expiry, 0 if lapsed
+func ttlRemaining(expiry time.Time) time.Duration {
+	return max(time.Until(expiry).Round(time.Second), 0)
+}
+
+func serveCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve the locks of the lock directory over HTTP, including the state locking of the Terraform HTTP backend at /terraform/<state>, optionally replicated among servers by Raft",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "The address to serve HTTP on",
+				Value: ":8080",
+			},
+			&cli.StringFlag{
+				Name:  "grpc-addr",
+				Usage: "The address to serve gRPC on, if any",
+			},
+			&cli.StringFlag{
+				Name:  "raft-id",
+				Usage: "Keep the lock directory in a Raft group with the other servers instead of a filesystem, as the node of this ID",
+			},
+			&cli.StringFlag{
+				Name:  "raft-addr",
+				Usage: "The host:port of this node for the other Raft nodes",
+			},
+			&cli.StringSliceFlag{
+				Name:  "raft-peer",
+				Usage: "A node of the Raft group, as id=host:port, used to bootstrap it (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "raft-dir",
+				Usage: "The directory holding the Raft log and snapshots of this node",
+				Value: "/var/lib/lock/raft",
+			},
+			&cli.StringFlag{
+				Name:  "raft-secret-file",
+				Usage: "File containing the secret shared by the Raft nodes, authenticating their connections",
+			},
+			&cli.StringFlag{
+				Name:  "auth-token-file",
+				Usage: "File containing the token the clients must send, as a bearer token or the password of basic authentication",
+			},
+			&cli.BoolFlag{
+				Name:  "no-auth",
+				Usage: "Serve without authentication, any client reaching the server being able to take locks",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			token, err := serveToken(c)
+			if err != nil {
+				return err
+			}
+
+			dir := strArg(c, "dir", lock.DefaultDir)
+			protocol := lock.DefaultConfig().Protocol
+			if len(c.String("raft-id")) > 0 {
+				if err := openRaft(c); err != nil {
+					return err
+				}
+				protocol = lock.ProtocolMkdir
+			}
+
+			errs := make(chan error, 2)
+			if addr := strArg(c, "grpc-addr", ""); len(addr) > 0 {
+				srv := lockgrpc.NewServer(dir)
+				srv.Token = token
+				srv.Config.Metrics = lockMetrics()
+				srv.Config.EventLog = eventLog
+				srv.Config.Journal = journalFile
+				srv.Config.Webhooks = webhooks
+				srv.Config.EventSinks = eventSinks
+				srv.Config.FS = dirFS()
+				srv.Config.Protocol = protocol
+				go func() {
+					errs <- fmt.Errorf("gRPC server: %v", srv.ListenAndServe(addr))
+				}()
+			}
+
+			srv := server.New(dir)
+			srv.Token = token
+			srv.Config.Metrics = lockMetrics()
+			srv.Config.EventLog = eventLog
+			srv.Config.Journal = journalFile
+			srv.Config.Webhooks = webhooks
+			srv.Config.EventSinks = eventSinks
+			srv.Config.FS = dirFS()
+			srv.Config.Protocol = protocol
+			go func() {
+				errs <- fmt.Errorf("HTTP server: %v", srv.ListenAndServe(c.String("addr")))
+			}()
+
+			return <-errs
+		},
+	}
+}
+
+// serveToken returns the token the clients of lock serve must send, empty
+// with --no-auth, which must be asked explicitly
+func serveToken(c *cli.Context) (string, error) {
+	path := c.String("auth-token-file")
+	switch {
+	case len(path) > 0 && c.Bool("no-auth"):
+		return "", usageError("Please give only one of --auth-token-file and --no-auth")
+	case len(path) > 0:
+		return readSecretFile(path, "auth token")
+	case c.Bool("no-auth"):
+		return "", nil
+	default:
+		return "", usageError("Please give the token of the clients with --auth-token-file, or --no-auth to serve without authentication")
+	}
+}
+
+func daemonCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "Serve the locks of the lock directory to local processes on a Unix socket",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:  "socket",
+				Usage: "The path of the Unix socket, unless one is passed by systemd socket activation",
+				Value: "/run/lock.sock",
 			},
+		},
+		Action: func(c *cli.Context) error {
+			srv := server.New(strArg(c, "dir", lock.DefaultDir))
+			srv.Config.Metrics = lockMetrics()
+			srv.Config.EventLog = eventLog
+			srv.Config.Journal = journalFile
+			srv.Config.Webhooks = webhooks
+			srv.Config.EventSinks = eventSinks
 
-			&cli.IntFlag{
-				Name:        "max-wait",
-				Usage:       "Maximum time to wait for lock, in secs",
-				Aliases:     []string{"w", "lock.max-wait"},
-				DefaultText: fmt.Sprintf("%d", lock.DefaultMaxWait),
+			// prefer a socket passed by systemd socket activation
+			listeners, err := systemd.Listeners()
+			if err != nil {
+				return err
+			}
+
+			var lis net.Listener
+			if len(listeners) > 0 {
+				lis = listeners[0]
+			} else if lis, err = server.ListenUnix(c.String("socket")); err != nil {
+				return err
+			}
+			defer lis.Close()
+
+			stop := make(chan struct{})
+			defer close(stop)
+			systemd.Notify("READY=1")
+			systemd.Watchdog(stop)
+
+			return srv.Serve(lis)
+		},
+	}
+}
+
+func watchCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "watch",
+		Usage: "Stream the events of the lock directory",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Time between scans of the lock directory",
+				Value: time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  "stale-after",
+				Usage: "Report held locks not renewed for this long as stale",
+			},
+			&cli.StringFlag{
+				Name:  "lock",
+				Usage: "Only watch the locks held on this name, reporting their renewals too",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the events as a stream of JSON objects",
 			},
 		},
 		Action: func(c *cli.Context) error {
-			lck, err := lock.Acquire(&lock.Configuration{
-				Dir:          strArg(c, "dir", lock.DefaultDir),
-				Name:         strArg(c, "name", lock.DefaultName),
-				PollInterval: intArg(c, "poll-interval", lock.DefaultPollTime),
-				MaxWait:      intArg(c, "max-wait", lock.DefaultMaxWait),
-			})
+			stop := make(chan struct{})
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigs
+				close(stop)
+			}()
 
-			if err == nil {
-				fmt.Print(lck.ID)
+			dir := strArg(c, "dir", lock.DefaultDir)
+			opts := lock.WatchOptions{
+				Interval:   c.Duration("interval"),
+				StaleAfter: c.Duration("stale-after"),
 			}
 
-			return err
+			var events <-chan lock.Event
+			if name := strArg(c, "lock", ""); len(name) > 0 {
+				if err := lock.ValidateName(name); err != nil {
+					return err
+				}
+				events = lock.WatchLock(dir, name, opts, stop)
+			} else {
+				events = lock.Watch(dir, opts, stop)
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			for ev := range events {
+				if c.Bool("json") {
+					if err := enc.Encode(ev); err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Printf("%s %-9s %-24s %-32s %s\n",
+					ev.Time.Format(time.RFC3339), ev.Type, ev.Name, ev.ID, ev.Node)
+			}
+			return nil
 		},
 	}
 }
 
-func deleteCmd() *cli.Command {
+func breakCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "break",
+		Usage: "Forcibly remove a lock held by someone else",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:     "id",
+				Usage:    "The ID of the lock to break",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "reason",
+				Usage: "Why the lock is broken, recorded in the audit log",
+			},
+			&cli.BoolFlag{
+				Name:  "tombstone",
+				Usage: "Leave a tombstone so that the holder can find out its lock was broken",
+			},
+			&cli.BoolFlag{
+				Name:  "yes",
+				Usage: "Confirm the lock should be broken",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if !c.Bool("yes") {
+				return usageError("Breaking a lock held by someone else is dangerous, please confirm with --yes")
+			}
+
+			return lock.Break(strArg(c, "dir", lock.DefaultDir), c.String("id"), c.String("reason"), c.Bool("tombstone"))
+		},
+	}
+}
+
+func infoCmd() *cli.Command {
 	return &cli.Command{
-		Name:  "delete",
-		Usage: "Delete the lock",
+		Name:      "info",
+		Usage:     "Show the details of a lock or request",
+		ArgsUsage: "<id>",
 		Flags: []cli.Flag{
 			lockdirFlag(),
+			keyFileFlag(),
+			signingKeyFileFlag(),
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the details as JSON",
+			},
+			formatFlag(),
 		},
 		Action: func(c *cli.Context) error {
-			lockdir := strArg(c, "dir", lock.DefaultDir)
 			if c.Args().Len() != 1 {
-				return fmt.Errorf("Please give one argument: the UUID of the lock")
+				return usageError("Please give one argument: the ID of the lock or request")
 			}
 
-			id := c.Args().First()
-			lck, err := lock.WithID(id, lockdir)
+			tmpl, err := formatArg(c)
 			if err != nil {
-				return fmt.Errorf("Failed to find lock with ID %s, cannot delete", id)
+				return err
 			}
 
-			if err := lck.Remove(); err != nil {
-				return fmt.Errorf("Unable to remove lock %s: %v", lck.Path(), err)
+			// the cipher, if any, is needed to read the metadata
+			if err := setKeys(c); err != nil {
+				return err
+			}
+
+			d, err := lock.Inspect(strArg(c, "dir", lock.DefaultDir), c.Args().First())
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("json") {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(d)
+			}
+
+			if tmpl != nil {
+				return printFormatted(tmpl, d)
+			}
+
+			row := func(k string, v interface{}) { fmt.Printf("%-14s %v\n", k+":", v) }
+			row("Type", d.Type)
+			row("Name", d.Name)
+			row("Node", d.Node)
+			row("ID", d.ID)
+			row("Created", d.Created.Format(time.RFC3339))
+			row("Age", d.Age.Round(time.Second))
+			row("Last renewed", d.LastRenewed.Format(time.RFC3339))
+			row("Path", d.Path)
+			if d.Format > 0 {
+				row("Format", fmt.Sprintf("v%d", d.Format))
+			}
+			if d.PID > 0 {
+				alive := "unknown (other node)"
+				if d.PIDAlive != nil {
+					alive = fmt.Sprintf("%v", *d.PIDAlive)
+				}
+				row("PID", fmt.Sprintf("%d (alive: %s)", d.PID, alive))
+			}
+			if len(d.SignatureError) > 0 {
+				row("Signature", d.SignatureError)
+			}
+			if len(d.MetadataError) > 0 {
+				row("Metadata", d.MetadataError)
+			}
+			keys := make([]string, 0, len(d.Metadata))
+			for k := range d.Metadata {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				row("  "+k, d.Metadata[k])
 			}
 			return nil
 		},
 	}
 }
 
+func exportCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export the state of the lock directory as JSON",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "The file to write to, stdout if not given",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			snap, err := lock.Export(strArg(c, "dir", lock.DefaultDir))
+			if err != nil {
+				return err
+			}
+
+			data, err := json.MarshalIndent(snap, "", "  ")
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+
+			if path := strArg(c, "output", ""); len(path) > 0 {
+				return os.WriteFile(path, data, 0664)
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+}
+
+func importCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Import a JSON export into the lock directory",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "The file to read from, stdin if not given",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			in := os.Stdin
+			if path := strArg(c, "input", ""); len(path) > 0 {
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+
+			var snap lock.Snapshot
+			if err := json.NewDecoder(in).Decode(&snap); err != nil {
+				return fmt.Errorf("Invalid export: %v", err)
+			}
+			return lock.Import(strArg(c, "dir", lock.DefaultDir), &snap)
+		},
+	}
+}
+
+func printLatency(label string, stats lock.LatencyStats) {
+	fmt.Printf("%s: %d samples\n", label, stats.Count)
+	if stats.Count > 0 {
+		fmt.Printf("  last %v, mean %v, min %v, max %v\n",
+			stats.Last, stats.Mean(), stats.Min, stats.Max)
+	}
+}
+
 func lockdirFlag() *cli.StringFlag {
 	return &cli.StringFlag{
 		Name:        "dir",
-		Usage:       "The directory in which to create the lock",
+		Usage:       "The directory in which to create the lock, or sftp://[user@]host[:port]/path or dav[s]://[user@]host[:port]/path on a remote host",
 		Aliases:     []string{"d"},
 		DefaultText: lock.DefaultDir,
 	}
@@ -106,12 +1564,81 @@ func locknameFlag() *cli.StringFlag {
 	}
 }
 
+// locknameFlags are the flags to give or derive the lock name, see lockName
+func locknameFlags() []cli.Flag {
+	return []cli.Flag{
+		locknameFlag(),
+		&cli.StringFlag{
+			Name:  "name-from-path",
+			Usage: "Derive the lock name from the given filesystem path",
+		},
+		&cli.StringFlag{
+			Name:  "name-from-git",
+			Usage: "Derive the lock name from the git repository containing the given directory",
+		},
+		&cli.StringFlag{
+			Name:  "name-from-url",
+			Usage: "Derive the lock name from the given URL",
+		},
+	}
+}
+
+// lockName returns the lock name given, or derived from a resource, on the command line
+func lockName(c *cli.Context) (string, error) {
+	derivers := []struct {
+		flag   string
+		derive func(string) (string, error)
+	}{
+		{"name-from-path", lock.NameFromPath},
+		{"name-from-git", lock.NameFromGitRepo},
+		{"name-from-url", lock.NameFromURL},
+	}
+
+	for _, d := range derivers {
+		if val := strArg(c, d.flag, ""); len(val) > 0 {
+			if c.IsSet("name") {
+				return "", usageError("Please give only one of --name and --%s", d.flag)
+			}
+			return d.derive(val)
+		}
+	}
+
+	return strArg(c, "name", lock.DefaultName), nil
+}
+
+func keyFileFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "key-file",
+		Usage: "File containing the hex encoded site key used to encrypt lock metadata",
+	}
+}
+
 func intArg(c *cli.Context, name string, default_ int) int {
 	if c.IsSet(name) {
 		return c.Int(name)
 	}
 	return default_
 }
+
+// durationArg parses a duration such as 30s or 5m, plain
+// numbers being seconds as in previous versions
+func durationArg(c *cli.Context, name string, default_ time.Duration) (time.Duration, error) {
+	val := strArg(c, name, "")
+	if len(val) == 0 {
+		return default_, nil
+	}
+
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, usageError("Invalid --%s %q, expected a duration such as 30s or 5m", name, val)
+	}
+	return d, nil
+}
+
 func strArg(c *cli.Context, name string, default_ string) string {
 	val := strings.TrimSpace(c.String(name))
 	if len(val) == 0 {
@@ -120,3 +1647,146 @@ func strArg(c *cli.Context, name string, default_ string) string {
 
 	return val
 }
+
+func metaArg(c *cli.Context, name string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, kv := range c.StringSlice(name) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || len(strings.TrimSpace(key)) == 0 {
+			return nil, usageError("Invalid metadata %q, expected key=value", kv)
+		}
+		meta[strings.TrimSpace(key)] = value
+	}
+	return meta, nil
+}
+
+func orderingArg(c *cli.Context, name string) (lock.Ordering, error) {
+	switch val := strArg(c, name, "client"); val {
+	case "client":
+		return lock.OrderByCreation, nil
+	case "fs":
+		return lock.OrderByFileTime, nil
+	default:
+		return 0, usageError("Invalid --%s %q, expected client or fs", name, val)
+	}
+}
+
+func protocolArg(c *cli.Context, name string) (lock.Protocol, error) {
+	switch val := strArg(c, name, "create"); val {
+	case "create":
+		return lock.ProtocolCreate, nil
+	case "hardlink":
+		return lock.ProtocolHardlink, nil
+	case "mkdir":
+		return lock.ProtocolMkdir, nil
+	default:
+		return 0, usageError("Invalid --%s %q, expected create, hardlink or mkdir", name, val)
+	}
+}
+
+func modeArg(c *cli.Context, name string) (os.FileMode, error) {
+	val := strArg(c, name, "")
+	if len(val) == 0 {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, usageError("Invalid --%s %q, expected an octal permission such as 664", name, val)
+	}
+	return os.FileMode(mode), nil
+}
+
+func signingKeyFileFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "signing-key-file",
+		Usage: "File containing the secret shared by the lock users to sign and verify the locks",
+	}
+}
+
+func signingKeyArg(c *cli.Context) ([]byte, error) {
+	path := strArg(c, "signing-key-file", "")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return lock.LoadSigningKeyFile(path)
+}
+
+// setKeys sets the cipher and signing key used to read and verify
+// entries outside of acquire
+func setKeys(c *cli.Context) error {
+	cipher, err := cipherArg(c)
+	if err != nil {
+		return err
+	}
+
+	key, err := signingKeyArg(c)
+	if err != nil {
+		return err
+	}
+
+	lock.SetCipher(cipher)
+	lock.SetSigningKey(key)
+	return nil
+}
+
+func cipherArg(c *cli.Context) (lock.Cipher, error) {
+	path := strArg(c, "key-file", "")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return lock.LoadKeyFile(path)
+}
+
+func purgeCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "purge",
+		Usage: "Delete the locks and requests of the lock directory not renewed for a while",
+		Flags: []cli.Flag{
+			lockdirFlag(),
+			&cli.StringFlag{
+				Name:     "older-than",
+				Usage:    "Delete the entries not renewed for this long, e.g. 24h (plain numbers are secs)",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "locks-only",
+				Usage: "Only delete locks",
+			},
+			&cli.BoolFlag{
+				Name:  "requests-only",
+				Usage: "Only delete requests",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only list the entries which would be deleted",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Bool("locks-only") && c.Bool("requests-only") {
+				return usageError("Please give only one of --locks-only and --requests-only")
+			}
+
+			olderThan, err := durationArg(c, "older-than", 0)
+			if err != nil {
+				return err
+			}
+
+			purged, err := lock.Purge(strArg(c, "dir", lock.DefaultDir), lock.PurgeOptions{
+				OlderThan: olderThan,
+				Locks:     c.Bool("locks-only"),
+				Requests:  c.Bool("requests-only"),
+				DryRun:    c.Bool("dry-run"),
+			})
+
+			verb := "Deleted"
+			if c.Bool("dry-run") {
+				verb = "Would delete"
+			}
+			for _, i := range purged {
+				fmt.Printf("%s %s %s (%s)\n", verb, i.ID, i.Name, i.Path)
+			}
+			return err
+		},
+	}
+}
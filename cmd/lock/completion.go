@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+// bashCompletion completes the lock names and IDs from the entries of the
+// lock directory given on the command line, and the commands and flags
+// through the --generate-bash-completion support of the cli package
+const bashCompletion = `_lock_complete() {
+  local cur prev cmd dir i
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  prev="${COMP_WORDS[COMP_CWORD-1]}"
+  cmd="${COMP_WORDS[1]}"
+
+  dir=()
+  for ((i = 1; i < COMP_CWORD; i++)); do
+    case "${COMP_WORDS[i]}" in
+      -d|--dir) dir=(--dir "${COMP_WORDS[i+1]}") ;;
+      --dir=*) dir=(--dir "${COMP_WORDS[i]#--dir=}") ;;
+    esac
+  done
+
+  case "$prev" in
+    -n|--name|--lock)
+      COMPREPLY=($(compgen -W "$(${COMP_WORDS[0]} completion names "${dir[@]}" 2>/dev/null)" -- "$cur"))
+      return 0 ;;
+    --id)
+      COMPREPLY=($(compgen -W "$(${COMP_WORDS[0]} completion ids "${dir[@]}" 2>/dev/null)" -- "$cur"))
+      return 0 ;;
+  esac
+
+  if [[ "$cur" != -* && "$prev" != -* && COMP_CWORD -gt 1 ]]; then
+    case "$cmd" in
+      delete|release|renew)
+        COMPREPLY=($(compgen -W "$(${COMP_WORDS[0]} completion ids "${dir[@]}" 2>/dev/null)" -- "$cur"))
+        return 0 ;;
+      info)
+        COMPREPLY=($(compgen -W "$(${COMP_WORDS[0]} completion ids --requests "${dir[@]}" 2>/dev/null)" -- "$cur"))
+        return 0 ;;
+    esac
+  fi
+
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "$(${COMP_WORDS[@]:0:COMP_CWORD} "$cur" --generate-bash-completion 2>/dev/null)" -- "$cur"))
+  else
+    COMPREPLY=($(compgen -W "$(${COMP_WORDS[@]:0:COMP_CWORD} --generate-bash-completion 2>/dev/null)" -- "$cur"))
+  fi
+  return 0
+}
+
+complete -o bashdefault -o default -F _lock_complete lock
+`
+
+// zshCompletion reuses the bash completion through bashcompinit
+const zshCompletion = `autoload -U +X bashcompinit && bashcompinit
+` + bashCompletion
+
+func completionCmd() *cli.Command {
+	return &cli.Command{
+		Name:   "completion",
+		Usage:  "Print the shell completion script, e.g. source <(lock completion bash)",
+		Hidden: true,
+		Subcommands: []*cli.Command{
+			{
+				Name:  "bash",
+				Usage: "Print the bash completion script",
+				Action: func(c *cli.Context) error {
+					fmt.Print(bashCompletion)
+					return nil
+				},
+			},
+			{
+				Name:  "zsh",
+				Usage: "Print the zsh completion script",
+				Action: func(c *cli.Context) error {
+					fmt.Print(zshCompletion)
+					return nil
+				},
+			},
+			{
+				Name:  "names",
+				Usage: "List the names of the locks and requests of the lock directory",
+				Flags: []cli.Flag{
+					lockdirFlag(),
+				},
+				Action: func(c *cli.Context) error {
+					dir := strArg(c, "dir", lock.DefaultDir)
+
+					seen := map[string]bool{}
+					for _, i := range append(lock.List(dir), lock.ListRequests(dir)...) {
+						seen[i.Name] = true
+					}
+
+					names := make([]string, 0, len(seen))
+					for name := range seen {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+
+					for _, name := range names {
+						fmt.Println(name)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "ids",
+				Usage: "List the IDs of the locks of the lock directory",
+				Flags: []cli.Flag{
+					lockdirFlag(),
+					&cli.BoolFlag{
+						Name:  "requests",
+						Usage: "Also list the IDs of the requests",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					dir := strArg(c, "dir", lock.DefaultDir)
+
+					infos := lock.List(dir)
+					if c.Bool("requests") {
+						infos = append(infos, lock.ListRequests(dir)...)
+					}
+					for _, i := range infos {
+						fmt.Println(i.ID)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
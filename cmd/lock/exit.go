@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+// The exit codes of the command, so that scripts can branch on why it failed
+const (
+	exitFailure    = 1  // any other failure
+	exitTimeout    = 2  // the lock was not obtained within --max-wait
+	exitContention = 3  // the lock was held and --max-wait 0 asked not to wait
+	exitBackend    = 4  // the lock directory could not be accessed
+	exitUsage      = 64 // invalid command line, as EX_USAGE of sysexits.h
+)
+
+const exitCodesHelp = `EXIT CODES:
+   0    success
+   1    failure
+   2    timeout, the lock was not obtained within --max-wait
+   3    contention, the lock was held and --max-wait 0 asked not to wait
+   4    backend error, the lock directory could not be accessed
+   64   usage error, invalid command line`
+
+// usageErr is an invalid command line
+type usageErr struct {
+	msg string
+}
+
+func (e *usageErr) Error() string {
+	return e.msg
+}
+
+func usageError(format string, args ...any) error {
+	return &usageErr{fmt.Sprintf(format, args...)}
+}
+
+// silentErr fails the command without reporting anything, e.g. when a
+// predicate is false, with the exit code if set, else exitFailure
+type silentErr struct {
+	code int
+}
+
+func (*silentErr) Error() string {
+	return ""
+}
+
+// parsed is set once the command line was parsed and checked by the cli
+// package, so that the errors before that are known to be usage errors
+var parsed bool
+
+func markParsed(c *cli.Context) error {
+	parsed = true
+
+	// set up once the command line is known valid, its failures not being usage errors
+	var err error
+	if eventLogger, err = newLogger(); err != nil {
+		return err
+	}
+	lock.SetLogger(eventLogger)
+
+	return dialDir(c)
+}
+
+// markCommandsParsed sets markParsed as the Before of the commands and
+// their subcommands, which run after their flags are parsed and checked,
+// and the update of the textfile as their After
+func markCommandsParsed(cmds []*cli.Command) {
+	for _, cmd := range cmds {
+		cmd.Before = markParsed
+		cmd.After = func(c *cli.Context) error {
+			updateTextfile(c)
+			return nil
+		}
+		markCommandsParsed(cmd.Subcommands)
+	}
+}
+
+// exitCode returns the exit code for the error returned by the command
+func exitCode(err error) int {
+	var usage *usageErr
+	var silent *silentErr
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &silent) && silent.code != 0:
+		return silent.code
+	case errors.As(err, &usage) || !parsed:
+		return exitUsage
+	// checked before ErrTimeout, which fail-fast errors also match
+	case errors.Is(err, lock.ErrWouldBlock):
+		return exitContention
+	case errors.Is(err, lock.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, lock.ErrBackend):
+		return exitBackend
+	default:
+		return exitFailure
+	}
+}
+
+// verbosity counts the occurrences of a flag, e.g. -vv
+type verbosity int
+
+func (v *verbosity) Set(string) error {
+	*v++
+	return nil
+}
+
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
+
+// countFlag is a flag given without value, possibly several times
+type countFlag struct {
+	cli.GenericFlag
+}
+
+func (f *countFlag) TakesValue() bool {
+	return false
+}
+
+func (f *countFlag) String() string {
+	return cli.FlagStringer(f)
+}
+
+// verbose is the number of -v given: 1 logs the lock events, 2 the debug ones
+var verbose verbosity
+
+// quiet is set by -q, to only report failures by the exit code
+var quiet bool
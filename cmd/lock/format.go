@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+func formatFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "format",
+		Usage: "Print each item with this Go template instead, e.g. '{{.ID}} {{.Node}} {{.Age}}'",
+	}
+}
+
+// formatArg returns the template given by --format, nil if none
+func formatArg(c *cli.Context) (*template.Template, error) {
+	text := strArg(c, "format", "")
+	if len(text) == 0 {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, usageError("Invalid --format: %v", err)
+	}
+	return tmpl, nil
+}
+
+// printFormatted prints the data with the template, followed by a newline
+func printFormatted(tmpl *template.Template, data any) error {
+	if err := tmpl.Execute(os.Stdout, data); err != nil {
+		return err
+	}
+	_, err := os.Stdout.WriteString("\n")
+	return err
+}
+
+// entryRow is a line of lock list, as given to --format
+type entryRow struct {
+	lock.Info
+	Type string
+	Age  time.Duration
+
+	// TTL is the time left before the lock lapses, 0 if it has no expiry
+	TTL time.Duration
+}
+
+func newEntryRow(kind string, i lock.Info) entryRow {
+	row := entryRow{Info: i, Type: kind, Age: time.Since(i.Created).Round(time.Second)}
+	if i.Expiry != nil {
+		row.TTL = ttlRemaining(*i.Expiry)
+	}
+	return row
+}
+
+// lockStatus is the state of a lock, as given to status --format
+type lockStatus struct {
+	Name          string
+	EstimatedWait time.Duration
+	Held          []entryRow
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+func gateCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "gate",
+		Usage: "Acquire the lock for a Kubernetes pod, e.g. of a singleton Job, and hold it for the pod lifetime",
+		Description: `Run as a native sidecar (an init container with restartPolicy: Always)
+with a startupProbe checking the --token-file, the pod only starts once the
+lock is acquired, which is renewed until the pod terminates.
+
+With a classic init container, --init exits once the lock is acquired, the
+renewals being left to a sidecar running gate --sidecar with the same
+--token-file on a shared volume.
+
+The lock needs a --ttl, so that it lapses if the pod dies.`,
+		Flags: append(acquireFlags(),
+			&cli.StringFlag{
+				Name:     "token-file",
+				Usage:    "Write the lock ID, name and directory to this file once acquired, read by --sidecar",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "init",
+				Usage: "Exit once the lock is acquired, leaving the renewals to a gate --sidecar",
+			},
+			&cli.BoolFlag{
+				Name:  "sidecar",
+				Usage: "Renew the lock acquired by a gate --init, given by the --token-file, until terminated",
+			},
+			&cli.BoolFlag{
+				Name:  "release-on-exit",
+				Usage: "Release the lock when terminated, rather than letting its TTL lapse",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.Bool("init") && c.Bool("sidecar") {
+				return usageError("Please give only one of --init and --sidecar")
+			}
+			if c.Bool("init") && c.Bool("release-on-exit") {
+				return usageError("--release-on-exit is for the gate holding the lock, not --init")
+			}
+
+			path := c.String("token-file")
+			if c.Bool("sidecar") {
+				lck, ttl, err := gateAttach(c, path)
+				if err != nil {
+					return err
+				}
+				return holdGate(lck, ttl, path, c.Bool("release-on-exit"))
+			}
+
+			cfg, err := acquireConfig(c)
+			if err != nil {
+				return err
+			}
+			if cfg.TTL <= 0 {
+				return usageError("Please give a --ttl, so that the lock lapses if the pod dies")
+			}
+
+			lck, err := acquireLock(c, cfg)
+			if err != nil {
+				return err
+			}
+			if err := writeTokenFile(path, lck, cfg.Name, cfg.Dir); err != nil {
+				lck.ForceRelease()
+				return err
+			}
+
+			if c.Bool("init") {
+				return nil
+			}
+			return holdGate(lck, cfg.TTL, path, c.Bool("release-on-exit"))
+		},
+	}
+}
+
+// gateAttach returns the lock of the token file written by gate --init,
+// with its TTL
+func gateAttach(c *cli.Context, path string) (*lock.Lock, time.Duration, error) {
+	id, fields, err := readToken(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dir := strArg(c, "dir", fields["dir"])
+	if len(dir) == 0 {
+		dir = lock.DefaultDir
+	}
+
+	lck, err := lock.Attach(id, dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the expiry is set along with the modification time, on each renewal
+	d, err := lock.Inspect(dir, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.Expiry == nil {
+		return nil, 0, fmt.Errorf("lock %s has no TTL, nothing to renew", id)
+	}
+	return lck, d.Expiry.Sub(d.LastRenewed).Round(time.Second), nil
+}
+
+// holdGate renews the lock until terminated, or until it is lost, then
+// releases it if asked. The token file is removed along, so that a
+// restarted pod acquires the lock again.
+func holdGate(lck *lock.Lock, ttl time.Duration, path string, release bool) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	lost := make(chan error, 1)
+	stop := lck.Heartbeat(ttl/3, func(err error) {
+		if errors.Is(err, lock.ErrSplitBrain) || errors.Is(err, os.ErrNotExist) {
+			select {
+			case lost <- err:
+			default:
+			}
+			return
+		}
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Failed to renew the lock: %v\n", err)
+		}
+	})
+	defer stop()
+
+	if eventLogger != nil {
+		eventLogger.Info("holding lock for the pod", "id", lck.ID(), "ttl", ttl)
+	}
+
+	select {
+	case err := <-lost:
+		os.Remove(path)
+		return fmt.Errorf("lost the lock: %v", err)
+	case <-sigs:
+	}
+
+	if !release {
+		return nil
+	}
+	stop()
+	os.Remove(path)
+	return lck.Release()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/mqtt"
+	"github.com/brinick/lock/systemd"
+)
+
+// logTarget is where the lock events are logged: stderr, syslog or journal
+var logTarget string
+
+// eventLogger is the logger of the lock events, nil if they are not logged
+var eventLogger lock.Logger
+
+// eventLog is the file the lock events are appended to, if --event-log is given
+var eventLog string
+
+// journalFile is the file of the lock operations in progress, if --journal is given
+var journalFile string
+
+// webhooks are the webhooks notified of the lock events, from --webhook
+var webhooks []lock.Webhook
+
+// eventSinks are sent the lock events, e.g. the MQTT publisher
+var eventSinks []lock.EventSink
+
+// publisher publishes the lock events to the --mqtt broker, if given
+var publisher *mqtt.Publisher
+
+// newLogger returns the logger of the lock events to the --log-target. On
+// stderr they are only logged with -v, and not with -q, elsewhere always.
+func newLogger() (lock.Logger, error) {
+	level := slog.LevelInfo
+	if verbose > 1 {
+		level = slog.LevelDebug
+	}
+
+	switch logTarget {
+	case "", "stderr":
+		if verbose == 0 || quiet {
+			return nil, nil
+		}
+		return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})), nil
+	case "syslog":
+		emit, err := syslogEmitter()
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(&fieldHandler{level: level, emit: emit}), nil
+	case "journal":
+		if !systemd.JournalAvailable() {
+			return nil, fmt.Errorf("the systemd journal is not available on this host")
+		}
+		return slog.New(&fieldHandler{level: level, emit: journal}), nil
+	default:
+		return nil, usageError("Invalid --log-target %q, expected stderr, syslog or journal", logTarget)
+	}
+}
+
+// fieldHandler is an slog.Handler passing the records, with their
+// attributes flattened, to an emit function
+type fieldHandler struct {
+	level slog.Level
+	attrs []slog.Attr
+	group string
+	emit  func(level slog.Level, msg string, attrs []slog.Attr) error
+}
+
+func (h *fieldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *fieldHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, a)
+		return true
+	})
+	return h.emit(r.Level, r.Message, attrs)
+}
+
+func (h *fieldHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range as {
+		c.attrs = appendAttr(c.attrs, h.group, a)
+	}
+	return &c
+}
+
+func (h *fieldHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.group += name + "."
+	return &c
+}
+
+// appendAttr appends the attribute, those of a group prefixed by its name
+func appendAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup {
+		return append(attrs, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+	}
+	if len(a.Key) > 0 {
+		prefix += a.Key + "."
+	}
+	for _, ga := range a.Value.Group() {
+		attrs = appendAttr(attrs, prefix, ga)
+	}
+	return attrs
+}
+
+// formatAttrs returns the message followed by the attributes as key=value
+func formatAttrs(msg string, attrs []slog.Attr) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, a := range attrs {
+		v := a.Value.String()
+		if len(v) == 0 || strings.ContainsAny(v, " \"=\n") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+	}
+	return b.String()
+}
+
+// journal sends the record to the systemd journal, the attributes as
+// LOCK_ fields so that entries can be matched on, e.g. LOCK_NAME
+func journal(level slog.Level, msg string, attrs []slog.Attr) error {
+	priority := "6"
+	switch {
+	case level >= slog.LevelError:
+		priority = "3"
+	case level >= slog.LevelWarn:
+		priority = "4"
+	case level < slog.LevelInfo:
+		priority = "7"
+	}
+
+	fields := map[string]string{
+		"MESSAGE":           formatAttrs(msg, attrs),
+		"PRIORITY":          priority,
+		"SYSLOG_IDENTIFIER": "lock",
+	}
+	for _, a := range attrs {
+		fields["LOCK_"+journalField(a.Key)] = a.Value.String()
+	}
+	return systemd.Journal(fields)
+}
+
+// journalField returns the key as journal field name
+func journalField(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
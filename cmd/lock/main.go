@@ -1,14 +1,29 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/brinick/lock"
 )
 
 func main() {
 	app := createApp()
-	if err := app.Run(os.Args); err != nil {
-		fmt.Fprint(os.Stderr, fmt.Sprintf("%v\n", err))
-		os.Exit(1)
+	err := app.Run(os.Args)
+	lock.WaitNotifications(15 * time.Second)
+	if publisher != nil {
+		publisher.Close()
+	}
+	if remote != nil {
+		remote.Close()
+	}
+	if err != nil {
+		var silent *silentErr
+		if !quiet && !errors.As(err, &silent) {
+			fmt.Fprint(os.Stderr, fmt.Sprintf("%v\n", err))
+		}
+		os.Exit(exitCode(err))
 	}
 }
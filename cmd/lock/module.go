@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+// moduleArgs are the arguments of lock module, durations being in
+// seconds. A negative max_wait waits forever.
+type moduleArgs struct {
+	Name         string            `json:"name"`
+	Dir          string            `json:"dir"`
+	State        string            `json:"state"`
+	ID           string            `json:"id"`
+	MaxWait      *int              `json:"max_wait"`
+	PollInterval int               `json:"poll_interval"`
+	TTL          int               `json:"ttl"`
+	Priority     int               `json:"priority"`
+	Shared       bool              `json:"shared"`
+	Metadata     map[string]string `json:"metadata"`
+	Force        bool              `json:"force"`
+
+	// CheckMode is set by Ansible for --check runs, which change nothing
+	CheckMode bool `json:"_ansible_check_mode"`
+}
+
+// moduleResult is the output of lock module, as expected by Ansible
+type moduleResult struct {
+	Changed bool   `json:"changed"`
+	Failed  bool   `json:"failed,omitempty"`
+	Msg     string `json:"msg"`
+	ID      string `json:"id,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+func moduleCmd() *cli.Command {
+	return &cli.Command{
+		Name:  "module",
+		Usage: "Acquire or release a lock as an Ansible module, reading the JSON arguments from the file, as passed to WANT_JSON modules, or stdin, and printing the Ansible JSON result",
+		Description: `The arguments are name, dir, state (present to acquire, absent to release),
+id (of the lock to release, or already held), max_wait, poll_interval and ttl
+(in seconds), priority, shared, metadata and force (to release a lock owned
+by another user). The result has changed, failed, msg and the id of the lock.`,
+		ArgsUsage: "[<args-file>|-]",
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() > 1 {
+				return usageError("Please give at most one argument: the arguments file")
+			}
+
+			res, err := runModule(c.Args().First())
+			if err != nil {
+				res = moduleResult{Failed: true, Msg: err.Error(), ID: res.ID}
+			}
+
+			out, _ := json.Marshal(res)
+			fmt.Println(string(out))
+			if err != nil {
+				return &silentErr{exitCode(err)}
+			}
+			return nil
+		},
+	}
+}
+
+// runModule reads the arguments and acquires or releases the lock
+func runModule(path string) (moduleResult, error) {
+	args, err := readModuleArgs(path)
+	if err != nil {
+		return moduleResult{}, err
+	}
+
+	if len(args.Dir) == 0 {
+		args.Dir = lock.DefaultDir
+	}
+
+	switch args.State {
+	case "", "present":
+		return modulePresent(args)
+	case "absent":
+		return moduleAbsent(args)
+	default:
+		return moduleResult{}, usageError("Invalid state %q, expected present or absent", args.State)
+	}
+}
+
+// readModuleArgs reads the arguments from the file, or stdin if none or -,
+// failing on the unknown ones but those passed by Ansible itself
+func readModuleArgs(path string) (*moduleArgs, error) {
+	var data []byte
+	var err error
+	if len(path) == 0 || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, usageError("Invalid module arguments: %v", err)
+	}
+
+	known := map[string]bool{}
+	for _, f := range []string{"name", "dir", "state", "id", "max_wait", "poll_interval", "ttl", "priority", "shared", "metadata", "force"} {
+		known[f] = true
+	}
+	var unknown []string
+	for f := range fields {
+		if !known[f] && !strings.HasPrefix(f, "_ansible_") {
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, usageError("Unsupported parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	var args moduleArgs
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, usageError("Invalid module arguments: %v", err)
+	}
+	return &args, nil
+}
+
+// modulePresent acquires the lock, unless the lock with the given ID is
+// already held, so that the task can be run again
+func modulePresent(args *moduleArgs) (moduleResult, error) {
+	if len(args.ID) > 0 {
+		if lck, err := lock.WithID(args.ID, args.Dir); err == nil {
+			return moduleResult{Msg: "lock already held", ID: lck.ID(), Path: lck.Path()}, nil
+		}
+	}
+	if len(args.Name) == 0 {
+		return moduleResult{}, usageError("Please give the name of the lock")
+	}
+	if args.CheckMode {
+		return moduleResult{Changed: true, Msg: "lock would be acquired"}, nil
+	}
+
+	cfg := lock.DefaultConfig()
+	cfg.Dir = args.Dir
+	cfg.Name = args.Name
+	if args.MaxWait != nil {
+		cfg.MaxWait = time.Duration(*args.MaxWait) * time.Second
+		if *args.MaxWait < 0 {
+			cfg.MaxWait = lock.WaitForever
+		}
+	}
+	if args.PollInterval > 0 {
+		cfg.PollInterval = time.Duration(args.PollInterval) * time.Second
+	}
+	cfg.TTL = time.Duration(args.TTL) * time.Second
+	cfg.Priority = args.Priority
+	cfg.Shared = args.Shared
+	cfg.Metadata = args.Metadata
+	cfg.Metrics = lockMetrics()
+	cfg.Logger = eventLogger
+	cfg.FS = dirFS()
+	cfg.EventLog = eventLog
+	cfg.Journal = journalFile
+	cfg.Webhooks = webhooks
+	cfg.EventSinks = eventSinks
+
+	lck, err := lock.Acquire(&cfg)
+	if err != nil {
+		return moduleResult{}, err
+	}
+	return moduleResult{Changed: true, Msg: "lock acquired", ID: lck.ID(), Path: lck.Path()}, nil
+}
+
+// moduleAbsent releases the lock with the given ID, if still held
+func moduleAbsent(args *moduleArgs) (moduleResult, error) {
+	if len(args.ID) == 0 {
+		return moduleResult{}, usageError("Please give the id of the lock to release")
+	}
+
+	lck, err := lock.WithID(args.ID, args.Dir)
+	if errors.Is(err, lock.ErrNotFound) {
+		return moduleResult{Msg: "lock not held", ID: args.ID}, nil
+	}
+	if err != nil {
+		return moduleResult{ID: args.ID}, err
+	}
+	if args.CheckMode {
+		return moduleResult{Changed: true, Msg: "lock would be released", ID: args.ID}, nil
+	}
+
+	release := lck.Release
+	if args.Force {
+		release = lck.ForceRelease
+	}
+	if err := release(); err != nil {
+		return moduleResult{ID: args.ID}, err
+	}
+	return moduleResult{Changed: true, Msg: "lock released", ID: args.ID, Path: lck.Path()}, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+// notifiers sends the alerts to several notifiers
+type notifiers []lock.Notifier
+
+func (n notifiers) Notify(alert lock.WaitAlert) error {
+	var errs []error
+	for _, notifier := range n {
+		errs = append(errs, notifier.Notify(alert))
+	}
+	return errors.Join(errs...)
+}
+
+// notifierArg returns the notifier of the --notify-slack and --notify-smtp
+// flags, nil if neither is given
+func notifierArg(c *cli.Context) (lock.Notifier, error) {
+	var n notifiers
+	if url := strArg(c, "notify-slack", ""); len(url) > 0 {
+		n = append(n, lock.SlackNotifier{WebhookURL: url})
+	}
+	if addr := strArg(c, "notify-smtp", ""); len(addr) > 0 {
+		to := c.StringSlice("notify-to")
+		if len(to) == 0 {
+			return nil, usageError("--notify-smtp needs at least one --notify-to")
+		}
+		n = append(n, lock.SMTPNotifier{
+			Addr:     addr,
+			From:     c.String("notify-from"),
+			To:       to,
+			Username: os.Getenv("LOCK_SMTP_USER"),
+			Password: os.Getenv("LOCK_SMTP_PASSWORD"),
+		})
+	}
+
+	switch len(n) {
+	case 0:
+		return nil, nil
+	case 1:
+		return n[0], nil
+	default:
+		return n, nil
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// progress reports on stderr, refreshed in place, how long acquire has
+// been waiting, its position in the queue and the current holder
+type progress struct {
+	dir, name string
+	started   time.Time
+	position  atomic.Int64
+	stop      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+}
+
+// isTerminal tells if the file is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress starts reporting the wait for the lock, after a second so
+// that immediate acquisitions print nothing. It returns nil, which reports
+// nothing, if stderr is not a terminal.
+func startProgress(dir, name string) *progress {
+	if quiet || !isTerminal(os.Stderr) {
+		return nil
+	}
+
+	p := &progress{
+		dir:     dir,
+		name:    name,
+		started: time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	drawn := false
+	for {
+		select {
+		case <-p.stop:
+			if drawn {
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			return
+		case <-ticker.C:
+			p.draw()
+			drawn = true
+		}
+	}
+}
+
+func (p *progress) draw() {
+	position := "?"
+	if pos := p.position.Load(); pos > 0 {
+		position = fmt.Sprint(pos)
+	}
+
+	holder := "nobody"
+	if held := lock.Held(p.dir, p.name); len(held) > 0 {
+		holder = fmt.Sprintf("%s (%s)", held[0].Node, held[0].ID)
+		if len(held) > 1 {
+			holder += fmt.Sprintf(" and %d more", len(held)-1)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\r\033[KWaiting for lock %s: %v elapsed, position %s, held by %s",
+		p.name, time.Since(p.started).Round(time.Second), position, holder)
+}
+
+// setPosition records the position in the queue, as given to OnWaiting
+func (p *progress) setPosition(pos int) {
+	if p != nil {
+		p.position.Store(int64(pos))
+	}
+}
+
+// Stop stops reporting and clears the line
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+}
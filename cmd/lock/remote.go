@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/davfs"
+	"github.com/brinick/lock/raftfs"
+	"github.com/brinick/lock/sftpfs"
+)
+
+// remote is the filesystem of a remote lock directory, if one is given
+var remote interface {
+	lock.FS
+	io.Closer
+}
+
+// fsProfile is the profile of the filesystem of the lock directory
+var fsProfile lock.FSProfile
+
+// dirFS returns the filesystem of the lock directory, nil if local
+func dirFS() lock.FS {
+	if remote == nil {
+		return nil
+	}
+	return remote
+}
+
+// dialDir connects to the host of an sftp:// or dav[s]:// lock directory,
+// the --dir flag then being the path of the directory on the host
+func dialDir(c *cli.Context) error {
+	dir := c.String("dir")
+	scheme, _, ok := strings.Cut(dir, "://")
+	if !ok {
+		return nil
+	}
+	if c.String("protocol") == "hardlink" {
+		return usageError("The hardlink protocol is not supported by remote lock directories, please use mkdir")
+	}
+
+	switch scheme {
+	case "sftp":
+		f, path, err := sftpfs.Dial(dir)
+		if err != nil {
+			return err
+		}
+		remote, dir = f, path
+	case "dav", "davs":
+		f, path, err := davfs.New(withDAVPassword(dir))
+		if err != nil {
+			return err
+		}
+		remote, dir = f, path
+	default:
+		return usageError("Unsupported lock directory %s, expected a path, sftp:// or dav[s]://", dir)
+	}
+
+	lock.SetFS(remote)
+	return c.Set("dir", dir)
+}
+
+// readSecretFile reads the secret in the file, ignoring surrounding whitespace
+func readSecretFile(path, what string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s file %s: %v", what, path, err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if len(secret) == 0 {
+		return "", fmt.Errorf("%s file %s is empty", what, path)
+	}
+	return secret, nil
+}
+
+// openRaft starts the Raft node of `lock serve`, the lock directory then
+// being replicated in the Raft group instead of on a filesystem
+func openRaft(c *cli.Context) error {
+	if remote != nil {
+		return usageError("A Raft node cannot serve a remote lock directory")
+	}
+
+	peers := map[string]string{}
+	for _, peer := range c.StringSlice("raft-peer") {
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || len(id) == 0 || len(addr) == 0 {
+			return usageError("Invalid --raft-peer %q, expected id=host:port", peer)
+		}
+		peers[id] = addr
+	}
+
+	path := c.String("raft-secret-file")
+	if len(path) == 0 {
+		return usageError("Please give the secret shared by the Raft nodes with --raft-secret-file")
+	}
+	secret, err := readSecretFile(path, "Raft secret")
+	if err != nil {
+		return err
+	}
+
+	f, err := raftfs.Open(raftfs.Config{
+		ID:     c.String("raft-id"),
+		Addr:   c.String("raft-addr"),
+		Dir:    c.String("raft-dir"),
+		Peers:  peers,
+		Secret: []byte(secret),
+	})
+	if err != nil {
+		return err
+	}
+
+	remote = f
+	lock.SetFS(remote)
+	return nil
+}
+
+// withDAVPassword adds the LOCK_DAV_PASSWORD environment variable as
+// password to the URL if it has a user but no password, so that it does
+// not have to be given on the command line
+func withDAVPassword(dir string) string {
+	u, err := url.Parse(dir)
+	if err != nil || u.User == nil {
+		return dir
+	}
+	if _, set := u.User.Password(); set {
+		return dir
+	}
+	if password, ok := os.LookupEnv("LOCK_DAV_PASSWORD"); ok {
+		u.User = url.UserPassword(u.User.Username(), password)
+	}
+	return u.String()
+}
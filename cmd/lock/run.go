@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+)
+
+func runCmd() *cli.Command {
+	return &cli.Command{
+		Name:      "run",
+		Usage:     "Run a command holding the lock, released when the command exits, with its exit code",
+		ArgsUsage: "-- <command> [<arg>...]",
+		Flags: append(acquireFlags(),
+			&cli.BoolFlag{
+				Name:  "skip-if-locked",
+				Usage: "Do not wait if the lock is held, exit at once with --skip-exit-code without running the command, e.g. for overlapping cron jobs",
+			},
+			&cli.IntFlag{
+				Name:  "skip-exit-code",
+				Usage: "The exit code with --skip-if-locked when the lock is held",
+			},
+			&cli.StringFlag{
+				Name:  "token-file",
+				Usage: "Write the lock ID, then its name, directory, path and metadata as key=value lines, to this file while the command runs",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() == 0 {
+				return usageError("Please give the command to run after --")
+			}
+
+			cfg, err := acquireConfig(c)
+			if err != nil {
+				return err
+			}
+
+			skip := c.Bool("skip-if-locked")
+			if skip {
+				cfg.MaxWait = 0
+			}
+
+			lck, err := acquireLock(c, cfg)
+			if skip && errors.Is(err, lock.ErrWouldBlock) {
+				if eventLogger != nil {
+					eventLogger.Info("lock held, skipping the command", "lock", cfg.Name)
+				}
+				if code := c.Int("skip-exit-code"); code != 0 {
+					return &silentErr{code}
+				}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// the token file is only valid while the lock is held
+			if path := c.String("token-file"); len(path) > 0 {
+				if err := writeTokenFile(path, lck, cfg.Name, cfg.Dir); err != nil {
+					lck.ForceRelease()
+					return err
+				}
+				defer os.Remove(path)
+			}
+
+			runErr := runLocked(lck, cfg, c.Args().Slice())
+			if err := lck.Release(); err != nil {
+				if runErr != nil {
+					fmt.Fprintf(os.Stderr, "Failed to release the lock: %v\n", err)
+					return runErr
+				}
+				return err
+			}
+			return runErr
+		},
+	}
+}
+
+// runLocked runs the command while holding the lock, renewing it if it
+// has a TTL and forwarding the termination signals to the command, and
+// returns a silentErr with its exit code if it fails
+func runLocked(lck *lock.Lock, cfg *lock.Configuration, args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "LOCK_TOKEN="+lck.ID())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	if cfg.TTL > 0 {
+		stop := lck.Heartbeat(cfg.TTL/3, func(err error) {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Failed to renew the lock: %v\n", err)
+			}
+		})
+		defer stop()
+	}
+
+	err := cmd.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if code := exit.ExitCode(); code > 0 {
+			return &silentErr{code}
+		}
+		// killed by a signal
+		return &silentErr{exitFailure}
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"log/syslog"
+)
+
+// syslogEmitter returns the emit function of a fieldHandler logging to
+// the local syslog daemon, the attributes formatted as key=value
+func syslogEmitter() (func(slog.Level, string, []slog.Attr) error, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "lock")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(level slog.Level, msg string, attrs []slog.Attr) error {
+		line := formatAttrs(msg, attrs)
+		switch {
+		case level >= slog.LevelError:
+			return w.Err(line)
+		case level >= slog.LevelWarn:
+			return w.Warning(line)
+		case level >= slog.LevelInfo:
+			return w.Info(line)
+		default:
+			return w.Debug(line)
+		}
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// syslogEmitter fails, there is no syslog on Windows
+func syslogEmitter() (func(slog.Level, string, []slog.Attr) error, error) {
+	return nil, fmt.Errorf("syslog is not available on Windows")
+}
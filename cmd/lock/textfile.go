@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/metrics"
+)
+
+// textfile is the node_exporter textfile collector file to keep up to
+// date, if --textfile is given
+var textfile string
+
+// updateTextfile writes the lock gauges of the lock directory of the
+// command to the textfile. Failures are reported but do not fail the
+// command, monitoring should not prevent locking.
+func updateTextfile(c *cli.Context) {
+	if len(textfile) == 0 || !hasFlag(c.Command, "dir") {
+		return
+	}
+	if err := metrics.WriteTextfile(textfile, strArg(c, "dir", lock.DefaultDir)); err != nil && !quiet {
+		fmt.Fprintf(os.Stderr, "Failed to write the textfile %s: %v\n", textfile, err)
+	}
+}
+
+// hasFlag tells if the command has a flag of the given name
+func hasFlag(cmd *cli.Command, name string) bool {
+	if cmd == nil {
+		return false
+	}
+	for _, f := range cmd.Flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
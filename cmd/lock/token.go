@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brinick/lock"
+)
+
+// writeTokenFile writes the ID of the lock on the first line of the file,
+// followed by its name, directory, path and metadata as key=value lines.
+// The file is written next to its destination then renamed, so that
+// readers never see it partially written.
+func writeTokenFile(path string, lck *lock.Lock, name, dir string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nname=%s\ndir=%s\npath=%s\n", lck.ID(), name, dir, lck.Path())
+
+	meta, err := lck.Metadata()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, meta[k])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write token file %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write token file %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write token file %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write token file %s: %v", path, err)
+	}
+	return nil
+}
+
+// readToken reads a lock ID, alone or as written by writeTokenFile, from
+// the file, or stdin if path is -. It also returns the key=value lines.
+func readToken(path string) (string, map[string]string, error) {
+	var data []byte
+	var err error
+	source := "token file " + path
+	if path == "-" {
+		source = "stdin"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read %s: %v", source, err)
+	}
+
+	id := ""
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+		case len(id) == 0:
+			id = line
+		default:
+			if k, v, ok := strings.Cut(line, "="); ok {
+				fields[k] = v
+			}
+		}
+	}
+
+	if len(id) == 0 {
+		return "", nil, fmt.Errorf("no lock ID found in %s", source)
+	}
+	return id, fields, nil
+}
@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encryptedMagic prefixes every encrypted payload so that readers can tell
+// an encrypted entry apart from a plain one.
+var encryptedMagic = []byte("LOCKENC1")
+
+// Cipher encrypts and decrypts the payload written into entry files.
+type Cipher interface {
+	Encrypt(plain []byte) ([]byte, error)
+	Decrypt(sealed []byte) ([]byte, error)
+}
+
+// ErrEncrypted is returned when reading an encrypted payload without a Cipher configured.
+var ErrEncrypted = fmt.Errorf("entry payload is encrypted and no cipher is configured")
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns a Cipher using AES-GCM with the given 16, 24 or 32 byte key.
+func NewAESGCM(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid site key: %v", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesGCM{aead}, nil
+}
+
+// LoadKeyFile reads a hex encoded site key from the given file
+// and returns an AES-GCM Cipher using it.
+func LoadKeyFile(path string) (Cipher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file %s: %v", path, err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s is not hex encoded: %v", path, err)
+	}
+
+	return NewAESGCM(key)
+}
+
+func (c *aesGCM) Encrypt(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	out := append([]byte{}, nonce...)
+	return c.aead.Seal(out, nonce, plain, nil), nil
+}
+
+func (c *aesGCM) Decrypt(sealed []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("encrypted payload too short")
+	}
+
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %v", err)
+	}
+
+	return plain, nil
+}
+
+// seal encrypts the payload if a cipher is given, prefixing the magic marker
+func seal(c Cipher, plain []byte) ([]byte, error) {
+	if c == nil {
+		return plain, nil
+	}
+
+	sealed, err := c.Encrypt(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, encryptedMagic...), sealed...), nil
+}
+
+// unseal reverses seal, returning plain payloads untouched
+func unseal(c Cipher, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+
+	if c == nil {
+		return nil, ErrEncrypted
+	}
+
+	return c.Decrypt(data[len(encryptedMagic):])
+}
+
+// SetCipher sets the Cipher used to read entry payloads outside of Acquire,
+// e.g. by Inspect or Metadata.
+func SetCipher(c Cipher) {
+	setConfig(func(cfg *Configuration) { cfg.Cipher = c })
+}
@@ -0,0 +1,453 @@
+// Package davfs provides the lock directory filesystem over WebDAV, e.g. a
+// Nextcloud or ownCloud share or other HTTP storage. New files are created
+// with PUT and If-None-Match, and directories with MKCOL, which fails if
+// the directory exists, so the mkdir mutex protocol is the one to use:
+// hard links are not available over WebDAV.
+package davfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// FS is a lock.FS over WebDAV
+type FS struct {
+	base   url.URL
+	client *http.Client
+}
+
+var _ lock.FS = (*FS)(nil)
+
+// New returns the FS of the dav://[user[:password]@]host[:port]/path URL,
+// davs:// for HTTPS, and the path of the lock directory on the server
+func New(rawurl string) (*FS, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || len(u.Host) == 0 {
+		return nil, "", fmt.Errorf("invalid WebDAV lock directory %s, expected dav[s]://host/path", rawurl)
+	}
+
+	switch u.Scheme {
+	case "dav":
+		u.Scheme = "http"
+	case "davs":
+		u.Scheme = "https"
+	default:
+		return nil, "", fmt.Errorf("invalid WebDAV lock directory %s, expected dav[s]://host/path", rawurl)
+	}
+	if len(u.Path) == 0 {
+		return nil, "", fmt.Errorf("no lock directory path in %s", rawurl)
+	}
+
+	dir := u.Path
+	u.Path, u.RawPath = "", ""
+	return &FS{*u, &http.Client{Timeout: time.Minute}}, dir, nil
+}
+
+// Close closes the idle connections
+func (f *FS) Close() error {
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	infos, err := f.propfind(name, "0")
+	if err != nil {
+		return nil, pathErr("stat", name, err)
+	}
+	if len(infos) == 0 {
+		return nil, pathErr("stat", name, fmt.Errorf("no properties returned"))
+	}
+	return infos[0], nil
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	data, _, err := f.get(name)
+	return data, pathErr("read", name, err)
+}
+
+// OpenFile returns a file whose content is sent with PUT when closed, once
+// complete. O_EXCL is honoured with If-None-Match, and a missing O_CREATE
+// with If-Match, so that a removed file is not recreated.
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (lock.File, error) {
+	file := &davFile{fs: f, name: name, flag: flag}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 || flag&os.O_APPEND != 0 {
+		data, etag, err := f.get(name)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		case err != nil:
+			return nil, pathErr("open", name, err)
+		default:
+			file.etag = etag
+			if flag&os.O_EXCL != 0 {
+				return nil, pathErr("open", name, fs.ErrExist)
+			}
+			if flag&os.O_APPEND != 0 {
+				file.base = data
+			} else {
+				file.buf.Write(data)
+			}
+		}
+	}
+	return file, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := f.propfind(name, "1")
+	if err != nil {
+		return nil, pathErr("readdir", name, err)
+	}
+
+	var entries []fs.DirEntry
+	for _, info := range infos {
+		// the directory itself is listed too
+		if info.self {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	resp, err := f.do("MKCOL", name, nil, nil)
+	if err != nil {
+		return pathErr("mkdir", name, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return pathErr("mkdir", name, fs.ErrExist)
+	case http.StatusConflict:
+		// the parent is missing
+		return pathErr("mkdir", name, fs.ErrNotExist)
+	default:
+		return pathErr("mkdir", name, statusErr(resp))
+	}
+}
+
+// Remove removes the file, or the directory and its content
+func (f *FS) Remove(name string) error {
+	resp, err := f.do(http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return pathErr("remove", name, err)
+	}
+	resp.Body.Close()
+	return pathErr("remove", name, statusErr(resp))
+}
+
+func (f *FS) Rename(oldpath, newpath string) error {
+	resp, err := f.do("MOVE", oldpath, nil, map[string]string{
+		"Destination": f.url(newpath),
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	resp.Body.Close()
+	if err := statusErr(resp); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+// Link is not supported by WebDAV
+func (f *FS) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+}
+
+// Chmod does nothing, the permissions being managed by the server
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// Chtimes sends the file again, WebDAV having no way to only change its
+// times: the modification time becomes the current time of the server, or
+// mtime with the servers honouring X-OC-Mtime, such as Nextcloud and
+// ownCloud. The access time is not kept.
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	data, etag, err := f.get(name)
+	if err != nil {
+		return pathErr("chtimes", name, err)
+	}
+
+	headers := map[string]string{"X-OC-Mtime": strconv.FormatInt(mtime.Unix(), 10)}
+	if len(etag) > 0 {
+		headers["If-Match"] = etag
+	}
+	return pathErr("chtimes", name, f.put(name, data, headers))
+}
+
+// KeepsAccessTime tells the access time is not kept, see Chtimes
+func (f *FS) KeepsAccessTime() bool {
+	return false
+}
+
+// get returns the content of the file and its ETag
+func (f *FS) get(name string) ([]byte, string, error) {
+	resp, err := f.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if err := statusErr(resp); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", &lock.BackendErr{Err: err}
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (f *FS) put(name string, data []byte, headers map[string]string) error {
+	resp, err := f.do(http.MethodPut, name, data, headers)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		if _, ok := headers["If-None-Match"]; ok {
+			return fs.ErrExist
+		}
+		return errChanged
+	}
+	return statusErr(resp)
+}
+
+// errChanged is a file changed or removed since read
+var errChanged = errors.New("file changed meanwhile")
+
+func (f *FS) do(method, name string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, f.url(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if f.base.User != nil {
+		password, _ := f.base.User.Password()
+		req.SetBasicAuth(f.base.User.Username(), password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, &lock.BackendErr{Err: fmt.Errorf("WebDAV request failed: %v", err)}
+	}
+	return resp, nil
+}
+
+func (f *FS) url(name string) string {
+	u := f.base
+	u.User = nil
+	u.Path = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	return u.String()
+}
+
+// statusErr returns the error matching the status of the response, nil on success
+func statusErr(resp *http.Response) error {
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusNotFound:
+		return fs.ErrNotExist
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return fs.ErrPermission
+	case code >= 500:
+		return &lock.BackendErr{Err: fmt.Errorf("WebDAV server returned %s", resp.Status)}
+	default:
+		return fmt.Errorf("WebDAV server returned %s", resp.Status)
+	}
+}
+
+// pathErr returns the error as an *fs.PathError, as returned by the os
+// package, so that os.IsNotExist and os.IsExist work with it
+func pathErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, errChanged) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// ----------------------------------------------------------------------
+
+// davFile is a file of OpenFile, sent when closed
+type davFile struct {
+	fs   *FS
+	name string
+	flag int
+	etag string
+
+	// base is the content the writes are appended to, with O_APPEND
+	base []byte
+	buf  bytes.Buffer
+}
+
+func (d *davFile) Read(p []byte) (int, error) {
+	return d.buf.Read(p)
+}
+
+func (d *davFile) Write(p []byte) (int, error) {
+	return d.buf.Write(p)
+}
+
+// appendRetries bounds the attempts to append to a file changed meanwhile
+const appendRetries = 5
+
+func (d *davFile) Close() error {
+	if d.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		headers := map[string]string{}
+		switch {
+		case d.flag&os.O_EXCL != 0, d.flag&os.O_APPEND != 0 && len(d.etag) == 0 && d.base == nil:
+			headers["If-None-Match"] = "*"
+		case len(d.etag) > 0:
+			headers["If-Match"] = d.etag
+		case d.flag&os.O_CREATE == 0:
+			headers["If-Match"] = "*"
+		}
+
+		data := d.buf.Bytes()
+		if d.flag&os.O_APPEND != 0 {
+			data = append(append([]byte{}, d.base...), data...)
+		}
+
+		err := d.fs.put(d.name, data, headers)
+		retry := errors.Is(err, errChanged) || (errors.Is(err, fs.ErrExist) && d.flag&os.O_EXCL == 0)
+		if d.flag&os.O_APPEND == 0 || !retry || attempt == appendRetries {
+			return pathErr("close", d.name, err)
+		}
+
+		// appended to meanwhile, append to the new content
+		base, etag, err := d.fs.get(d.name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return pathErr("close", d.name, err)
+		}
+		d.base, d.etag = base, etag
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// fileInfo is the fs.FileInfo of a PROPFIND response
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+
+	// self is set for the requested resource itself
+	self bool
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() any           { return nil }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0775
+	}
+	return 0664
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				LastModified  string `xml:"getlastmodified"`
+				ContentLength string `xml:"getcontentlength"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop>
+<d:getlastmodified/><d:getcontentlength/><d:resourcetype/>
+</d:prop></d:propfind>`
+
+// propfind returns the properties of the resource, and with depth 1 of its children
+func (f *FS) propfind(name, depth string) ([]*fileInfo, error) {
+	resp, err := f.do("PROPFIND", name, []byte(propfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := statusErr(resp); err != nil {
+		return nil, err
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &lock.BackendErr{Err: fmt.Errorf("invalid PROPFIND response: %v", err)}
+	}
+
+	self := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	var infos []*fileInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		p := path.Clean(href.Path)
+
+		info := &fileInfo{name: path.Base(p), self: p == self}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			info.dir = info.dir || ps.Prop.ResourceType.Collection != nil
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				info.modTime = t
+			}
+			if n, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+				info.size = n
+			}
+		}
+
+		// the requested resource first
+		if info.self {
+			infos = append([]*fileInfo{info}, infos...)
+		} else {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
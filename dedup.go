@@ -0,0 +1,57 @@
+package lock
+
+import (
+	"os"
+	"sync"
+)
+
+// The requests of the process are tracked so that calling Acquire again
+// for a name, e.g. in a retry loop, reuses a request the process left in
+// the queue, e.g. by Enqueue, rather than competing with itself.
+
+var (
+	ownRequestsMu sync.Mutex
+
+	// ownRequests are the requests created by the process and not removed,
+	// by path, telling if a wait is in progress on them
+	ownRequests = map[string]bool{}
+)
+
+// reuseRequest returns a request of the process for the configured name,
+// priority and mode on which no wait is in progress, marked as waited on, nil
+// if there is none
+func (c *Configuration) reuseRequest() *Request {
+	ownRequestsMu.Lock()
+	defer ownRequestsMu.Unlock()
+
+	ndir := nameDir(c.Dir, c.Name)
+	for path, waiting := range ownRequests {
+		r := Request{entry{path, c}}
+		if waiting || r.dir() != ndir || r.name() != leafName(c.Name) || r.priority() != c.Priority || r.shared() != c.Shared {
+			continue
+		}
+		if _, err := c.filesystem().Stat(path); os.IsNotExist(err) {
+			// removed by someone else, e.g. as expired
+			delete(ownRequests, path)
+			continue
+		}
+		ownRequests[path] = true
+		return &r
+	}
+	return nil
+}
+
+// trackRequest records whether a wait is in progress on the request of
+// the process
+func trackRequest(path string, waiting bool) {
+	ownRequestsMu.Lock()
+	defer ownRequestsMu.Unlock()
+	ownRequests[path] = waiting
+}
+
+// untrackRequest forgets the request, removed
+func untrackRequest(path string) {
+	ownRequestsMu.Lock()
+	defer ownRequestsMu.Unlock()
+	delete(ownRequests, path)
+}
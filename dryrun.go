@@ -0,0 +1,62 @@
+package lock
+
+// DryRunReport tells what would happen if the lock was requested now
+type DryRunReport struct {
+	Name string `json:"name"`
+
+	// Available is true if the lock would be obtained immediately
+	Available bool `json:"available"`
+
+	// Holders are the conflicting locks currently held, at most
+	// MaxLocks of them can be held at the same time
+	Holders []Info `json:"holders"`
+
+	// Queue are the pending requests, in queue order
+	Queue []Info `json:"queue"`
+
+	// Position is the 1-based queue position a new request would take
+	Position int `json:"position"`
+}
+
+// DryRun reports whether the configured lock could be obtained right now
+// and what its queue looks like, without creating any request file.
+func DryRun(cfg *Configuration) (*DryRunReport, error) {
+	c := configCopy()
+	if cfg != nil {
+		c = *cfg
+	}
+
+	if err := ValidateName(c.Name); err != nil {
+		return nil, err
+	}
+
+	// failing to list the entries must not be mistaken for none
+	holders, err := c.heldLocks()
+	if err != nil {
+		return nil, err
+	}
+	items, err := c.readEntries(nameDir(c.Dir, c.Name))
+	if err != nil {
+		return nil, err
+	}
+	requests := items.withFiletype(requestFileType)
+
+	queue := requests.withName(leafName(c.Name))
+	queue.sort()
+
+	// the request a new acquisition would create, only named
+	path, err := c.createEntryPath(c.Dir, c.Name, requestFileType, c.Priority)
+	if err != nil {
+		return nil, &BackendErr{err}
+	}
+	req := entry{path, &c}
+
+	report := &DryRunReport{
+		Name:     c.Name,
+		Holders:  infos(c.Dir, holders),
+		Queue:    infos(c.Dir, queue),
+		Position: req.positionIn(requests),
+	}
+	report.Available = report.Position == 1 && c.slotFree(holders)
+	return report, nil
+}
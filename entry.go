@@ -1,12 +1,15 @@
 package lock
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,33 +19,270 @@ const (
 	requestFileType = ".request"
 	lockFileType    = ".lock"
 
-	// Default time in seconds to wait between each attempt to acquire the lock
-	DefaultPollTime = 30
+	// sharedField ends the names of the shared locks and their requests
+	sharedField = "shared"
 
-	// Default maximum time in seconds to wait to acquire the lock before giving up
-	DefaultMaxWait = 3600
+	// Default time to wait between each attempt to acquire the lock
+	DefaultPollTime = 30 * time.Second
+
+	// Default maximum time to wait to acquire the lock before giving up
+	DefaultMaxWait = time.Hour
+
+	// WaitForever as MaxWait waits for the lock without time limit
+	WaitForever time.Duration = -1
 
 	// Default name for lock files
 	DefaultName = "default_lock"
+
+	// Default number of locks which can be held at the same time on a name
+	DefaultMaxLocks = 1
 )
 
 var (
 	// Default base directory in which to create locks and lock request files
 	DefaultDir = func() string {
-		d, _ := os.UserHomeDir()
+		d, err := os.UserHomeDir()
+		if err != nil || len(d) == 0 {
+			// e.g. services on Windows, or users without a home
+			return os.TempDir()
+		}
 		return d
 	}()
 
 	config = DefaultConfig()
+
+	// configMu guards the package configuration, copied by the acquisitions
+	configMu sync.RWMutex
 )
 
+// setConfig updates the package configuration
+func setConfig(update func(*Configuration)) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	update(&config)
+}
+
+// configCopy returns a copy of the package configuration
+func configCopy() Configuration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// currentConfig returns a copy of the package configuration, for the
+// functions and entries not given one
+func currentConfig() *Configuration {
+	c := configCopy()
+	return &c
+}
+
 // ----------------------------------------------------------------------
 
 type Configuration struct {
 	Dir          string
 	Name         string
-	PollInterval int
-	MaxWait      int
+	PollInterval time.Duration
+
+	// MaxWait is the time to wait for the lock before giving up with an
+	// error matching ErrTimeout. With 0, the lock is tried once and
+	// Acquire fails at once, with an error also matching ErrWouldBlock,
+	// if it is not available. With WaitForever, or any negative value,
+	// Acquire waits until the lock is obtained or canceled.
+	MaxWait time.Duration
+
+	// Clock tells the time and waits between attempts, the system clock
+	// if not set. Tests can set a fake one to simulate long waits.
+	Clock Clock
+
+	// Retry decides the wait between the checks of the queue and the
+	// attempts to create the lock, PollInterval if not set
+	Retry RetryPolicy
+
+	// Metadata is stored as the payload of the request and lock files
+	Metadata map[string]string
+
+	// Cipher, if set, encrypts the payload with a site key so that it
+	// is not readable by everyone with access to the lock directory
+	Cipher Cipher
+
+	// SigningKey, if set, is a secret shared by the lock users with which
+	// the entries are signed, so that a forged or tampered entry is refused
+	// by Attach and Release
+	SigningKey []byte
+
+	// Hierarchical makes a lock conflict with locks held on its parents
+	// and children, e.g. project/db conflicts with project and project/db/migrations
+	Hierarchical bool
+
+	// Priority of the request: requests with a higher priority
+	// are served before older requests with a lower one
+	Priority int
+
+	// Ordering selects how requests are ordered in the queue, all clients
+	// sharing a lock directory should use the same
+	Ordering Ordering
+
+	// MaxLocks is the number of locks which can be held at the same time
+	// on the name, DefaultMaxLocks (mutual exclusion) if not set. Finding
+	// more locks than that is a split brain, e.g. after a race with a
+	// client using another protocol: waiters wait for the holders to
+	// resolve it, see Lock.Resolve, until MaxWait.
+	MaxLocks int
+
+	// Shared takes the lock in shared mode, e.g. for readers: the shared
+	// locks of a name are held together, while the other, exclusive, locks
+	// exclude them. A shared request waits for the exclusive requests ahead
+	// of it, so that writers are not starved. Shared locks are only known
+	// to the clients of this version, all the clients of a name taking
+	// shared locks must support them.
+	Shared bool
+
+	// MaxQueue, if set, is the number of requests which can be pending on
+	// the name: Acquire fails at once with an error matching ErrQueueFull
+	// when as many are, so that the directory does not fill up with
+	// request files during incidents. Concurrent requests may exceed it
+	// by a few.
+	MaxQueue int
+
+	// EntryFormat is the format of the written entry files,
+	// DefaultEntryFormat if not set. Both formats are always read.
+	EntryFormat EntryFormat
+
+	// Protocol selects how the lock creation is made exclusive, all
+	// clients sharing a lock directory should use the same. Use
+	// ProtocolHardlink or ProtocolMkdir on NFS.
+	Protocol Protocol
+
+	// Budget, if set, limits the total time spent across Acquire calls
+	// sharing it, on top of the MaxWait of each call. It is measured on
+	// the clock it was created with, see NewBudgetClock.
+	Budget *Budget
+
+	// Audit records every acquire, release and timeout in an append-only
+	// log in the lock directory. Once a lock has an audit log, all
+	// clients append to it, whatever their configuration.
+	Audit bool
+
+	// AuditMaxSize is the size in bytes above which the audit log
+	// is rotated, DefaultAuditMaxSize if not set
+	AuditMaxSize int64
+
+	// EventLog, if set, is the path of a local file to which every lock
+	// event of the process (queue, acquire, release, timeout, cancel,
+	// break, stale) is appended as a JSON line, for log shippers. Unlike
+	// the audit log, it is outside the lock directory and covers all
+	// the locks.
+	EventLog string
+
+	// Journal, if set, is the path of local files in which the processes
+	// record the request and lock creations and the releases before
+	// doing them, until they complete, each in its own file suffixed by
+	// its PID. The next Acquire or Release with the same journal, e.g.
+	// after a restart, or Recover first rolls back the acquisitions and
+	// completes the releases left by the processes which are not running
+	// anymore, e.g. after a crash.
+	Journal string
+
+	// Webhooks are notified of the lock events, see Webhook
+	Webhooks []Webhook
+
+	// EventSinks are sent the lock events, in the background. The events
+	// are dropped, with a warning, while the sinks are too far behind.
+	EventSinks []EventSink
+
+	// NotifyAfter, if positive, is the wait after which the Notifier is
+	// sent a WaitAlert, once per acquisition
+	NotifyAfter time.Duration
+	Notifier    Notifier
+
+	// FileMode is the permission of the created files, DefaultFileMode if not set
+	FileMode os.FileMode
+
+	// DirMode is the permission of the created directories, DefaultDirMode if not set
+	DirMode os.FileMode
+
+	// AutoRelease releases the acquired lock when the process terminates
+	// on SIGINT, SIGTERM or SIGHUP, or exits through Exit or after ReleaseHeld
+	AutoRelease bool
+
+	// Cancel, if set, aborts a waiting Acquire when closed: its request is
+	// removed and ErrCanceled returned
+	Cancel <-chan struct{}
+
+	// Logger, if set, receives the acquire, retry, timeout and release events
+	Logger Logger
+
+	// Metrics, if set, receives measurements of the lock operations
+	Metrics Metrics
+
+	// FS, if set, is the filesystem of the lock directory, the local one
+	// if not, e.g. an SFTP one for machines without a shared mount
+	FS FS
+
+	// Preflight, if set, checks the lock directory before queuing, e.g.
+	// that it is writable and has free space
+	Preflight *Preflight
+
+	// FSProfile adapts the file operations to the filesystem, e.g.
+	// FSProfileSMB for CIFS/SMB mounts
+	FSProfile FSProfile
+
+	// Tracer, if set, traces the acquisition and release of locks
+	Tracer Tracer
+
+	// OnWaiting, if set, is called each poll cycle with the
+	// current 1-based position of the request in the queue
+	OnWaiting func(position int)
+
+	// OnAcquired, if set, is called once the lock is acquired
+	OnAcquired func(lck *Lock)
+
+	// OnTimeout, if set, is called when giving up waiting for the lock
+	OnTimeout func()
+
+	// RequestTTL, if set, is the time after which a request not seen alive
+	// is ignored and removed by the other waiters, so that requests left by
+	// dead waiters don't block the queue. Waiters signal they are alive at
+	// every poll, so it must be well above the poll interval of all clients.
+	// It is independent of the time a lock can be held. The heartbeats are
+	// recorded in the access time of the requests, so it is refused with
+	// the filesystems not keeping it, see AccessTimeKeeper.
+	RequestTTL time.Duration
+
+	// SkipAfterPolls, if set, makes the queue skip, without removing them,
+	// the requests not seen alive for that many poll intervals, so that
+	// dead waiters are bypassed. All clients sharing the lock should use the
+	// same poll interval, else slower clients are skipped too. It is refused
+	// with the filesystems not keeping the access times, as RequestTTL.
+	SkipAfterPolls int
+
+	// TTL, if set, is written in the locks as their expiry, TTL after their
+	// creation and pushed back by Touch and Renew. Waiters remove the locks
+	// past their expiry. Only stored in EntryFormatV2.
+	TTL time.Duration
+
+	// Liveness, if set, probes the holders of the conflicting locks while
+	// waiting, and their locks are removed if they are found dead
+	Liveness LivenessChecker
+
+	// TakeoverAfter, if set, lets the first waiter take over a lock whose
+	// holder neither renewed it (see Lock.Heartbeat) nor, with Liveness,
+	// was found alive for that long, the takeover being audited. Unlike
+	// without it, a holder found dead by Liveness is given the same grace,
+	// and a holder whose liveness is unknown counts as unreachable.
+	TakeoverAfter time.Duration
+
+	// OnStaleDetected, if set, is called with the path of each
+	// stale entry found while waiting for the lock
+	OnStaleDetected func(path string)
+}
+
+// maxLocks returns the number of locks which can be held on the name
+func (c *Configuration) maxLocks() int {
+	if c.MaxLocks < 1 {
+		return DefaultMaxLocks
+	}
+	return c.MaxLocks
 }
 
 func DefaultConfig() Configuration {
@@ -56,54 +296,264 @@ func DefaultConfig() Configuration {
 
 // Acquire drops a lock request file, and then, when the request is first in queue,
 // it will attempt to create the lock file within the time limit configured.
-// If successful it will return it to the caller.
-func Acquire(cfg *Configuration) (*entry, error) {
+// If successful it will return it to the caller. The lock is acquired with
+// a copy of cfg, or of the package configuration if nil.
+func Acquire(cfg *Configuration) (*Lock, error) {
+	c := configCopy()
 	if cfg != nil {
-		config = *cfg
+		c = *cfg
 	}
-	// Create the lock dir if inexistant
-	if err := createDir(config.Dir, 0774); err != nil {
+
+	if err := ValidateName(c.Name); err != nil {
 		return nil, err
 	}
+	return c.acquireWithin(context.Background())
+}
+
+// acquireWithin acquires the configured lock within its budget, if any
+func (c *Configuration) acquireWithin(ctx context.Context) (*Lock, error) {
+	if c.Budget == nil {
+		return c.acquire(ctx)
+	}
+
+	if c.Budget.Exhausted() {
+		return nil, &BudgetError{ErrBudgetExhausted, 0}
+	}
+
+	lck, err := c.acquire(ctx)
+	if err != nil {
+		err = &BudgetError{err, c.Budget.Remaining()}
+	}
+	return lck, err
+}
+
+func (c *Configuration) acquire(ctx context.Context) (*Lock, error) {
+	span := c.startSpan("lock.acquire",
+		Attribute{"lock.name", c.Name},
+		Attribute{"lock.dir", c.Dir},
+	)
+	defer span.End()
+
+	lck, err := c.queueAndCreate(ctx, span)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes(Attribute{"lock.id", lck.ID()})
+	}
+	return lck, err
+}
 
-	req, err := createRequest()
+func (c *Configuration) queueAndCreate(ctx context.Context, span Span) (*Lock, error) {
+	req, err := c.enqueue()
 	if err != nil {
 		return nil, err
 	}
 
-	isTimeOut := timedOut(config.MaxWait)
+	isTimeOut := c.timedOut(c.MaxWait)
+	if budget := c.Budget; budget != nil {
+		maxWaitOut := isTimeOut
+		isTimeOut = func() bool {
+			return maxWaitOut() || budget.Exhausted()
+		}
+	}
+
+	stop := c.Cancel
+	if ctx.Done() != nil {
+		// stop on either the Cancel channel or the context
+		merged := make(chan struct{})
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-c.Cancel:
+			case <-done:
+				return
+			}
+			close(merged)
+		}()
+		stop = merged
+	}
+
+	return req.wait(span, isTimeOut, stop, func() error {
+		if err := ctx.Err(); err != nil {
+			return c.canceled(req, err)
+		}
+		return c.canceled(req, ErrCanceled)
+	})
+}
+
+// checkQueue fails with a QueueFullErr if MaxQueue requests are pending
+func (c *Configuration) checkQueue() error {
+	if c.MaxQueue <= 0 {
+		return nil
+	}
+
+	items, err := c.readEntries(nameDir(c.Dir, c.Name))
+	if err != nil {
+		return err
+	}
+	if n := len(*items.withFiletype(requestFileType).withName(leafName(c.Name))); n >= c.MaxQueue {
+		return &QueueFullErr{n, c.MaxQueue}
+	}
+	return nil
+}
+
+// enqueue creates a request for the configured lock, joining its queue
+func (c *Configuration) enqueue() (*Request, error) {
+	c.metrics().AcquireAttempt(c.Name)
+
+	if req := c.reuseRequest(); req != nil {
+		c.logger().Debug("reusing lock request of the process", "lock", c.Name, "request", req.Path())
+		return req, nil
+	}
+
+	// Create the lock dir if inexistant
+	if err := c.createDir(nameDir(c.Dir, c.Name)); err != nil {
+		return nil, &BackendErr{err}
+	}
+	if err := c.preflight(nameDir(c.Dir, c.Name)); err != nil {
+		c.logger().Error("lock directory failed preflight check", "lock", c.Name, "error", err)
+		return nil, err
+	}
+	if err := c.checkQueue(); err != nil {
+		c.logger().Warn("lock queue full, not requesting", "lock", c.Name, "error", err)
+		return nil, err
+	}
+
+	req, err := c.createRequest()
+	if err != nil {
+		c.logger().Error("failed to create lock request", "lock", c.Name, "error", err)
+		return nil, &BackendErr{err}
+	}
+	trackRequest(req.path, true)
+	c.logger().Debug("lock requested", "lock", c.Name, "request", req.Path())
+	c.event(req.dir(), c.newAuditRecord(EventLogQueue, c.Name, req.ID(), ""))
+	return req, nil
+}
+
+// wait waits for the request to be first in queue then for the lock to be
+// free, and takes it. It gives up when isTimeOut, removing the request,
+// or when stop is closed, returning the result of stopped.
+func (req *Request) wait(span Span, isTimeOut func() bool, stop <-chan struct{}, stopped func() error) (*Lock, error) {
+	c := req.conf()
+	trackRequest(req.path, true)
+	defer func() {
+		// unless removed meanwhile, the request can be reused
+		if _, err := c.filesystem().Stat(req.path); err == nil {
+			trackRequest(req.path, false)
+		}
+	}()
+
+	// the wait is measured from the creation of the request, which is
+	// only known on the wall clock
+	started := c.anchor(time.Unix(0, int64(req.created())))
+
+	// paused tells if the lock was contended, having to be waited for
+	paused := false
+	alerted := false
+
+	queueSpan := span.Start("lock.queue", Attribute{"lock.queue_depth", req.Position()})
 
 	// Loop until we are first in queue (or we timeout)
-	for !req.IsOldest() {
+	for attempt := 1; ; attempt++ {
+		if err := req.heartbeat(); err != nil {
+			c.logger().Warn("failed to heartbeat lock request", "lock", c.Name, "error", err)
+		}
+		c.reapExpiredRequests(req)
+
+		pos := req.Position()
+		if c.OnWaiting != nil {
+			c.OnWaiting(pos)
+		}
+		if pos == 1 {
+			queueSpan.SetAttributes(Attribute{"lock.wait_seconds", c.since(started).Seconds()})
+			queueSpan.End()
+			break
+		}
+		c.logger().Debug("waiting in lock queue", "lock", c.Name, "position", pos)
+
 		if isTimeOut() {
-			msg := fmt.Sprintf("Timed out (%ds) waiting to acquire lock", config.MaxWait)
-			if err := req.Remove(); err != nil {
-				msg = fmt.Sprintf(
-					" (also failed to remove request %s: %v - please remove manually)",
-					req.Path(),
-					err,
-				)
-			}
-			return nil, fmt.Errorf(msg)
+			c.logger().Warn("timed out waiting in lock queue", "lock", c.Name, "max_wait", c.MaxWait)
+			c.metrics().TimedOut(c.Name)
+			c.onTimeout()
+			c.audit(req.dir(), AuditTimeout, c.Name, "", "waiting in queue")
+			queueSpan.End()
+			return nil, &TimeoutErr{c.MaxWait, "waiting to acquire lock", req.Remove(), req.Path()}
 		}
 
-		time.Sleep(time.Duration(config.PollInterval) * time.Second)
+		paused = true
+		c.alertWait(req, started, &alerted)
+		if !c.pause(attempt, stop) {
+			queueSpan.End()
+			return nil, stopped()
+		}
 	}
 
-	var lck *entry
+	var lck *Lock
+	var err error
 
-	// first in queue, try and get lock
-	for !isTimeOut() {
-		lck, err = create()
-		switch err.(type) {
-		case nil:
+	// first in queue, try and get lock, at least once
+	for attempt := 1; attempt == 1 || !isTimeOut(); attempt++ {
+		if err := req.heartbeat(); err != nil {
+			c.logger().Warn("failed to heartbeat lock request", "lock", c.Name, "error", err)
+		}
+
+		lck, err = c.create()
+		var exists *ExistsErr
+		var tooMany *TooManyLocksErr
+		switch {
+		case err == nil:
 			// We have the lock:
 			// 1. print out the lock token for the client to capture
 			// 2. delete the request
-			return lck, req.Remove()
-		case ExistsErr:
-			// wait for the existing lock to be removed
+			_ = c.recordHandoff(nameDir(c.Dir, c.Name))
+			c.logger().Info("lock acquired", "lock", c.Name, "id", lck.ID(), "path", lck.Path())
+			c.metrics().Acquired(c.Name, c.since(started))
+			rec := c.newAuditRecord(AuditAcquire, c.Name, lck.ID(), "")
+			if paused {
+				rec.Waited = c.since(started)
+			}
+			c.auditRecord(lck.dir(), rec)
+			if c.AutoRelease {
+				autoRelease(lck)
+			}
+			if c.OnAcquired != nil {
+				c.OnAcquired(lck)
+			}
+			if err := req.Remove(); err != nil {
+				return lck, err
+			}
+			// the acquisition completed, the lock is the caller's
+			c.unjournal(lck.path)
+			return lck, nil
+		case errors.As(err, &tooMany):
+			// a split brain, wait for the holders to converge
+			c.logger().Warn("more locks held than allowed, waiting for the holders to resolve it", "lock", c.Name, "locks", tooMany.Count, "max", tooMany.Max)
+			if isTimeOut() {
+				continue
+			}
+			paused = true
+			c.alertWait(req, started, &alerted)
+			if !c.pause(attempt, stop) {
+				return nil, stopped()
+			}
+		case errors.As(err, &exists) || err == errMutexBusy:
+			// retry at once if the holder was dead, else wait for the existing lock to be removed
+			if c.removeDeadHolders() > 0 {
+				continue
+			}
+			if isTimeOut() {
+				continue
+			}
+			c.logger().Debug("lock held, retrying", "lock", c.Name, "reason", err)
+			paused = true
+			c.alertWait(req, started, &alerted)
+			if !c.pause(attempt, stop) {
+				return nil, stopped()
+			}
 		default:
+			c.logger().Error("failed to create lock", "lock", c.Name, "error", err)
 			if removeErr := req.Remove(); removeErr != nil {
 				err = fmt.Errorf(
 					"Error creating lock %v, and also failed to remove request %s: %v",
@@ -116,23 +566,65 @@ func Acquire(cfg *Configuration) (*entry, error) {
 		}
 	}
 
-	return lck, nil
+	c.logger().Warn("timed out waiting for lock release", "lock", c.Name, "max_wait", c.MaxWait)
+	c.metrics().TimedOut(c.Name)
+	c.onTimeout()
+	c.audit(req.dir(), AuditTimeout, c.Name, "", "waiting for lock release")
+	return nil, &TimeoutErr{c.MaxWait, "waiting for existing lock to be released", req.Remove(), req.Path()}
+}
+
+// ErrCanceled is returned by Acquire when the Cancel channel is closed while waiting
+var ErrCanceled = fmt.Errorf("lock acquisition canceled")
+
+// pause waits for the retry policy delay after the given attempt, returning
+// false if stop was closed in the meantime
+func (c *Configuration) pause(attempt int, stop <-chan struct{}) bool {
+	select {
+	case <-c.clock().After(c.retryPolicy().Delay(attempt)):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// canceled removes the pending request of an acquisition canceled with
+// the given error, ErrCanceled or the error of the context
+func (c *Configuration) canceled(req *Request, cause error) error {
+	c.logger().Info("lock acquisition canceled", "lock", c.Name, "reason", cause)
+	c.event(req.dir(), c.newAuditRecord(EventLogCancel, c.Name, req.ID(), cause.Error()))
+	if err := req.Remove(); err != nil {
+		return fmt.Errorf("%w (also failed to remove request %s: %v)", cause, req.Path(), err)
+	}
+	return cause
+}
+
+func (c *Configuration) onTimeout() {
+	if c.OnTimeout != nil {
+		c.OnTimeout()
+	}
 }
 
 func Delete() error {
 	return nil
 }
 
-func WithID(id, lockdir string) (*entry, error) {
-
-	return nil, nil
+// WithID returns the lock with the given ID in the given directory.
+// Unlike Attach, its signature is only verified when releasing it.
+func WithID(id, lockdir string) (*Lock, error) {
+	return findLock(id, lockdir)
 }
 
-func timedOut(max int) func() bool {
-	started := time.Now().Unix()
+// timedOut returns a function telling if the max wait is over, never if
+// it is negative, see WaitForever. With the real clock, it is measured on
+// the monotonic clock, which steps of the wall clock do not affect.
+func (c *Configuration) timedOut(max time.Duration) func() bool {
+	if max < 0 {
+		return func() bool { return false }
+	}
+
+	started := c.clock().Now()
 	return func() bool {
-		val := (time.Now().Unix() - started) > int64(max)
-		return val
+		return c.since(started) > max
 	}
 }
 
@@ -169,9 +661,22 @@ func (e *entries) match(ee entry) *entries {
 	return &es
 }
 
+// withFiletype returns the entries of the given file type, skipping the
+// lock and request files which do not follow the entry naming scheme, see
+// Malformed
 func (e *entries) withFiletype(ft string) *entries {
 	return e.filter(func(ee entry) bool {
-		return ee.filetype() == ft
+		if ee.filetype() != ft {
+			return false
+		}
+		if ft != lockFileType && ft != requestFileType {
+			return true
+		}
+		if _, err := ee.parse(); err != nil {
+			logger().Debug("skipping malformed entry", "error", err)
+			return false
+		}
+		return true
 	})
 }
 
@@ -187,15 +692,19 @@ func (e *entries) withNode(nodename string) *entries {
 	})
 }
 
+// sort orders the entries by queue order, see entry.before
+func (e *entries) sort() {
+	sort.Slice(*e, func(i, j int) bool {
+		return (*e)[i].before((*e)[j])
+	})
+}
+
 func (e *entries) oldest() *entry {
 	if e == nil || len(*e) == 0 {
 		return nil
 	}
 
-	sort.Slice(*e, func(i, j int) bool {
-		return (*e)[i].created() < (*e)[j].created()
-	})
-
+	e.sort()
 	return &(*e)[0]
 }
 
@@ -203,17 +712,97 @@ func (e *entries) oldest() *entry {
 
 type entry struct {
 	path string
+
+	// cfg is the configuration the entry was created or found with,
+	// the package one if nil
+	cfg *Configuration
+}
+
+// conf returns the configuration of the entry
+func (e *entry) conf() *Configuration {
+	if e.cfg == nil {
+		return currentConfig()
+	}
+	return e.cfg
 }
 
 func (e *entry) Remove() error {
-	return os.Remove(e.path)
+	isLock := e.filetype() == lockFileType
+	if isLock {
+		// best effort, stats are only informative
+		_ = e.conf().recordHold(e.dir(), e.node(), time.Duration(e.conf().clock().Now().UnixNano()-int64(e.created())))
+	}
+
+	if err := e.conf().filesystem().Remove(e.path); err != nil {
+		return err
+	}
+	e.conf().unjournal(e.path)
+	if !isLock {
+		untrackRequest(e.path)
+	}
+
+	if isLock {
+		_ = e.conf().markReleased(e.dir())
+	}
+	return nil
+}
+
+// StillHeld tells if the entry file still exists, i.e. nobody released,
+// broke or purged it, and, if it has an expiry, it did not lapse, so
+// that holders can check they still own the lock before critical
+// operations. The ID being part of the file name, a lock acquired again
+// meanwhile by someone else is another file.
+func (e *entry) StillHeld() (bool, error) {
+	if _, err := e.conf().filesystem().Stat(e.path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, &BackendErr{err}
+	}
+	return !e.lapsed(), nil
 }
 
 func (e *entry) IsOldest() bool {
-	vals := _entries(e.dir()).withFiletype(e.filetype())
-	found := vals.match(*e)
-	// No matches means we are the oldest, or we check if we are
-	return len(*found) == 0 || found.oldest().path == e.path
+	return e.position() == 1
+}
+
+// position returns the 1-based position of the entry amongst
+// the matching entries of the same file type, the shared entries
+// only counting the exclusive ones ahead of them
+func (e *entry) position() int {
+	return e.positionIn(e.conf().entries(e.dir()).withFiletype(e.filetype()))
+}
+
+// positionIn returns the position of the entry amongst the given ones, see position
+func (e *entry) positionIn(vals *entries) int {
+	ahead := vals.match(*e).filter(func(other entry) bool {
+		if e.filetype() == requestFileType && (&Request{other}).skipped() {
+			return false
+		}
+		// the shared requests only wait for the exclusive ones
+		if e.shared() && other.shared() {
+			return false
+		}
+		return other.before(*e)
+	})
+	return len(*ahead) + 1
+}
+
+// before tells if the entry is ahead of the other in the queue:
+// higher priority first, then oldest first, then lowest ID. This is a
+// total order, so every waiter agrees on who is first even when two
+// entries were created in the same nanosecond.
+func (e *entry) before(other entry) bool {
+	if e.priority() != other.priority() {
+		return e.priority() > other.priority()
+	}
+	if t, o := e.queueTime(), other.queueTime(); t != o {
+		return t < o
+	}
+	if e.ID() != other.ID() {
+		return e.ID() < other.ID()
+	}
+	return e.path < other.path
 }
 
 func (e *entry) Path() string {
@@ -224,27 +813,120 @@ func (e *entry) filetype() string {
 	return filepath.Ext(e.path)
 }
 
-func (e *entry) fields() []string {
-	name := strings.Replace(e.base(), fmt.Sprintf(".%s", e.filetype()), "", -1)
-	return strings.Split(name, "__")
+// parsedEntry holds the fields packed into an entry file name
+type parsedEntry struct {
+	name     string
+	node     string
+	id       string
+	created  int
+	priority int
+	shared   bool
+}
+
+// EntryNameError is returned when parsing a file name which does not
+// follow the entry naming scheme, e.g. an editor backup or an .nfs file
+type EntryNameError struct {
+	Path   string
+	Reason string
+}
+
+func (e *EntryNameError) Error() string {
+	return fmt.Sprintf("%s is not a lock entry: %s", e.Path, e.Reason)
+}
+
+// parse parses the entry file name, i.e.
+// <name>__<node>__<id>__<created>[__p<priority>][__shared]<filetype>
+func (e *entry) parse() (parsedEntry, error) {
+	fields := strings.Split(strings.TrimSuffix(e.base(), e.filetype()), "__")
+	if len(fields) < 4 || len(fields) > 6 {
+		return parsedEntry{}, &EntryNameError{e.path, fmt.Sprintf("%d fields, expected 4 to 6", len(fields))}
+	}
+
+	for _, f := range fields {
+		if len(f) == 0 {
+			return parsedEntry{}, &EntryNameError{e.path, "empty field"}
+		}
+	}
+
+	name, err := decodeName(fields[0])
+	if err != nil {
+		return parsedEntry{}, &EntryNameError{e.path, fmt.Sprintf("invalid name: %v", err)}
+	}
+
+	created, ok := parseCreated(fields[3])
+	if !ok {
+		return parsedEntry{}, &EntryNameError{e.path, fmt.Sprintf("invalid creation time %q", fields[3])}
+	}
+
+	p := parsedEntry{name: name, node: fields[1], id: fields[2], created: created}
+
+	// only present when needed, see createEntryPath
+	optional := fields[4:]
+	if len(optional) > 0 && optional[len(optional)-1] == sharedField {
+		p.shared = true
+		optional = optional[:len(optional)-1]
+	}
+	if len(optional) > 0 {
+		if !strings.HasPrefix(optional[0], "p") || len(optional) > 1 {
+			return parsedEntry{}, &EntryNameError{e.path, fmt.Sprintf("invalid priority %q", optional[0])}
+		}
+		if p.priority, err = strconv.Atoi(optional[0][1:]); err != nil {
+			return parsedEntry{}, &EntryNameError{e.path, fmt.Sprintf("invalid priority %q", optional[0])}
+		}
+	}
+	return p, nil
+}
+
+// parseCreated parses the creation time field, in nanoseconds since the
+// epoch. Signs and zero are rejected, as such entries would sort before
+// all others and starve the queue.
+func parseCreated(field string) (int, bool) {
+	for _, r := range field {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	created, err := strconv.Atoi(field)
+	return created, err == nil && created > 0
+}
+
+// valid tells if the entry file name follows the naming scheme
+func (e *entry) valid() bool {
+	_, err := e.parse()
+	return err == nil
+}
+
+// parsed returns the fields of the entry name, all zero if it cannot be parsed
+func (e *entry) parsed() parsedEntry {
+	p, _ := e.parse()
+	return p
 }
 
+// name returns the decoded lock name of the entry
 func (e *entry) name() string {
-	return e.fields()[0]
+	return e.parsed().name
 }
 
 func (e *entry) node() string {
-	return e.fields()[1]
+	return e.parsed().node
 }
 
 func (e *entry) ID() string {
-	return e.fields()[2]
+	return e.parsed().id
 }
 
 func (e *entry) created() int {
-	when := e.fields()[3]
-	value, _ := strconv.Atoi(when)
-	return value
+	return e.parsed().created
+}
+
+// priority returns the optional priority field of the entry, 0 if absent
+func (e *entry) priority() int {
+	return e.parsed().priority
+}
+
+// shared tells if the entry is a shared lock or a request for one
+func (e *entry) shared() bool {
+	return e.parsed().shared
 }
 
 func (e *entry) hasName(name string) bool {
@@ -263,17 +945,64 @@ func (e *entry) dir() string {
 	return filepath.Dir(e.path)
 }
 
-// create will write to disk the file
+// create will write to disk the file, all at once, see commitFile
 func (e *entry) create(contents string) error {
-	return os.WriteFile(e.path, []byte(contents), 0774)
+	return e.conf().commitFile(e.path, []byte(contents))
 }
 
-// ----------------------------------------------------------------------
+// MetaPID is the metadata key holding the PID of the process owning an entry
+const MetaPID = "pid"
+
+// createWithMetadata writes the file with the given metadata as payload,
+// encrypted if a cipher is configured
+func (e *entry) createWithMetadata(meta map[string]string) error {
+	// record the owner process, user and command, unless the caller gave them
+	withOwner := map[string]string{
+		MetaPID:     strconv.Itoa(os.Getpid()),
+		MetaUser:    currentUser(),
+		MetaCommand: strings.Join(os.Args, " "),
+	}
+	for k, v := range meta {
+		withOwner[k] = v
+	}
+
+	c := e.conf()
+	// the expiry is only stored, and so signed, in EntryFormatV2
+	var exp expiry
+	if c.TTL > 0 && e.filetype() == lockFileType && c.entryFormat() != EntryFormatV1 {
+		exp = expiry{c.clock().Now().Add(c.TTL), c.TTL}
+	}
+
+	if len(c.SigningKey) > 0 {
+		withOwner[MetaSignature] = e.signature(c.SigningKey, withOwner, exp)
+	}
+
+	data, err := e.sealBody(c.entryFormat(), withOwner, exp)
+	if err != nil {
+		return err
+	}
+	return e.create(string(data))
+}
+
+// sealBody returns the entry file content, encrypted if a cipher is configured
+func (e *entry) sealBody(format EntryFormat, meta map[string]string, exp expiry) ([]byte, error) {
+	data, err := e.encodeBody(format, meta, exp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode metadata: %v", err)
+	}
+	return seal(e.conf().Cipher, data)
+}
 
-type ExistsErr error
-type TooManyLocksErr error
+// Metadata returns the metadata stored in the entry payload, decrypting
+// it if needed, whatever the entry format
+func (e *entry) Metadata() (map[string]string, error) {
+	meta, _, _, err := e.readBody()
+	return meta, err
+}
 
-func createEntryPath(dir, name, filetype string) (string, error) {
+// ----------------------------------------------------------------------
+
+func (c *Configuration) createEntryPath(dir, name, filetype string, priority int) (string, error) {
 	uuid, err := newUUID()
 	if err != nil {
 		return "", err
@@ -281,15 +1010,23 @@ func createEntryPath(dir, name, filetype string) (string, error) {
 
 	uuid = strings.ReplaceAll(uuid, "-", "")
 
-	name = fmt.Sprintf(
-		"%s__%s__%s__%d%s",
-		strings.Replace(name, "/", "_", -1),
+	entryName := fmt.Sprintf(
+		"%s__%s__%s__%d",
+		encodeName(leafName(name)),
 		currentNode(),
 		uuid,
-		currentEpoch(),
-		filetype,
+		c.clock().Now().UnixNano(),
 	)
-	return filepath.Join(dir, name), nil
+
+	// only add the priority field when needed to keep the default format unchanged
+	if priority != 0 {
+		entryName = fmt.Sprintf("%s__p%d", entryName, priority)
+	}
+	if c.Shared {
+		entryName += "__" + sharedField
+	}
+	entryName += filetype
+	return filepath.Join(nameDir(dir, name), entryName), nil
 }
 
 func requests(dir string) *entries {
@@ -301,57 +1038,117 @@ func locks(dir string) *entries {
 }
 
 func _entries(dir string) *entries {
-	matches, _ := filepath.Glob(fmt.Sprintf("%s/*", dir))
+	return currentConfig().entries(dir)
+}
+
+// entries lists the files of the directory with the filesystem of the
+// configuration, the entries keeping it
+func (c *Configuration) entries(dir string) *entries {
+	items, _ := c.readEntries(dir)
+	return items
+}
+
+// readEntries returns the entries in the directory, failing with an
+// UnavailableErr if it could not be listed. A missing directory has no
+// entries.
+func (c *Configuration) readEntries(dir string) (*entries, error) {
+	matches, err := listDir(c.filesystem(), dir)
+	if err != nil {
+		err = &UnavailableErr{dir, err}
+	}
 	var items entries
 	for _, item := range matches {
-		items = append(items, entry{item})
+		items = append(items, entry{item, c})
 	}
-	return &items
+	return &items, err
 }
 
-func createRequest() (*entry, error) {
-	path, err := createEntryPath(config.Dir, config.Name, requestFileType)
+func (c *Configuration) createRequest() (*Request, error) {
+	// the waiters would not be seen alive past their creation
+	if (c.RequestTTL > 0 || c.SkipAfterPolls > 0) && !c.keepsAccessTime() {
+		return nil, errors.New("RequestTTL and SkipAfterPolls need a filesystem keeping the access times, in which the request heartbeats are recorded")
+	}
+
+	path, err := c.createEntryPath(c.Dir, c.Name, requestFileType, c.Priority)
 	if err != nil {
 		return nil, err
 	}
 
-	e := entry{path}
-	if err := e.create(""); err != nil {
+	r := Request{entry{path, c}}
+	c.journal(JournalRequest, path)
+	if err := r.createWithMetadata(c.Metadata); err != nil {
+		c.unjournal(path)
 		return nil, fmt.Errorf("failed to create request %s: %v", path, err)
 	}
 
-	return &e, nil
+	return &r, nil
 }
 
-// createDir creates the given directory with the provided permission
-func createDir(dir string, perm os.FileMode) error {
-	if err := os.MkdirAll(dir, perm); err != nil {
-		return fmt.Errorf("unable to create lock dir %s: %v", dir, err)
+// slotFree tells if the configured lock can be created with the given
+// locks held: a shared lock only conflicts with the exclusive ones, an
+// exclusive one with MaxLocks locks of any mode
+func (c *Configuration) slotFree(held *entries) bool {
+	if c.Shared {
+		return len(*held.filter(func(e entry) bool { return !e.shared() })) == 0
 	}
-
-	return nil
+	return len(*held) < c.maxLocks()
 }
 
 // create will create the lock file in the given directory with the given name
-// unless one or more locks already exist.
-func create() (*entry, error) {
-	path, err := createEntryPath(config.Dir, config.Name, lockFileType)
+// unless the maximum number of locks with that name already exist.
+func (c *Configuration) create() (*Lock, error) {
+	path, err := c.createEntryPath(c.Dir, c.Name, lockFileType, 0)
 	if err != nil {
+		return nil, &BackendErr{err}
+	}
+	e := Lock{entry{path, c}}
+
+	unlock, err := c.takeMutex(nameDir(c.Dir, c.Name))
+	if err == errMutexBusy {
 		return nil, err
 	}
-	e := entry{path}
+	if err != nil {
+		return nil, &BackendErr{err}
+	}
+	defer unlock()
 
-	n := len(*locks(config.Dir))
+	// failing to list the locks must not be mistaken for no locks held
+	existing, err := c.heldLocks()
+	if err != nil {
+		return nil, err
+	}
+
+	n, max := len(*existing), c.maxLocks()
+	exclusive := len(*existing.filter(func(e entry) bool { return !e.shared() }))
 	switch {
-	case n == 0:
-		// we can make the lock
-		if err := e.create(""); err != nil {
-			return nil, fmt.Errorf("failed to create request %s: %v", path, err)
+	case c.slotFree(existing):
+		// a slot is free, we can make the lock
+		c.journal(JournalLock, path)
+		if err := e.createWithMetadata(c.Metadata); err != nil {
+			c.unjournal(path)
+			return nil, &BackendErr{fmt.Errorf("failed to create lock %s: %w", path, err)}
 		}
-	case n <= 2:
-		return nil, ExistsErr(fmt.Errorf("%d lock(s) already exist", n))
+	case exclusive > max:
+		return nil, &TooManyLocksErr{exclusive, max}
 	default:
-		return nil, TooManyLocksErr(fmt.Errorf("%d locks found, expect <= 2", n))
+		// held exclusively, or shared when asking for exclusive
+		return nil, &ExistsErr{n}
+	}
+
+	settled, err := c.settled(&e)
+	if err != nil {
+		if c.filesystem().Remove(path) == nil {
+			c.unjournal(path)
+		}
+		return nil, err
+	}
+	if !settled {
+		c.logger().Debug("lock created concurrently by another client, backing off", "lock", c.Name)
+		if err := c.filesystem().Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, &BackendErr{fmt.Errorf("failed to remove lock %s: %w", path, err)}
+		}
+		c.unjournal(path)
+		return nil, &ExistsErr{max}
 	}
 
 	return &e, nil
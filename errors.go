@@ -0,0 +1,195 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sentinel errors to branch on with errors.Is, whatever the error type
+// carrying the details
+var (
+	// ErrTimeout is matched by the errors of acquisitions timing out
+	ErrTimeout = errors.New("timed out waiting for the lock")
+
+	// ErrNotFound is matched when the lock or request looked for does not exist
+	ErrNotFound = errors.New("lock not found")
+
+	// ErrNotOwner is matched when acting on a lock owned by another user
+	ErrNotOwner = errors.New("lock owned by another user")
+
+	// ErrWouldBlock is matched when the lock is not available and MaxWait is 0
+	ErrWouldBlock = errors.New("lock not available without waiting")
+
+	// ErrBackend is matched by failures of the lock directory storage,
+	// e.g. a full or unreachable filesystem
+	ErrBackend = errors.New("lock backend failure")
+
+	// ErrBackendUnavailable is matched when the lock directory could not be
+	// listed, e.g. on a permission error or an unmounted NFS directory, so
+	// that the held locks are unknown. It also matches ErrBackend.
+	ErrBackendUnavailable = errors.New("lock backend unavailable")
+
+	// ErrSplitBrain is matched when a lock was released because more
+	// locks than allowed were held on its name and it lost the resolution
+	ErrSplitBrain = errors.New("lock lost to split-brain resolution")
+
+	// ErrQueueFull is matched when the lock is not requested because
+	// Configuration.MaxQueue requests are already pending
+	ErrQueueFull = errors.New("lock queue full")
+)
+
+// ExistsErr is returned when the lock cannot be created because
+// the maximum number of locks with its name are already held
+type ExistsErr struct {
+	Count int
+}
+
+func (e *ExistsErr) Error() string {
+	return fmt.Sprintf("%d lock(s) already exist", e.Count)
+}
+
+// TooManyLocksErr is returned when more locks than allowed are found,
+// meaning that the lock directory is inconsistent
+type TooManyLocksErr struct {
+	Count int
+	Max   int
+}
+
+func (e *TooManyLocksErr) Error() string {
+	return fmt.Sprintf("%d locks found, at most %d expected", e.Count, e.Max)
+}
+
+// QueueFullErr is returned when MaxQueue requests are already pending on the lock
+type QueueFullErr struct {
+	Count int
+	Max   int
+}
+
+func (e *QueueFullErr) Error() string {
+	return fmt.Sprintf("%d request(s) already pending, at most %d allowed", e.Count, e.Max)
+}
+
+func (e *QueueFullErr) Is(target error) bool {
+	return target == ErrQueueFull
+}
+
+// PreflightErr is returned when the lock directory fails a check of
+// Configuration.Preflight, before queuing
+type PreflightErr struct {
+	Dir   string
+	Check string
+	Err   error
+}
+
+func (e *PreflightErr) Error() string {
+	return fmt.Sprintf("lock directory %s failed the %s check: %v", e.Dir, e.Check, e.Err)
+}
+
+func (e *PreflightErr) Unwrap() error {
+	return e.Err
+}
+
+func (e *PreflightErr) Is(target error) bool {
+	return target == ErrBackend
+}
+
+// SplitBrainErr is returned when the lock was released by the split-brain
+// resolution, Count locks being held on its name where at most Max are allowed
+type SplitBrainErr struct {
+	ID    string
+	Count int
+	Max   int
+}
+
+func (e *SplitBrainErr) Error() string {
+	return fmt.Sprintf("lock %s released: %d locks found, at most %d expected, and newer ones give way", e.ID, e.Count, e.Max)
+}
+
+func (e *SplitBrainErr) Is(target error) bool {
+	return target == ErrSplitBrain
+}
+
+// TimeoutErr is returned when the lock was not acquired within MaxWait
+type TimeoutErr struct {
+	MaxWait time.Duration
+
+	// Waiting tells what was waited for when timing out
+	Waiting string
+
+	// Cleanup is the error removing the request, if it failed
+	Cleanup error
+	Request string
+}
+
+func (e *TimeoutErr) Error() string {
+	msg := fmt.Sprintf("Timed out (%v) %s", e.MaxWait, e.Waiting)
+	if e.MaxWait == 0 {
+		msg = fmt.Sprintf("Lock not available without waiting (max wait 0), would be %s", e.Waiting)
+	}
+	if e.Cleanup != nil {
+		msg += fmt.Sprintf(
+			" (also failed to remove request %s: %v - please remove manually)",
+			e.Request,
+			e.Cleanup,
+		)
+	}
+	return msg
+}
+
+func (e *TimeoutErr) Is(target error) bool {
+	return target == ErrTimeout || (target == ErrWouldBlock && e.MaxWait == 0)
+}
+
+// NotFoundErr is returned when no lock or request has the given ID
+type NotFoundErr struct {
+	ID  string
+	Dir string
+
+	// What is looked for: lock, or lock or request
+	What string
+}
+
+func (e *NotFoundErr) Error() string {
+	return fmt.Sprintf("no %s with ID %s found in %s", e.What, e.ID, e.Dir)
+}
+
+func (e *NotFoundErr) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// BackendErr wraps a failure of the lock directory storage
+type BackendErr struct {
+	Err error
+}
+
+func (e *BackendErr) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BackendErr) Unwrap() error {
+	return e.Err
+}
+
+func (e *BackendErr) Is(target error) bool {
+	return target == ErrBackend
+}
+
+// UnavailableErr is returned when the entries of the lock directory could
+// not be listed, rather than taking the failure for an empty directory
+type UnavailableErr struct {
+	Dir string
+	Err error
+}
+
+func (e *UnavailableErr) Error() string {
+	return fmt.Sprintf("failed to list %s: %v", e.Dir, e.Err)
+}
+
+func (e *UnavailableErr) Unwrap() error {
+	return e.Err
+}
+
+func (e *UnavailableErr) Is(target error) bool {
+	return target == ErrBackendUnavailable || target == ErrBackend
+}
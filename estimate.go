@@ -0,0 +1,35 @@
+package lock
+
+import "time"
+
+// EstimateWait estimates how long a new request for the named lock would
+// wait before acquiring it, based on the hold and handoff durations
+// recorded for past holders and the current state of the queue.
+// It returns 0 when there is no history to base an estimate on.
+func EstimateWait(dir, name string) (time.Duration, error) {
+	stats, err := ReadStats(dir, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if stats.Hold.Count == 0 {
+		return 0, nil
+	}
+
+	hold := stats.Hold.Mean()
+	perHolder := hold + stats.Handoff.Mean()
+
+	var wait time.Duration
+	for _, held := range *locksNamed(dir, name) {
+		// time the current holder is expected to still hold the lock for
+		age := time.Duration(currentEpoch() - int64(held.created()))
+		if left := hold - age; left > 0 {
+			wait += left
+		}
+		wait += stats.Handoff.Mean()
+	}
+
+	waiting := len(*requestsNamed(dir, name))
+	wait += time.Duration(waiting) * perHolder
+	return wait, nil
+}
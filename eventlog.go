@@ -0,0 +1,117 @@
+package lock
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Events of the event log in addition to the audit ones
+const (
+	EventLogQueue  = "queue"
+	EventLogCancel = "cancel"
+)
+
+// EventLogRecord is a line of the event log
+type EventLogRecord struct {
+	AuditRecord
+
+	// Dir is the directory of the lock name, in the lock directory
+	Dir string `json:"dir"`
+}
+
+// SetEventLog sets the event log file, see Configuration.EventLog
+func SetEventLog(path string) {
+	setConfig(func(c *Configuration) { c.EventLog = path })
+}
+
+// EventSink receives the lock events, e.g. to publish them to a message
+// broker, see Configuration.EventSinks and the mqtt package
+type EventSink interface {
+	Send(rec EventLogRecord) error
+}
+
+// SetEventSinks sets the event sinks, see Configuration.EventSinks
+func SetEventSinks(sinks []EventSink) {
+	setConfig(func(c *Configuration) { c.EventSinks = sinks })
+}
+
+var (
+	sinkOnce  sync.Once
+	sinkQueue chan func()
+)
+
+// event reports the record of the lock name directory to the event log,
+// the webhooks and the event sinks
+func (c *Configuration) event(ndir string, rec AuditRecord) {
+	c.logEvent(ndir, rec)
+	c.fireWebhooks(ndir, rec)
+	c.sendEvent(ndir, rec)
+}
+
+// sendEvent sends the record to the event sinks in the background, in
+// order. Failures are logged but not returned.
+func (c *Configuration) sendEvent(ndir string, rec AuditRecord) {
+	if len(c.EventSinks) == 0 {
+		return
+	}
+
+	sinkOnce.Do(func() {
+		sinkQueue = make(chan func(), 64)
+		go func() {
+			for send := range sinkQueue {
+				send()
+				deliveries.Done()
+			}
+		}()
+	})
+
+	sinks := c.EventSinks
+	deliveries.Add(1)
+	send := func() {
+		for _, sink := range sinks {
+			if err := sink.Send(EventLogRecord{rec, ndir}); err != nil {
+				c.logger().Warn("failed to send lock event", "event", rec.Event, "error", err)
+			}
+		}
+	}
+
+	// a slow or unreachable sink must not hold up the locking
+	select {
+	case sinkQueue <- send:
+	default:
+		deliveries.Done()
+		c.logger().Warn("event sinks falling behind, dropping lock event", "event", rec.Event, "lock", rec.Lock)
+	}
+}
+
+// logEvent appends the record to the event log, if configured. Failures
+// are logged but not returned, as for the audit log.
+func (c *Configuration) logEvent(ndir string, rec AuditRecord) {
+	if len(c.EventLog) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(EventLogRecord{rec, ndir})
+	if err == nil {
+		err = appendLine(c.EventLog, line)
+	}
+	if err != nil {
+		c.logger().Warn("failed to write event log", "path", c.EventLog, "error", err)
+	}
+}
+
+// appendLine appends the line to the local file, in a single write so
+// that the lines of concurrent processes don't interleave
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
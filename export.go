@@ -0,0 +1,117 @@
+package lock
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is the version of the Snapshot format
+const snapshotVersion = 1
+
+// Snapshot is the exported state of a lock directory
+type Snapshot struct {
+	Version  int             `json:"version"`
+	Dir      string          `json:"dir"`
+	Exported time.Time       `json:"exported"`
+	Entries  []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is an exported lock or request entry
+type SnapshotEntry struct {
+	// Type is lock or request
+	Type string `json:"type"`
+
+	// Name is the, possibly hierarchical, lock name
+	Name string `json:"name"`
+
+	// File is the base name of the entry file
+	File string `json:"file"`
+
+	// Payload is the raw content of the file, encrypted metadata included
+	Payload []byte `json:"payload,omitempty"`
+
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Export returns a snapshot of all the lock and request entries of the directory
+func Export(dir string) (*Snapshot, error) {
+	snap := &Snapshot{
+		Version:  snapshotVersion,
+		Dir:      dir,
+		Exported: clock().Now().UTC(),
+		Entries:  []SnapshotEntry{},
+	}
+
+	for _, e := range *entriesTree(dir) {
+		kind := ""
+		switch e.filetype() {
+		case lockFileType:
+			kind = "lock"
+		case requestFileType:
+			kind = "request"
+		default:
+			continue
+		}
+
+		info, err := fsys().Stat(e.path)
+		if err != nil {
+			// removed while exporting
+			continue
+		}
+
+		payload, err := fsys().ReadFile(e.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to export %s: %v", e.path, err)
+		}
+
+		snap.Entries = append(snap.Entries, SnapshotEntry{
+			Type:    kind,
+			Name:    e.qualifiedName(dir),
+			File:    e.base(),
+			Payload: payload,
+			ModTime: info.ModTime(),
+		})
+	}
+	return snap, nil
+}
+
+// Import recreates the entries of the snapshot in the directory, keeping
+// their IDs, payloads and modification times. Existing entries are never
+// overwritten: import fails before writing anything if any already exists.
+func Import(dir string, snap *Snapshot) error {
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	paths := make([]string, len(snap.Entries))
+	for i, se := range snap.Entries {
+		if err := ValidateName(se.Name); err != nil {
+			return err
+		}
+
+		if se.File != filepath.Base(se.File) {
+			return fmt.Errorf("invalid entry file name %q in snapshot", se.File)
+		}
+
+		paths[i] = filepath.Join(nameDir(dir, se.Name), se.File)
+		if _, err := fsys().Stat(paths[i]); err == nil {
+			return fmt.Errorf("entry %s already exists, not importing", paths[i])
+		}
+	}
+
+	for i, se := range snap.Entries {
+		if err := createDir(filepath.Dir(paths[i])); err != nil {
+			return err
+		}
+
+		if err := currentConfig().commitFile(paths[i], se.Payload); err != nil {
+			return fmt.Errorf("unable to import %s: %v", paths[i], err)
+		}
+
+		if err := fsys().Chtimes(paths[i], se.ModTime, se.ModTime); err != nil {
+			return fmt.Errorf("unable to set the time of %s: %v", paths[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EntryFormat is the format of the content of the entry files
+type EntryFormat int
+
+const (
+	// EntryFormatV1 stores the metadata, owner PID and user included,
+	// as a flat JSON object, all other fields being only in the file name
+	EntryFormatV1 EntryFormat = 1
+
+	// EntryFormatV2 stores the entry fields as a versioned JSON document,
+	// so that they can be read without parsing the file name. Clients
+	// older than this format cannot read its metadata: use EntryFormatV1
+	// while they still share the lock directory.
+	EntryFormatV2 EntryFormat = 2
+
+	// DefaultEntryFormat is the format written if none is configured
+	DefaultEntryFormat = EntryFormatV2
+)
+
+// entryBody is the content of an EntryFormatV2 entry file
+type entryBody struct {
+	Version  EntryFormat       `json:"version"`
+	Name     string            `json:"name"`
+	Node     string            `json:"node"`
+	ID       string            `json:"id"`
+	PID      int               `json:"pid"`
+	User     string            `json:"user"`
+	Command  string            `json:"command,omitempty"`
+	Created  int64             `json:"created"`
+	Priority int               `json:"priority,omitempty"`
+	Expiry   int64             `json:"expiry,omitempty"`
+	TTL      time.Duration     `json:"ttl,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Signature is the HMAC of the other fields, see signature
+	Signature string `json:"sig,omitempty"`
+}
+
+func (c *Configuration) entryFormat() EntryFormat {
+	if c.EntryFormat == 0 {
+		return DefaultEntryFormat
+	}
+	return c.EntryFormat
+}
+
+// expiry is when an entry lapses unless touched, and by how much
+// touching it pushes it back. The zero value never lapses.
+type expiry struct {
+	At  time.Time
+	TTL time.Duration
+}
+
+// encodeBody returns the content of the entry file in the configured
+// format, from the metadata holding the owner PID, user and signature.
+// The expiry is only stored in EntryFormatV2.
+func (e *entry) encodeBody(format EntryFormat, meta map[string]string, exp expiry) ([]byte, error) {
+	if format == EntryFormatV1 {
+		return json.Marshal(meta)
+	}
+
+	p := e.parsed()
+	body := entryBody{
+		Version:   EntryFormatV2,
+		Name:      p.name,
+		Node:      p.node,
+		ID:        p.id,
+		User:      meta[MetaUser],
+		Command:   meta[MetaCommand],
+		Created:   int64(p.created),
+		Priority:  p.priority,
+		Signature: meta[MetaSignature],
+		Metadata:  map[string]string{},
+		TTL:       exp.TTL,
+	}
+	if !exp.At.IsZero() {
+		body.Expiry = exp.At.UnixNano()
+	}
+	body.PID, _ = strconv.Atoi(meta[MetaPID])
+
+	for k, v := range meta {
+		switch k {
+		case MetaPID, MetaUser, MetaCommand, MetaSignature:
+		default:
+			body.Metadata[k] = v
+		}
+	}
+	return json.Marshal(body)
+}
+
+// decodeBody reads the entry file content in either format, returning
+// the metadata with the owner PID, user and signature as in EntryFormatV1
+func (e *entry) decodeBody(data []byte) (map[string]string, EntryFormat, expiry, error) {
+	var probe struct {
+		Version json.RawMessage `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, 0, expiry{}, fmt.Errorf("unable to decode metadata of %s: %v", e.path, err)
+	}
+
+	// a V1 metadata value is always a string
+	if len(probe.Version) == 0 || probe.Version[0] == '"' {
+		meta := map[string]string{}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, 0, expiry{}, fmt.Errorf("unable to decode metadata of %s: %v", e.path, err)
+		}
+		return meta, EntryFormatV1, expiry{}, nil
+	}
+
+	var body entryBody
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, 0, expiry{}, fmt.Errorf("unable to decode entry %s: %v", e.path, err)
+	}
+	if body.Version != EntryFormatV2 {
+		return nil, 0, expiry{}, fmt.Errorf("entry %s has unsupported format version %d", e.path, body.Version)
+	}
+
+	meta := map[string]string{}
+	for k, v := range body.Metadata {
+		meta[k] = v
+	}
+	meta[MetaPID] = strconv.Itoa(body.PID)
+	meta[MetaUser] = body.User
+	if len(body.Command) > 0 {
+		meta[MetaCommand] = body.Command
+	}
+	if len(body.Signature) > 0 {
+		meta[MetaSignature] = body.Signature
+	}
+
+	exp := expiry{TTL: body.TTL}
+	if body.Expiry > 0 {
+		exp.At = time.Unix(0, body.Expiry)
+	}
+	return meta, EntryFormatV2, exp, nil
+}
+
+// readBody reads and decrypts the entry file, returning its
+// metadata, format and expiry. Empty files are EntryFormatV1.
+func (e *entry) readBody() (map[string]string, EntryFormat, expiry, error) {
+	data, err := e.conf().filesystem().ReadFile(e.path)
+	if err != nil {
+		return nil, 0, expiry{}, err
+	}
+
+	if len(data) == 0 {
+		return map[string]string{}, EntryFormatV1, expiry{}, nil
+	}
+
+	data, err = unseal(e.conf().Cipher, data)
+	if err != nil {
+		return nil, 0, expiry{}, err
+	}
+
+	return e.decodeBody(data)
+}
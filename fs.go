@@ -0,0 +1,225 @@
+package lock
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FS is the filesystem holding the lock directory, so that it can be
+// reached otherwise than through a local mount, e.g. over SFTP. Its
+// methods behave as the os package functions of the same names, their
+// errors matching the fs package ones (fs.ErrNotExist, fs.ErrExist).
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Link(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// File is a file opened with FS.OpenFile
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// DirSyncer is implemented by the filesystems able to flush a directory
+// to stable storage, so that the files created in it survive a crash
+type DirSyncer interface {
+	SyncDir(name string) error
+}
+
+// AccessTimeKeeper is implemented by the filesystems telling whether they
+// keep the access time given to Chtimes, in which the waiters record that
+// they are alive. The filesystems not implementing it are assumed to.
+type AccessTimeKeeper interface {
+	KeepsAccessTime() bool
+}
+
+// keepsAccessTime tells if the configured filesystem keeps the access times
+func (c *Configuration) keepsAccessTime() bool {
+	k, ok := c.FS.(AccessTimeKeeper)
+	return !ok || k.KeepsAccessTime()
+}
+
+// osFS is the local filesystem
+type osFS struct{}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// SetFS sets the filesystem of the lock directory, see Configuration.FS
+func SetFS(f FS) {
+	setConfig(func(c *Configuration) { c.FS = f })
+}
+
+// filesystem returns the configured FS, the local filesystem if not set
+func (c *Configuration) filesystem() FS {
+	var f FS = osFS{}
+	if c.FS != nil {
+		f = c.FS
+	}
+	if c.FSProfile == FSProfileSMB {
+		f = smbFS{f}
+	}
+	return f
+}
+
+func fsys() FS {
+	return currentConfig().filesystem()
+}
+
+// syncFile flushes the file to stable storage, if supported
+func syncFile(f File) error {
+	s, ok := f.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	if err := s.Sync(); !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// syncDir flushes the directory to stable storage, if supported
+func syncDir(f FS, dir string) error {
+	s, ok := f.(DirSyncer)
+	if !ok {
+		return nil
+	}
+	if err := s.SyncDir(dir); !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// removeAll removes the path and, if a directory, its content
+func removeAll(f FS, path string) error {
+	children, err := f.ReadDir(path)
+	if err == nil {
+		for _, child := range children {
+			removeAll(f, filepath.Join(path, child.Name()))
+		}
+	}
+
+	err = f.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// listDir returns the paths of the entries of the directory, sorted,
+// none if the directory does not exist
+func listDir(f FS, dir string) ([]string, error) {
+	children, err := f.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(children))
+	for _, child := range children {
+		paths = append(paths, filepath.Join(dir, child.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// walkDir walks the directory tree like filepath.WalkDir, over the FS
+func walkDir(f FS, root string, fn fs.WalkDirFunc) error {
+	if _, ok := f.(osFS); ok {
+		return filepath.WalkDir(root, fn)
+	}
+
+	info, err := f.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(f, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(f FS, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	children, err := f.ReadDir(path)
+	if err != nil {
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	for _, child := range children {
+		if err := walk(f, filepath.Join(path, child.Name()), child, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
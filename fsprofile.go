@@ -0,0 +1,123 @@
+package lock
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FSProfile adapts the file operations to the filesystem of the lock
+// directory, for the filesystems whose semantics differ from POSIX
+type FSProfile int
+
+const (
+	// FSProfileDefault assumes POSIX semantics, as local filesystems and NFS
+	FSProfileDefault FSProfile = iota
+
+	// FSProfileSMB is for CIFS/SMB mounts: renames never replace an
+	// existing file, the operations failing with a sharing violation,
+	// e.g. while an antivirus scans the file, are retried, and a new lock
+	// is only trusted once the directory listings cached by the other
+	// clients had time to show it, see SMBSettleTime.
+	FSProfileSMB
+)
+
+var (
+	// SMBSettleTime is the time given to the other clients of an SMB
+	// mount, which cache the directory listings, to see a new lock
+	SMBSettleTime = 2 * time.Second
+
+	// SMBRetries is the number of attempts of an operation failing
+	// with a sharing violation on an SMB mount
+	SMBRetries = 5
+)
+
+// SetFSProfile sets the filesystem profile, see Configuration.FSProfile
+func SetFSProfile(p FSProfile) {
+	setConfig(func(c *Configuration) { c.FSProfile = p })
+}
+
+// smbFS wraps the filesystem of an SMB mount
+type smbFS struct {
+	FS
+}
+
+// retry runs the operation until it does not fail with a sharing violation
+func retry[T any](op func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		v, err := op()
+		if err == nil || !sharingViolation(err) || attempt >= SMBRetries {
+			return v, err
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+}
+
+func retryErr(op func() error) error {
+	_, err := retry(func() (struct{}, error) { return struct{}{}, op() })
+	return err
+}
+
+func (s smbFS) Stat(name string) (fs.FileInfo, error) {
+	return retry(func() (fs.FileInfo, error) { return s.FS.Stat(name) })
+}
+
+func (s smbFS) ReadFile(name string) ([]byte, error) {
+	return retry(func() ([]byte, error) { return s.FS.ReadFile(name) })
+}
+
+func (s smbFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return retry(func() (File, error) { return s.FS.OpenFile(name, flag, perm) })
+}
+
+func (s smbFS) Remove(name string) error {
+	return retryErr(func() error { return s.FS.Remove(name) })
+}
+
+// Rename removes newpath first, renames over existing files not being
+// reliable on SMB. It is therefore not atomic when newpath exists.
+func (s smbFS) Rename(oldpath, newpath string) error {
+	if err := s.Remove(newpath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return retryErr(func() error { return s.FS.Rename(oldpath, newpath) })
+}
+
+func (s smbFS) SyncDir(name string) error {
+	return syncDir(s.FS, name)
+}
+
+func (s smbFS) Chmod(name string, mode os.FileMode) error {
+	return retryErr(func() error { return s.FS.Chmod(name, mode) })
+}
+
+func (s smbFS) Chtimes(name string, atime, mtime time.Time) error {
+	return retryErr(func() error { return s.FS.Chtimes(name, atime, mtime) })
+}
+
+// settled tells if the new lock is still within the maximum number of
+// locks once the listings of the other clients of an SMB mount could show
+// it: if more locks were created meanwhile, only the first ones are kept,
+// in queue order, and false is returned for the others.
+func (c *Configuration) settled(lck *Lock) (bool, error) {
+	if c.FSProfile != FSProfileSMB {
+		return true, nil
+	}
+
+	<-c.clock().After(SMBSettleTime)
+
+	existing, err := c.heldLocks()
+	if err != nil {
+		return false, err
+	}
+
+	ahead := existing.filter(func(e entry) bool {
+		return e.path != lck.path && e.before(lck.entry)
+	})
+	if lck.shared() {
+		// only the exclusive locks conflict
+		return len(*ahead.filter(func(e entry) bool { return !e.shared() })) == 0, nil
+	}
+	return len(*ahead) < c.maxLocks(), nil
+}
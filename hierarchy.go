@@ -0,0 +1,184 @@
+package lock
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The entries of each lock name live in their own subdirectory of the
+// lock directory, so that polling only lists the entries of that name.
+// Lock names may be hierarchical, using slashes as separators
+// (e.g. project/db/migrations), in which case the subdirectories
+// are nested, mirroring the hierarchy.
+
+// nameDir returns the directory holding the entries of the named lock
+func nameDir(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(encodePath(name)))
+}
+
+// leafName returns the last component of a hierarchical lock name
+func leafName(name string) string {
+	return path.Base(cleanName(name))
+}
+
+func cleanName(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// ancestorNames returns the names of the parents of a hierarchical
+// lock name, e.g. [project, project/db] for project/db/migrations
+func ancestorNames(name string) []string {
+	parts := strings.Split(cleanName(name), "/")
+	var names []string
+	for i := 1; i < len(parts); i++ {
+		names = append(names, strings.Join(parts[:i], "/"))
+	}
+	return names
+}
+
+// locksNamed returns the locks with the given, possibly hierarchical, name
+func locksNamed(dir, name string) *entries {
+	return currentConfig().locksNamed(dir, name)
+}
+
+func (c *Configuration) locksNamed(dir, name string) *entries {
+	return c.entries(nameDir(dir, name)).withFiletype(lockFileType).withName(leafName(name))
+}
+
+// requestsNamed returns the requests for the given, possibly hierarchical, name
+func requestsNamed(dir, name string) *entries {
+	return currentConfig().requestsNamed(dir, name)
+}
+
+func (c *Configuration) requestsNamed(dir, name string) *entries {
+	return c.entries(nameDir(dir, name)).withFiletype(requestFileType).withName(leafName(name))
+}
+
+func relatedLocks(dir, name string) *entries {
+	return currentConfig().relatedLocks(dir, name)
+}
+
+// relatedLocks returns the locks held on the ancestors and
+// the descendants of the given hierarchical lock name
+func (c *Configuration) relatedLocks(dir, name string) *entries {
+	var related entries
+	for _, parent := range ancestorNames(name) {
+		related.extend(c.locksNamed(dir, parent))
+	}
+
+	own := nameDir(dir, name)
+	children := c.entriesTree(own).withFiletype(lockFileType).filter(func(e entry) bool {
+		return e.dir() != own
+	})
+	related.extend(children)
+	return &related
+}
+
+// heldLocks returns the locks counting against the maximum of the
+// configured name, failing with an UnavailableErr if any of their
+// directories could not be listed
+func (c *Configuration) heldLocks() (*entries, error) {
+	names := []string{c.Name}
+	if c.Hierarchical {
+		names = append(names, ancestorNames(c.Name)...)
+	}
+
+	var held entries
+	for _, name := range names {
+		items, err := c.readEntries(nameDir(c.Dir, name))
+		if err != nil {
+			return nil, err
+		}
+		held.extend(items.withFiletype(lockFileType).withName(leafName(name)))
+	}
+	if !c.Hierarchical {
+		return &held, nil
+	}
+
+	// the locks of the descendants
+	own := nameDir(c.Dir, c.Name)
+	var children entries
+	err := walkDir(c.filesystem(), own, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil && os.IsNotExist(err):
+			return nil
+		case err != nil:
+			return &UnavailableErr{p, err}
+		case !d.IsDir() && filepath.Dir(p) != own:
+			children = append(children, entry{p, c})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	held.extend(children.withFiletype(lockFileType))
+	return &held, nil
+}
+
+// readNamed returns the entries of the file type for the given, possibly
+// hierarchical, name, failing with an UnavailableErr if their directory
+// could not be listed
+func (c *Configuration) readNamed(dir, name, ft string) (*entries, error) {
+	items, err := c.readEntries(nameDir(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return items.withFiletype(ft).withName(leafName(name)), nil
+}
+
+// readEntriesTree returns all entries in the directory and its
+// subdirectories, failing with an UnavailableErr if any part of the tree
+// could not be listed. A missing directory has no entries.
+func (c *Configuration) readEntriesTree(dir string) (*entries, error) {
+	var items entries
+	err := walkDir(c.filesystem(), dir, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil && os.IsNotExist(err):
+			return nil
+		case err != nil:
+			return &UnavailableErr{p, err}
+		case !d.IsDir():
+			items = append(items, entry{p, c})
+		}
+		return nil
+	})
+	return &items, err
+}
+
+// entriesTree returns all entries in the directory and its subdirectories
+func entriesTree(dir string) *entries {
+	return currentConfig().entriesTree(dir)
+}
+
+func (c *Configuration) entriesTree(dir string) *entries {
+	var items entries
+	walkDir(c.filesystem(), dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable parts of the tree are skipped
+			return nil
+		}
+		if !d.IsDir() {
+			items = append(items, entry{p, c})
+		}
+		return nil
+	})
+	return &items
+}
+
+// qualifiedName returns the hierarchical name of an entry found under dir
+func (e *entry) qualifiedName(dir string) string {
+	rel, err := filepath.Rel(dir, e.dir())
+	if err != nil || rel == "." {
+		return e.name()
+	}
+
+	name, err := decodePath(filepath.ToSlash(rel))
+	if err != nil {
+		return filepath.ToSlash(rel)
+	}
+	return name
+}
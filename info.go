@@ -0,0 +1,79 @@
+package lock
+
+import "time"
+
+// Info describes a lock or request entry
+type Info struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Node     string    `json:"node"`
+	Created  time.Time `json:"created"`
+	Priority int       `json:"priority,omitempty"`
+	Shared   bool      `json:"shared,omitempty"`
+	Path     string    `json:"path"`
+
+	// Expiry is when the lock lapses unless touched, nil if it has no expiry
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+// List returns the locks currently held in the directory
+func List(dir string) []Info {
+	return infos(dir, entriesTree(dir).withFiletype(lockFileType))
+}
+
+// Held returns the locks currently held on the given name in the directory
+func Held(dir, name string) []Info {
+	return infos(dir, locksNamed(dir, name))
+}
+
+// ListRequests returns the pending lock requests in the directory, in queue order
+func ListRequests(dir string) []Info {
+	reqs := entriesTree(dir).withFiletype(requestFileType)
+	reqs.sort()
+	return infos(dir, reqs)
+}
+
+// Malformed returns the errors of the lock and request files in the
+// directory which do not follow the entry naming scheme, and are
+// therefore ignored, e.g. copies made by hand
+func Malformed(dir string) []error {
+	var errs []error
+	for _, e := range *entriesTree(dir) {
+		if ft := e.filetype(); ft != lockFileType && ft != requestFileType {
+			continue
+		}
+		if _, err := e.parse(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func infos(dir string, es *entries) []Info {
+	var out []Info
+	for _, e := range *es {
+		out = append(out, e.info(dir))
+	}
+	return out
+}
+
+func (e *entry) info(dir string) Info {
+	i := Info{
+		ID:       e.ID(),
+		Name:     e.qualifiedName(dir),
+		Node:     e.node(),
+		Created:  time.Unix(0, int64(e.created())),
+		Priority: e.priority(),
+		Shared:   e.shared(),
+		Path:     e.path,
+	}
+	if at, ok := e.Expiry(); ok {
+		i.Expiry = &at
+	}
+	return i
+}
+
+// Info describes the lock, the directory being the lock directory it was found in
+func (l *Lock) Info(dir string) Info {
+	return l.info(dir)
+}
@@ -0,0 +1,84 @@
+package lock
+
+import (
+	"strconv"
+	"time"
+)
+
+// Details are the full details of a lock or request entry
+type Details struct {
+	Info
+
+	// Type is lock or request
+	Type string `json:"type"`
+
+	Age time.Duration `json:"age"`
+
+	// LastRenewed is the modification time of the entry file
+	LastRenewed time.Time `json:"last_renewed"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Format is the format of the entry file, 0 if it could not be read
+	Format EntryFormat `json:"format,omitempty"`
+
+	// MetadataError tells why the metadata could not be read, if so
+	MetadataError string `json:"metadata_error,omitempty"`
+
+	// SignatureError tells why the entry failed signature verification,
+	// only checked when a signing key is configured
+	SignatureError string `json:"signature_error,omitempty"`
+
+	// PID is the owner process, 0 if unknown
+	PID int `json:"pid,omitempty"`
+
+	// PIDAlive tells if the owner process is running, only
+	// known when the owner node is the current node
+	PIDAlive *bool `json:"pid_alive,omitempty"`
+}
+
+// Inspect returns the details of the lock or request with the given ID
+func Inspect(dir, id string) (*Details, error) {
+	found := entriesTree(dir).filter(func(e entry) bool {
+		ft := e.filetype()
+		return (ft == lockFileType || ft == requestFileType) && e.ID() == id
+	})
+
+	if len(*found) == 0 {
+		return nil, &NotFoundErr{id, dir, "lock or request"}
+	}
+
+	e := (*found)[0]
+	d := &Details{
+		Info: e.info(dir),
+		Type: "lock",
+	}
+	if e.filetype() == requestFileType {
+		d.Type = "request"
+	}
+	d.Age = since(d.Created)
+
+	if fi, err := e.conf().filesystem().Stat(e.path); err == nil {
+		d.LastRenewed = fi.ModTime()
+	}
+
+	meta, format, _, err := e.readBody()
+	if err != nil {
+		d.MetadataError = err.Error()
+	}
+	d.Metadata = meta
+	d.Format = format
+
+	if err := e.verify(); err != nil {
+		d.SignatureError = err.Error()
+	}
+
+	if pid, err := strconv.Atoi(meta[MetaPID]); err == nil && pid > 0 {
+		d.PID = pid
+		if e.node() == currentNode() {
+			alive := processAlive(pid)
+			d.PIDAlive = &alive
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,309 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations of the journal
+const (
+	// JournalRequest is a request being created, or waiting
+	JournalRequest = "request"
+
+	// JournalLock is a lock being created, until the acquisition completes
+	JournalLock = "lock"
+
+	// JournalRelease is a lock being released
+	JournalRelease = "release"
+)
+
+// JournalRecord is an operation of the journal not known to be completed
+type JournalRecord struct {
+	Op   string    `json:"op"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// Recovered is an operation of the journal recovered by Recover
+type Recovered struct {
+	JournalRecord
+
+	// Action is what was done: "rolled back" for the requests and the locks
+	// of incomplete acquisitions, which are removed, "completed" for the
+	// releases, or "none" if the entry was already gone
+	Action string
+
+	// Err is the error of the action, the record being kept in the journal
+	Err error
+}
+
+// SetJournal sets the journal file, see Configuration.Journal
+func SetJournal(path string) {
+	setConfig(func(c *Configuration) { c.Journal = path })
+}
+
+var (
+	// journalMu serializes the updates of the journals of the process
+	journalMu sync.Mutex
+
+	// journalRecovered are the journals recovered by the process,
+	// recovery being done before their first update
+	journalRecovered = map[string]bool{}
+
+	// journalWritten are the journals updated by the process, its own
+	// file then holding live operations
+	journalWritten = map[string]bool{}
+)
+
+// Recover finishes or rolls back the operations left in the journal of
+// the configuration by the processes which crashed, see Configuration.Journal
+func Recover() ([]Recovered, error) {
+	c := configCopy()
+	return c.Recover()
+}
+
+// Recover finishes or rolls back the operations left in the journal by
+// the processes which crashed, returning what was done. The files of the
+// running processes are left alone.
+func (c *Configuration) Recover() ([]Recovered, error) {
+	if len(c.Journal) == 0 {
+		return nil, errors.New("no journal configured")
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	journalRecovered[c.Journal] = true
+	return c.recoverJournal()
+}
+
+// journalPath returns the file of the journal of the process, each
+// process recording its operations in its own file, suffixed by its PID
+func (c *Configuration) journalPath() string {
+	return fmt.Sprintf("%s.%d", c.Journal, os.Getpid())
+}
+
+// deadJournals returns the files of the journal of the processes which
+// are not running anymore, and of the process itself if it did not write
+// to it yet, the file then being left by a previous process with its PID
+func (c *Configuration) deadJournals() ([]string, error) {
+	matches, err := filepath.Glob(c.Journal + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var dead []string
+	for _, path := range matches {
+		pid, err := strconv.Atoi(strings.TrimPrefix(path, c.Journal+"."))
+		switch {
+		case err != nil || pid <= 0:
+			// not a journal file
+		case pid == os.Getpid() && journalWritten[c.Journal]:
+		case pid == os.Getpid() || !processAlive(pid):
+			dead = append(dead, path)
+		}
+	}
+	return dead, nil
+}
+
+func (c *Configuration) recoverJournal() ([]Recovered, error) {
+	paths, err := c.deadJournals()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Recovered
+	for _, path := range paths {
+		recovered, err := c.recoverFile(path)
+		done = append(done, recovered...)
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// recoverFile recovers the operations of a journal file, removing it
+// unless some failed
+func (c *Configuration) recoverFile(path string) ([]Recovered, error) {
+	records, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Recovered
+	var pending []JournalRecord
+	for _, rec := range records {
+		r := c.recoverRecord(rec)
+		if r.Err != nil {
+			pending = append(pending, rec)
+			c.logger().Warn("failed to recover journal operation", "op", rec.Op, "path", rec.Path, "error", r.Err)
+		} else {
+			c.logger().Info("recovered journal operation", "op", rec.Op, "path", rec.Path, "action", r.Action)
+		}
+		done = append(done, r)
+	}
+	return done, writeJournal(path, pending)
+}
+
+// recoverRecord rolls back the acquisition or completes the release
+func (c *Configuration) recoverRecord(rec JournalRecord) Recovered {
+	r := Recovered{JournalRecord: rec, Action: "rolled back"}
+	e := entry{rec.Path, c}
+
+	switch rec.Op {
+	case JournalRequest, JournalLock:
+	case JournalRelease:
+		r.Action = "completed"
+	default:
+		r.Err = fmt.Errorf("unknown journal operation %q", rec.Op)
+		return r
+	}
+
+	err := c.filesystem().Remove(rec.Path)
+	switch {
+	case os.IsNotExist(err):
+		r.Action = "none"
+	case err != nil:
+		r.Err = &BackendErr{err}
+	case e.filetype() == lockFileType:
+		_ = c.markReleased(e.dir())
+		if rec.Op == JournalRelease {
+			c.audit(e.dir(), AuditRelease, e.name(), e.ID(), "completed by journal recovery")
+		}
+	}
+	return r
+}
+
+// journal records the operation on the entry before it is done, if the
+// configuration has a journal. Failures are logged but not returned, the
+// journal only helping recovery.
+func (c *Configuration) journal(op, path string) {
+	if len(c.Journal) == 0 {
+		return
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	// the operations left by a previous run are recovered first
+	if !journalRecovered[c.Journal] {
+		journalRecovered[c.Journal] = true
+		if _, err := c.recoverJournal(); err != nil {
+			c.logger().Warn("failed to recover journal", "path", c.Journal, "error", err)
+		}
+	}
+
+	journalWritten[c.Journal] = true
+	err := c.updateJournal(func(records []JournalRecord) []JournalRecord {
+		return append(records, JournalRecord{op, path, c.clock().Now().UTC()})
+	})
+	if err != nil {
+		c.logger().Warn("failed to write journal", "path", c.Journal, "error", err)
+	}
+}
+
+// unjournal removes the operations on the entries, completed or
+// abandoned, from the journal
+func (c *Configuration) unjournal(paths ...string) {
+	if len(c.Journal) == 0 {
+		return
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	err := c.updateJournal(func(records []JournalRecord) []JournalRecord {
+		kept := records[:0]
+		for _, rec := range records {
+			found := false
+			for _, p := range paths {
+				found = found || rec.Path == p
+			}
+			if !found {
+				kept = append(kept, rec)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		c.logger().Warn("failed to write journal", "path", c.Journal, "error", err)
+	}
+}
+
+// updateJournal updates the journal file of the process
+func (c *Configuration) updateJournal(update func([]JournalRecord) []JournalRecord) error {
+	path := c.journalPath()
+	records, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+
+	n := len(records)
+	records = update(records)
+	if n == 0 && len(records) == 0 {
+		return nil
+	}
+	return writeJournal(path, records)
+}
+
+// readJournal reads the records of the journal, none if it does not exist
+func readJournal(path string) ([]JournalRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []JournalRecord
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("invalid journal %s: %v", path, err)
+		}
+	}
+	return records, nil
+}
+
+// writeJournal replaces the journal atomically and flushes it to stable
+// storage, so that it is never found half written. The journal is removed
+// when it has no records left.
+func writeJournal(path string, records []JournalRecord) error {
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+partialSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = syncFile(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return syncDir(osFS{}, filepath.Dir(path))
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package lock
+
+import "os"
+
+// linkCount returns the number of hard links to the file, which
+// is not available from a FileInfo on windows
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
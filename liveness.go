@@ -0,0 +1,225 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Holder identifies the holder of a lock, as recorded in its entry
+type Holder struct {
+	ID   string
+	Name string
+	Node string
+
+	// PID is the holder process, 0 if unknown
+	PID  int
+	Path string
+}
+
+// LivenessChecker tells whether the holder of a lock is still alive. An
+// error means that it cannot tell, in which case the holder is assumed alive.
+type LivenessChecker interface {
+	Alive(h Holder) (bool, error)
+}
+
+// LivenessFunc adapts a function to a LivenessChecker
+type LivenessFunc func(h Holder) (bool, error)
+
+func (f LivenessFunc) Alive(h Holder) (bool, error) {
+	return f(h)
+}
+
+// errUnknownLiveness is returned by checkers which cannot probe the holder
+var errUnknownLiveness = errors.New("holder liveness unknown")
+
+// PIDChecker checks the holder process of locks held from the current node,
+// telling nothing about the other nodes. The holder process must be the one
+// keeping the lock, which is not the case of `lock acquire`.
+type PIDChecker struct{}
+
+func (PIDChecker) Alive(h Holder) (bool, error) {
+	if h.Node != currentNode() || h.PID <= 0 {
+		return false, errUnknownLiveness
+	}
+	return processAlive(h.PID), nil
+}
+
+// HTTPChecker probes a health endpoint on the holder node: the URL, e.g.
+// http://{node}:8080/healthz, has {node}, {pid} and {id} replaced with
+// those of the holder. A 2xx answer means alive, any other answer dead.
+// Unreachable nodes are assumed alive unless DeadIfUnreachable is set.
+type HTTPChecker struct {
+	URL               string
+	Timeout           time.Duration
+	DeadIfUnreachable bool
+}
+
+func (c HTTPChecker) Alive(h Holder) (bool, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	resp, err := (&http.Client{Timeout: timeout}).Get(expandHolder(c.URL, h))
+	if err != nil {
+		if c.DeadIfUnreachable {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to probe holder %s: %v", h.Node, err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
+// CommandChecker runs a command to probe the holder, e.g. over SSH:
+//
+//	[]string{"ssh", "-o", "BatchMode=yes", "{node}", "kill", "-0", "{pid}"}
+//
+// {node}, {pid} and {id} in the arguments are replaced with those of the
+// holder. Exit code 0 means alive, DeadExitCode (1 if not set) dead, and
+// anything else, e.g. 255 for an SSH failure, that it cannot tell.
+type CommandChecker struct {
+	Command      []string
+	Timeout      time.Duration
+	DeadExitCode int
+}
+
+func (c CommandChecker) Alive(h Holder) (bool, error) {
+	if len(c.Command) == 0 {
+		return false, fmt.Errorf("no liveness command configured")
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := make([]string, len(c.Command))
+	for i, a := range c.Command {
+		args[i] = expandHolder(a, h)
+	}
+
+	err := exec.CommandContext(ctx, args[0], args[1:]...).Run()
+	if err == nil {
+		return true, nil
+	}
+
+	dead := c.DeadExitCode
+	if dead == 0 {
+		dead = 1
+	}
+
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == dead && ctx.Err() == nil {
+		return false, nil
+	}
+	return false, fmt.Errorf("unable to probe holder %s: %v", h.Node, err)
+}
+
+func expandHolder(s string, h Holder) string {
+	return strings.NewReplacer(
+		"{node}", h.Node,
+		"{pid}", strconv.Itoa(h.PID),
+		"{id}", h.ID,
+	).Replace(s)
+}
+
+// holder returns the holder of the entry
+func (e *entry) holder() Holder {
+	h := Holder{ID: e.ID(), Name: e.name(), Node: e.node(), Path: e.path}
+	if meta, err := e.Metadata(); err == nil {
+		h.PID, _ = strconv.Atoi(meta[MetaPID])
+	}
+	return h
+}
+
+// removeDeadHolders removes the conflicting locks past their expiry or whose
+// holder the configured LivenessChecker finds dead, returning how many were removed
+func (c *Configuration) removeDeadHolders() int {
+	existing := c.locksNamed(c.Dir, c.Name)
+	if c.Hierarchical {
+		existing.extend(c.relatedLocks(c.Dir, c.Name))
+	}
+
+	removed := 0
+	for _, e := range *existing {
+		h := e.holder()
+		event, detail := AuditStale, fmt.Sprintf("holder %s pid %d found dead", h.Node, h.PID)
+		switch {
+		case e.lapsed():
+			// anyone able to write the directory could backdate the expiry
+			if err := e.verify(); err != nil {
+				c.logger().Warn("ignoring expiry of lock failing signature verification", "lock", h.Name, "id", h.ID, "error", err)
+				continue
+			}
+			detail = fmt.Sprintf("holder %s pid %d let the lock expire", h.Node, h.PID)
+		case c.TakeoverAfter > 0:
+			silent, ok := c.unreachableFor(e, h)
+			if !ok || silent < c.TakeoverAfter {
+				continue
+			}
+			event, detail = AuditTakeover, fmt.Sprintf("holder %s pid %d unreachable for %v", h.Node, h.PID, silent.Round(time.Second))
+		case c.Liveness == nil:
+			continue
+		default:
+			alive, err := c.Liveness.Alive(h)
+			if err != nil {
+				c.logger().Debug("holder liveness unknown, assuming alive", "lock", h.Name, "id", h.ID, "error", err)
+				continue
+			}
+			if alive {
+				continue
+			}
+		}
+
+		c.logger().Warn("removing lock of dead holder", "lock", h.Name, "id", h.ID, "node", h.Node, "pid", h.PID, "reason", detail)
+		if c.OnStaleDetected != nil {
+			c.OnStaleDetected(e.path)
+		}
+		if err := e.Remove(); err != nil {
+			c.logger().Error("failed to remove lock of dead holder", "path", e.path, "error", err)
+			continue
+		}
+		c.metrics().StaleRemoved(h.Name)
+		c.audit(e.dir(), event, h.Name, h.ID, detail)
+		removed++
+	}
+	return removed
+}
+
+// probedAlive is when the holders were last found alive by the liveness
+// checks, by lock path
+var probedAlive sync.Map
+
+// unreachableFor returns the time since the holder of the lock last showed
+// a sign of life: renewing the lock or, with Liveness, being found alive.
+// It returns false if it cannot tell, e.g. the lock being gone.
+func (c *Configuration) unreachableFor(e entry, h Holder) (time.Duration, bool) {
+	info, err := c.filesystem().Stat(e.path)
+	if err != nil {
+		return 0, false
+	}
+	now := c.clock().Now()
+	seen := info.ModTime()
+
+	if c.Liveness != nil {
+		if alive, err := c.Liveness.Alive(h); err == nil && alive {
+			probedAlive.Store(e.path, now)
+			return 0, true
+		}
+		if at, ok := probedAlive.Load(e.path); ok && at.(time.Time).After(seen) {
+			seen = at.(time.Time)
+		}
+	}
+	return now.Sub(seen), true
+}
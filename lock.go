@@ -0,0 +1,153 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lock is a lock file held by the caller
+type Lock struct {
+	entry
+}
+
+// Attach returns the lock with the given ID (the token printed by
+// `lock acquire`) in the given directory, so that a lock acquired by a
+// previous process can be renewed and released by another one.
+// If a signing key is configured, the lock must be signed with it.
+func Attach(token, dir string) (*Lock, error) {
+	l, err := findLock(token, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.verify(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func findLock(token, dir string) (*Lock, error) {
+	found := entriesTree(dir).withFiletype(lockFileType).filter(func(e entry) bool {
+		return e.ID() == token
+	})
+
+	switch len(*found) {
+	case 0:
+		return nil, &NotFoundErr{token, dir, "lock"}
+	case 1:
+		return &Lock{(*found)[0]}, nil
+	default:
+		return nil, fmt.Errorf("%d locks with ID %s found in %s", len(*found), token, dir)
+	}
+}
+
+// ReleaseAll removes every lock and request of the given name, whoever
+// holds them, e.g. to recover from a wedged queue after an outage. The locks
+// of parent and child names are left alone. It returns the number of entries
+// removed, and keeps going on failure, reporting all of them.
+func ReleaseAll(name, dir string) (int, error) {
+	if err := ValidateName(name); err != nil {
+		return 0, err
+	}
+
+	all := locksNamed(dir, name).extend(requestsNamed(dir, name))
+	detail := fmt.Sprintf("released all by %s@%s", currentUser(), currentNode())
+
+	removed := 0
+	var errs []error
+	for _, e := range *all {
+		if err := e.Remove(); err != nil {
+			errs = append(errs, fmt.Errorf("unable to remove %s: %w", e.path, err))
+			continue
+		}
+		removed++
+
+		if e.filetype() == lockFileType {
+			forget(&Lock{e})
+			if err := writeAudit(e.dir(), AuditRelease, name, e.ID(), detail); err != nil {
+				logger().Warn("failed to write audit log", "dir", e.dir(), "error", err)
+			}
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Release removes the lock file, giving the lock to the next in queue.
+// It fails with a NotOwnerErr if the lock was created by another user.
+func (l *Lock) Release() error {
+	if err := l.checkOwner(); err != nil {
+		return err
+	}
+	return l.ForceRelease()
+}
+
+// ForceRelease releases the lock whoever its owner
+func (l *Lock) ForceRelease() error {
+	c := l.conf()
+	held := time.Duration(c.clock().Now().UnixNano() - int64(l.created()))
+	span := c.startSpan("lock.release",
+		Attribute{"lock.name", l.name()},
+		Attribute{"lock.id", l.ID()},
+		Attribute{"lock.hold_seconds", held.Seconds()},
+	)
+	defer span.End()
+
+	forget(l)
+	c.journal(JournalRelease, l.path)
+	if err := l.Remove(); err != nil {
+		if os.IsNotExist(err) {
+			c.unjournal(l.path)
+		}
+		span.RecordError(err)
+		c.logger().Error("failed to release lock", "lock", l.name(), "id", l.ID(), "error", err)
+		return err
+	}
+	c.logger().Info("lock released", "lock", l.name(), "id", l.ID())
+	c.metrics().Released(l.name(), held)
+	c.audit(l.dir(), AuditRelease, l.name(), l.ID(), "")
+	return nil
+}
+
+// Renew updates the modification time of the lock file, and pushes back
+// its expiry if it has one, signalling that the holder is still alive.
+func (l *Lock) Renew() error {
+	return l.Touch()
+}
+
+// Heartbeat renews the lock every interval in the background until the
+// returned stop function is called. Renewal errors are passed to onError if not nil.
+// Each renewal also resolves a split brain, see Resolve: if the lock loses,
+// the error matching ErrSplitBrain is passed to onError and the renewals stop.
+func (l *Lock) Heartbeat(interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := l.Renew()
+				if err == nil {
+					err = l.Resolve()
+				}
+				if err != nil && onError != nil {
+					onError(err)
+				}
+				if errors.Is(err, ErrSplitBrain) {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,100 @@
+package lockgrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/brinick/lock/lockpb"
+)
+
+// Client talks to a lock gRPC server
+type Client struct {
+	conn *grpc.ClientConn
+	api  lockpb.LockServiceClient
+}
+
+// Dial returns a Client for the server at the given address. Without
+// options, the connection is not encrypted.
+func Dial(addr string, opts ...grpc.DialOption) (*Client, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to lock server %s: %v", addr, err)
+	}
+	return &Client{conn, lockpb.NewLockServiceClient(conn)}, nil
+}
+
+// authorizationKey is the metadata key of the token of the server
+const authorizationKey = "authorization"
+
+// WithToken returns the Dial option sending the token of the server,
+// in clear if the connection is not encrypted
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenAuth(token))
+}
+
+// tokenAuth sends the token as a bearer token
+type tokenAuth string
+
+func (t tokenAuth) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: "Bearer " + string(t)}, nil
+}
+
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return false
+}
+
+// Close closes the connection to the server
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Acquire blocks until the lock is granted, the server gives up or ctx is
+// done, calling onQueued, if not nil, with each queue position received.
+func (c *Client) Acquire(ctx context.Context, req *lockpb.AcquireRequest, onQueued func(position int)) (*lockpb.LockInfo, error) {
+	stream, err := c.api.Acquire(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		ev, err := stream.Recv()
+		switch {
+		case err == io.EOF:
+			return nil, fmt.Errorf("lock server ended the stream without granting the lock")
+		case err != nil:
+			return nil, err
+		}
+
+		if q := ev.GetQueued(); q != nil && onQueued != nil {
+			onQueued(int(q.Position))
+		}
+		if lck := ev.GetAcquired(); lck != nil {
+			return lck, nil
+		}
+	}
+}
+
+// Release releases the lock with the given ID
+func (c *Client) Release(ctx context.Context, id string) error {
+	_, err := c.api.Release(ctx, &lockpb.ReleaseRequest{Id: id})
+	return err
+}
+
+// Renew renews the lock with the given ID
+func (c *Client) Renew(ctx context.Context, id string) error {
+	_, err := c.api.Renew(ctx, &lockpb.RenewRequest{Id: id})
+	return err
+}
+
+// List lists the held locks and, if includeRequests, the pending requests
+func (c *Client) List(ctx context.Context, includeRequests bool) (*lockpb.ListResponse, error) {
+	return c.api.List(ctx, &lockpb.ListRequest{IncludeRequests: includeRequests})
+}
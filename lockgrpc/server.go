@@ -0,0 +1,224 @@
+// Package lockgrpc serves the locks of a lock directory over gRPC,
+// using the API defined in the lockpb package, and provides a client for it.
+package lockgrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/lockpb"
+)
+
+// Server implements the lockpb.LockServiceServer for a lock directory.
+// Each call works on its own copy of Config, so that acquisitions run
+// concurrently. The lock IDs are only returned to the clients acquiring
+// the locks, List leaving them out, so that only these clients can renew
+// and release them.
+type Server struct {
+	lockpb.UnimplementedLockServiceServer
+
+	// Config is the base configuration of the acquired locks,
+	// its Dir is the lock directory served
+	Config lock.Configuration
+
+	// Token, if set, must be sent by the clients as a bearer token in the
+	// authorization metadata, see WithToken
+	Token string
+}
+
+// NewServer returns a Server for the locks in the given directory
+func NewServer(dir string) *Server {
+	cfg := lock.DefaultConfig()
+	cfg.Dir = dir
+	return &Server{Config: cfg}
+}
+
+// ListenAndServe serves the locks over gRPC at the given address
+func (s *Server) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer()
+	lockpb.RegisterLockServiceServer(srv, s)
+	return srv.Serve(lis)
+}
+
+func (s *Server) Acquire(req *lockpb.AcquireRequest, stream lockpb.LockService_AcquireServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+
+	cfg := s.Config
+	if len(req.Name) > 0 {
+		cfg.Name = req.Name
+	}
+	if req.PollInterval > 0 {
+		cfg.PollInterval = time.Duration(req.PollInterval) * time.Second
+	}
+	if req.MaxWait != 0 {
+		// negative waits forever
+		cfg.MaxWait = time.Duration(req.MaxWait) * time.Second
+	}
+	if deadline, ok := stream.Context().Deadline(); ok {
+		if left := time.Until(deadline); cfg.MaxWait < 0 || left < cfg.MaxWait {
+			cfg.MaxWait = left
+		}
+	}
+	cfg.Priority = int(req.Priority)
+	cfg.Metadata = req.Metadata
+
+	var sendErr error
+	cfg.OnWaiting = func(pos int) {
+		if sendErr != nil {
+			return
+		}
+		sendErr = stream.Send(&lockpb.AcquireEvent{
+			Event: &lockpb.AcquireEvent_Queued{Queued: &lockpb.Queued{Position: int32(pos)}},
+		})
+	}
+
+	lck, err := lock.AcquireContext(stream.Context(), cfg.Name, lock.WithConfig(cfg))
+
+	if err != nil {
+		return status.Error(errorCode(err, codes.DeadlineExceeded), err.Error())
+	}
+
+	if sendErr == nil {
+		sendErr = stream.Send(&lockpb.AcquireEvent{
+			Event: &lockpb.AcquireEvent_Acquired{Acquired: toProto(lck.Info(s.Config.Dir))},
+		})
+	}
+
+	if sendErr != nil {
+		// the client went away, don't leave the lock behind
+		lck.Release()
+		return sendErr
+	}
+	return nil
+}
+
+func (s *Server) Release(ctx context.Context, req *lockpb.ReleaseRequest) (*lockpb.ReleaseResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &lockpb.ReleaseResponse{}, s.withLock(req.Id, (*lock.Lock).Release)
+}
+
+func (s *Server) Renew(ctx context.Context, req *lockpb.RenewRequest) (*lockpb.RenewResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &lockpb.RenewResponse{}, s.withLock(req.Id, (*lock.Lock).Renew)
+}
+
+// List lists the held locks and, if asked, the pending requests, without
+// their IDs, also in their paths, which are left to their acquirers
+func (s *Server) List(ctx context.Context, req *lockpb.ListRequest) (*lockpb.ListResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	m := s.manager()
+	held, err := m.Locks()
+	if err != nil {
+		return nil, status.Error(errorCode(err, codes.Internal), err.Error())
+	}
+
+	resp := &lockpb.ListResponse{}
+	for _, l := range held {
+		resp.Locks = append(resp.Locks, anonymous(toProto(m.Info(l))))
+	}
+
+	if req.IncludeRequests {
+		reqs, err := m.Requests()
+		if err != nil {
+			return nil, status.Error(errorCode(err, codes.Internal), err.Error())
+		}
+		for _, r := range reqs {
+			resp.Requests = append(resp.Requests, anonymous(toProto(m.Info(r))))
+		}
+	}
+	return resp, nil
+}
+
+// authorize checks the call carries the Token, if any
+func (s *Server) authorize(ctx context.Context) error {
+	if len(s.Token) == 0 {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get(authorizationKey) {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+// anonymous returns the lock info without its ID
+func anonymous(info *lockpb.LockInfo) *lockpb.LockInfo {
+	info.Id, info.Path = "", ""
+	return info
+}
+
+// manager returns a Manager of the lock directory with a copy of Config
+func (s *Server) manager() *lock.Manager {
+	cfg := s.Config
+	return &lock.Manager{Config: &cfg}
+}
+
+func (s *Server) withLock(id string, action func(*lock.Lock) error) error {
+	lck, err := s.manager().Attach(id)
+	if err != nil {
+		return status.Error(errorCode(err, codes.NotFound), err.Error())
+	}
+
+	if err := action(lck); err != nil {
+		return status.Error(errorCode(err, codes.Internal), err.Error())
+	}
+	return nil
+}
+
+// errorCode returns the gRPC code matching the lock error,
+// the given default one for other errors
+func errorCode(err error, default_ codes.Code) codes.Code {
+	switch {
+	case errors.Is(err, lock.ErrTimeout):
+		return codes.DeadlineExceeded
+	case errors.Is(err, lock.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, lock.ErrNotOwner):
+		return codes.PermissionDenied
+	case errors.Is(err, lock.ErrQueueFull):
+		return codes.ResourceExhausted
+	case errors.Is(err, lock.ErrBackend):
+		return codes.Unavailable
+	default:
+		return default_
+	}
+}
+
+func toProto(i lock.Info) *lockpb.LockInfo {
+	return &lockpb.LockInfo{
+		Id:              i.ID,
+		Name:            i.Name,
+		Node:            i.Node,
+		CreatedUnixNano: i.Created.UnixNano(),
+		Priority:        int32(i.Priority),
+		Path:            i.Path,
+	}
+}
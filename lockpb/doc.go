@@ -0,0 +1,5 @@
+// Package lockpb holds the protobuf API of the lock gRPC service,
+// generated from lock.proto.
+package lockpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative lock.proto
@@ -0,0 +1,86 @@
+package locktest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a lock.Clock whose time only moves when told to, so that
+// timeouts and long waits can be simulated instantly
+type FakeClock struct {
+	// AutoAdvance makes every wait move the time forward by the waited
+	// duration and return at once, e.g. to time out an Acquire instantly
+	AutoAdvance bool
+
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the fake time once it has moved forward by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.AutoAdvance && d > 0 {
+		c.now = c.now.Add(d)
+		c.fire()
+	}
+
+	if c.AutoAdvance || d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{c.now.Add(d), ch})
+	return ch
+}
+
+// Advance moves the time forward by d, waking up the waits which are over
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Waiters returns the number of pending waits, e.g. to advance the
+// time only once the code under test is waiting
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// fire wakes up the waits which are over, the lock being held
+func (c *FakeClock) fire() {
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
@@ -0,0 +1,54 @@
+// Package locktest provides helpers to test code using locks hermetically:
+// throwaway lock directories, a fake clock, and an in-memory client.Locker.
+package locktest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// Dir returns a new empty lock directory, removed when the test ends
+func Dir(t testing.TB) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "locks")
+}
+
+// Config returns a configuration for the named lock in a new lock directory,
+// polling every 10ms and giving up after a second, so that tests are quick
+func Config(t testing.TB, name string) *lock.Configuration {
+	t.Helper()
+
+	cfg := lock.DefaultConfig()
+	cfg.Dir = Dir(t)
+	cfg.Name = name
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.MaxWait = time.Second
+	return &cfg
+}
+
+// HoldLock acquires the named lock in the given lock directory, failing
+// the test if it cannot, and releases it when the test ends. Use it to
+// check how the code under test behaves when the lock is busy.
+func HoldLock(t testing.TB, dir, name string) *lock.Lock {
+	t.Helper()
+
+	cfg := lock.DefaultConfig()
+	cfg.Dir = dir
+	cfg.Name = name
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.MaxWait = time.Second
+
+	lck, err := lock.Acquire(&cfg)
+	if err != nil {
+		t.Fatalf("unable to hold lock %s in %s: %v", name, dir, err)
+	}
+
+	t.Cleanup(func() {
+		// the test may have released or broken the lock itself
+		lck.ForceRelease()
+	})
+	return lck
+}
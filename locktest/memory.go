@@ -0,0 +1,138 @@
+package locktest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brinick/lock"
+	"github.com/brinick/lock/client"
+)
+
+// MemoryLocker is a client.Locker keeping its locks in memory, for testing
+// code written against the client package without a lock directory or server.
+// Of the configuration, only the name, maximum wait, MaxLocks and Clock are used.
+type MemoryLocker struct {
+	mu      sync.Mutex
+	held    map[string]map[string]bool
+	changed chan struct{}
+	nextID  int
+}
+
+// NewMemoryLocker returns an empty MemoryLocker
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		held:    map[string]map[string]bool{},
+		changed: make(chan struct{}),
+	}
+}
+
+var _ client.Locker = (*MemoryLocker)(nil)
+
+// Acquire waits until fewer than MaxLocks locks are held on the name,
+// failing with an error matching lock.ErrTimeout after the maximum wait
+func (m *MemoryLocker) Acquire(ctx context.Context, cfg lock.Configuration) (client.Lock, error) {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	maxLocks := cfg.MaxLocks
+	if maxLocks < 1 {
+		maxLocks = lock.DefaultMaxLocks
+	}
+
+	// try once with a 0 max wait, never time out with a negative one
+	var timeout <-chan time.Time
+	if cfg.MaxWait >= 0 {
+		timeout = clock.After(cfg.MaxWait)
+	}
+	for {
+		m.mu.Lock()
+		if len(m.held[cfg.Name]) < maxLocks {
+			m.nextID++
+			id := fmt.Sprintf("mem%d", m.nextID)
+			if m.held[cfg.Name] == nil {
+				m.held[cfg.Name] = map[string]bool{}
+			}
+			m.held[cfg.Name][id] = true
+			m.mu.Unlock()
+			return &memoryLock{m, cfg.Name, id}, nil
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return nil, &lock.TimeoutErr{MaxWait: cfg.MaxWait, Waiting: "waiting for existing lock to be released"}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close does nothing
+func (m *MemoryLocker) Close() error {
+	return nil
+}
+
+// Held returns the number of locks held on the name
+func (m *MemoryLocker) Held(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.held[name])
+}
+
+// release removes the lock, waking up the waiting acquisitions
+func (m *MemoryLocker) release(name, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held[name][id] {
+		return &lock.NotFoundErr{ID: id, Dir: "memory", What: "lock"}
+	}
+	delete(m.held[name], id)
+
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return nil
+}
+
+func (m *MemoryLocker) isHeld(name, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.held[name][id]
+}
+
+type memoryLock struct {
+	locker *MemoryLocker
+	name   string
+	id     string
+}
+
+func (l *memoryLock) ID() string {
+	return l.id
+}
+
+func (l *memoryLock) Renew(context.Context) error {
+	if !l.locker.isHeld(l.name, l.id) {
+		return &lock.NotFoundErr{ID: l.id, Dir: "memory", What: "lock"}
+	}
+	return nil
+}
+
+func (l *memoryLock) Release(context.Context) error {
+	return l.locker.release(l.name, l.id)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,35 @@
+package lock
+
+// Logger receives the events of the package, as a message followed by
+// alternating key/value pairs. A *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// SetLogger sets the logger used outside of Acquire, e.g. by Release
+// or Purge, nil discarding everything.
+func SetLogger(l Logger) {
+	setConfig(func(c *Configuration) { c.Logger = l })
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// logger returns the configured Logger, or one discarding everything
+func (c *Configuration) logger() Logger {
+	if c.Logger == nil {
+		return nopLogger{}
+	}
+	return c.Logger
+}
+
+func logger() Logger {
+	return currentConfig().logger()
+}
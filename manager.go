@@ -0,0 +1,227 @@
+package lock
+
+import (
+	"fmt"
+	"io/fs"
+	"iter"
+	"time"
+)
+
+// Entry is a lock or request found by a Manager, a *Lock or a *Request
+type Entry interface {
+	ID() string
+	Path() string
+	Metadata() (map[string]string, error)
+	Owner() (Owner, error)
+	Expiry() (time.Time, bool)
+}
+
+// Manager inspects the locks and requests of a lock directory, so that
+// tools can be built on the library rather than listing the files. The
+// listings fail with an UnavailableErr if the directory cannot be read.
+type Manager struct {
+	// Config is the configuration of the directory, its Dir being the one
+	// inspected, read with its FS, e.g. of a remote directory
+	Config *Configuration
+}
+
+// NewManager returns the Manager of the given lock directory, with a
+// copy of the package configuration
+func NewManager(dir string) *Manager {
+	cfg := configCopy()
+	cfg.Dir = dir
+	return &Manager{Config: &cfg}
+}
+
+// Locks returns the locks held in the directory
+func (m *Manager) Locks() ([]*Lock, error) {
+	items, err := m.Config.readEntriesTree(m.Config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return m.locks(items.withFiletype(lockFileType)), nil
+}
+
+// Requests returns the pending requests of the directory, in queue order
+func (m *Manager) Requests() ([]*Request, error) {
+	items, err := m.Config.readEntriesTree(m.Config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	reqs := items.withFiletype(requestFileType)
+	reqs.sort()
+	return m.requests(reqs), nil
+}
+
+// Queue returns the pending requests of the given lock name, in queue order
+func (m *Manager) Queue(name string) ([]*Request, error) {
+	reqs, err := m.Config.readNamed(m.Config.Dir, name, requestFileType)
+	if err != nil {
+		return nil, err
+	}
+	reqs.sort()
+	return m.requests(reqs), nil
+}
+
+// Holders returns the locks held on the given lock name
+func (m *Manager) Holders(name string) ([]*Lock, error) {
+	held, err := m.Config.readNamed(m.Config.Dir, name, lockFileType)
+	if err != nil {
+		return nil, err
+	}
+	return m.locks(held), nil
+}
+
+// Find returns the lock or request with the given ID, a NotFoundErr if none
+func (m *Manager) Find(id string) (Entry, error) {
+	items, err := m.Config.readEntriesTree(m.Config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Entry
+	for _, l := range m.locks(items.withFiletype(lockFileType)) {
+		if l.ID() == id {
+			found = append(found, l)
+		}
+	}
+	for _, r := range m.requests(items.withFiletype(requestFileType)) {
+		if r.ID() == id {
+			found = append(found, r)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, &NotFoundErr{id, m.Config.Dir, "lock or request"}
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%d entries with ID %s found in %s", len(found), id, m.Config.Dir)
+	}
+}
+
+// Attach returns the held lock with the given ID, like Attach, checking
+// its signature if the configuration has a SigningKey
+func (m *Manager) Attach(id string) (*Lock, error) {
+	held, err := m.Locks()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Lock
+	for _, l := range held {
+		if l.ID() == id {
+			found = append(found, l)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, &NotFoundErr{id, m.Config.Dir, "lock"}
+	case 1:
+		return found[0], found[0].verify()
+	default:
+		return nil, fmt.Errorf("%d locks with ID %s found in %s", len(found), id, m.Config.Dir)
+	}
+}
+
+func (m *Manager) locks(es *entries) []*Lock {
+	var out []*Lock
+	for _, e := range *es {
+		out = append(out, &Lock{e})
+	}
+	return out
+}
+
+func (m *Manager) requests(es *entries) []*Request {
+	var out []*Request
+	for _, e := range *es {
+		out = append(out, &Request{e})
+	}
+	return out
+}
+
+// Info describes the lock or request, with its full hierarchical name
+func (m *Manager) Info(e Entry) Info {
+	ee := entry{e.Path(), m.Config}
+	return ee.info(m.Config.Dir)
+}
+
+// EntryFilter selects the entries yielded by Manager.Entries, the zero
+// value selecting all of them
+type EntryFilter struct {
+	// Name is the lock name, its children excluded
+	Name string
+
+	// Node is the node of the entry owners
+	Node string
+
+	// Type is lock or request
+	Type string
+
+	// MinAge and MaxAge bound the age of the entries, since their creation
+	MinAge time.Duration
+	MaxAge time.Duration
+}
+
+func (f EntryFilter) accept(e entry) bool {
+	c := e.conf()
+	switch ft := e.filetype(); {
+	case ft != lockFileType && ft != requestFileType:
+		return false
+	case f.Type == "lock" && ft != lockFileType, f.Type == "request" && ft != requestFileType:
+		return false
+	}
+	if !e.valid() {
+		return false
+	}
+	if len(f.Name) > 0 && e.name() != leafName(f.Name) {
+		return false
+	}
+	if len(f.Node) > 0 && e.node() != f.Node {
+		return false
+	}
+
+	age := c.since(time.Unix(0, int64(e.created())))
+	return (f.MinAge <= 0 || age >= f.MinAge) && (f.MaxAge <= 0 || age <= f.MaxAge)
+}
+
+// Entries iterates over the locks and requests of the directory selected
+// by the filter, in directory order, reading the directory as it goes
+// rather than listing it first, for very large lock directories.
+func (m *Manager) Entries(f EntryFilter) iter.Seq[Entry] {
+	root := m.Config.Dir
+	if len(f.Name) > 0 {
+		root = nameDir(m.Config.Dir, f.Name)
+	}
+
+	return func(yield func(Entry) bool) {
+		walkDir(m.Config.filesystem(), root, func(p string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				// unreadable parts of the tree are skipped
+				return nil
+			case d.IsDir():
+				if len(f.Name) > 0 && p != root {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			e := entry{p, m.Config}
+			if !f.accept(e) {
+				return nil
+			}
+
+			var item Entry = &Lock{e}
+			if e.filetype() == requestFileType {
+				item = &Request{e}
+			}
+			if !yield(item) {
+				return fs.SkipAll
+			}
+			return nil
+		})
+	}
+}
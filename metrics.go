@@ -0,0 +1,42 @@
+package lock
+
+import "time"
+
+// Metrics receives measurements of the lock operations,
+// see the metrics subpackage for a Prometheus implementation.
+type Metrics interface {
+	// AcquireAttempt is called each time Acquire is called
+	AcquireAttempt(name string)
+
+	// Acquired is called when a lock is obtained, with the time waited for it
+	Acquired(name string, wait time.Duration)
+
+	// TimedOut is called when Acquire gives up waiting
+	TimedOut(name string)
+
+	// Released is called when a lock is released, with the time it was held for
+	Released(name string, held time.Duration)
+
+	// StaleRemoved is called when a stale entry is cleaned up
+	StaleRemoved(name string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AcquireAttempt(string)          {}
+func (nopMetrics) Acquired(string, time.Duration) {}
+func (nopMetrics) TimedOut(string)                {}
+func (nopMetrics) Released(string, time.Duration) {}
+func (nopMetrics) StaleRemoved(string)            {}
+
+// metrics returns the configured Metrics, or one discarding everything
+func (c *Configuration) metrics() Metrics {
+	if c.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.Metrics
+}
+
+func metrics() Metrics {
+	return currentConfig().metrics()
+}
@@ -0,0 +1,159 @@
+// Package metrics exposes the measurements of the lock package
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// DefaultBuckets are the histogram upper bounds, in seconds, used for
+// wait and hold durations: from one second to a day.
+var DefaultBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 24 * 3600}
+
+// Registry collects the lock metrics and serves them to Prometheus.
+// It implements lock.Metrics.
+type Registry struct {
+	mu sync.Mutex
+
+	attempts      map[string]float64
+	timeouts      map[string]float64
+	staleCleanups map[string]float64
+	wait          map[string]*histogram
+	hold          map[string]*histogram
+}
+
+var _ lock.Metrics = (*Registry)(nil)
+
+// New returns an empty Registry
+func New() *Registry {
+	return &Registry{
+		attempts:      map[string]float64{},
+		timeouts:      map[string]float64{},
+		staleCleanups: map[string]float64{},
+		wait:          map[string]*histogram{},
+		hold:          map[string]*histogram{},
+	}
+}
+
+func (r *Registry) AcquireAttempt(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts[name]++
+}
+
+func (r *Registry) Acquired(name string, wait time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	observe(r.wait, name, wait)
+}
+
+func (r *Registry) TimedOut(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts[name]++
+}
+
+func (r *Registry) Released(name string, held time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	observe(r.hold, name, held)
+}
+
+func (r *Registry) StaleRemoved(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.staleCleanups[name]++
+}
+
+// Handler returns an http.Handler serving the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+// ListenAndServe serves the metrics on /metrics at the given address
+func (r *Registry) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	writeCounter(&b, "lock_acquire_attempts_total", "Number of lock acquisition attempts.", r.attempts)
+	writeCounter(&b, "lock_timeouts_total", "Number of lock acquisitions which timed out.", r.timeouts)
+	writeCounter(&b, "lock_stale_cleanups_total", "Number of stale entries removed.", r.staleCleanups)
+	writeHistogram(&b, "lock_wait_seconds", "Time waited to acquire a lock.", r.wait)
+	writeHistogram(&b, "lock_hold_seconds", "Time a lock was held for.", r.hold)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ----------------------------------------------------------------------
+
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+func observe(h map[string]*histogram, name string, d time.Duration) {
+	hist, ok := h[name]
+	if !ok {
+		hist = &histogram{counts: make([]float64, len(DefaultBuckets))}
+		h[name] = hist
+	}
+
+	secs := d.Seconds()
+	for i, le := range DefaultBuckets {
+		if secs <= le {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += secs
+	hist.count++
+}
+
+func writeCounter(b *strings.Builder, metric, help string, values map[string]float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+	for _, name := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{lock=%q} %v\n", metric, name, values[name])
+	}
+}
+
+func writeHistogram(b *strings.Builder, metric, help string, values map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", metric, help, metric)
+	for _, name := range sortedKeys(values) {
+		h := values[name]
+		for i, le := range DefaultBuckets {
+			fmt.Fprintf(b, "%s_bucket{lock=%q,le=\"%v\"} %v\n", metric, name, le, h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{lock=%q,le=\"+Inf\"} %v\n", metric, name, h.count)
+		fmt.Fprintf(b, "%s_sum{lock=%q} %v\n", metric, name, h.sum)
+		fmt.Fprintf(b, "%s_count{lock=%q} %v\n", metric, name, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
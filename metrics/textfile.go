@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// WriteTextfile writes the current lock and queue gauges of the lock
+// directory to the file at path, for the node_exporter textfile collector,
+// whose directory it should be in and end with .prom. The file is replaced
+// atomically, the collector never reading it half written.
+func WriteTextfile(path, dir string) error {
+	held := map[string]float64{}
+	queued := map[string]float64{}
+	oldest := map[string]float64{}
+
+	now := time.Now()
+	for _, i := range lock.List(dir) {
+		held[i.Name]++
+	}
+	for _, i := range lock.ListRequests(dir) {
+		queued[i.Name]++
+		oldest[i.Name] = max(oldest[i.Name], now.Sub(i.Created).Seconds())
+	}
+
+	var b strings.Builder
+	writeGauge(&b, "lock_held", "Number of holders of the lock.", held)
+	writeGauge(&b, "lock_queue_length", "Number of requests waiting for the lock.", queued)
+	writeGauge(&b, "lock_oldest_request_age_seconds", "Time the oldest request for the lock has been waiting.", oldest)
+	fmt.Fprintf(&b, "# HELP lock_textfile_timestamp_seconds Time the lock gauges were written.\n")
+	fmt.Fprintf(&b, "# TYPE lock_textfile_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "lock_textfile_timestamp_seconds %v\n", float64(now.UnixMilli())/1000)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// readable by node_exporter, CreateTemp making the file private
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeGauge(b *strings.Builder, metric, help string, values map[string]float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", metric, help, metric)
+	for _, name := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s{lock=%q} %v\n", metric, name, values[name])
+	}
+}
@@ -0,0 +1,296 @@
+// Package mqtt publishes the lock events to an MQTT broker, as a
+// lock.EventSink, for lightweight integrations e.g. in lab and IoT
+// environments. It speaks MQTT 3.1.1 and publishes with QoS 0 or 1.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// DefaultTopic is the topic of the events if the broker URL has no path
+const DefaultTopic = "lock/{lock}/{event}"
+
+// keepAlive is the keep alive interval sent to the broker
+const keepAlive = 60 * time.Second
+
+// Publisher publishes the lock events as JSON to an MQTT broker,
+// connecting on the first event and reconnecting after failures
+type Publisher struct {
+	// Addr is the host:port of the broker
+	Addr string
+
+	// TLS connects with TLS
+	TLS bool
+
+	// Topic is the topic of the events, in which {lock} is replaced by
+	// the lock name and {event} by the event
+	Topic string
+
+	// QoS is 0, at most once, or 1, at least once
+	QoS byte
+
+	Username string
+	Password string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	r        *bufio.Reader
+	packetID uint16
+}
+
+var _ lock.EventSink = (*Publisher)(nil)
+
+// New returns the Publisher of the mqtt[s]://[user[:password]@]host[:port][/topic][?qos=1]
+// URL, the port defaulting to 1883, 8883 with TLS, and the topic to DefaultTopic
+func New(rawurl string) (*Publisher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") || len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("invalid MQTT broker %s, expected mqtt[s]://host[:port][/topic]", rawurl)
+	}
+
+	p := &Publisher{Addr: u.Host, TLS: u.Scheme == "mqtts", Topic: strings.TrimPrefix(u.Path, "/")}
+	if len(u.Port()) == 0 {
+		port := "1883"
+		if p.TLS {
+			port = "8883"
+		}
+		p.Addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if len(p.Topic) == 0 {
+		p.Topic = DefaultTopic
+	}
+	if u.User != nil {
+		p.Username = u.User.Username()
+		p.Password, _ = u.User.Password()
+	}
+
+	if qos := u.Query().Get("qos"); len(qos) > 0 {
+		n, err := strconv.Atoi(qos)
+		if err != nil || n < 0 || n > 1 {
+			return nil, fmt.Errorf("invalid MQTT QoS %q, expected 0 or 1", qos)
+		}
+		p.QoS = byte(n)
+	}
+	return p, nil
+}
+
+// Send publishes the event, reconnecting once if the connection was lost
+func (p *Publisher) Send(rec lock.EventLogRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	topic := strings.NewReplacer("{lock}", rec.Lock, "{event}", rec.Event).Replace(p.Topic)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		err := p.publish(topic, payload)
+		if err == nil {
+			return nil
+		}
+		p.reset()
+		if attempt == 2 {
+			return fmt.Errorf("MQTT publish to %s failed: %v", p.Addr, err)
+		}
+	}
+}
+
+// Close disconnects from the broker
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.Write([]byte{0xe0, 0})
+	return p.reset()
+}
+
+func (p *Publisher) publish(topic string, payload []byte) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var body []byte
+	body = appendString(body, topic)
+	if p.QoS > 0 {
+		p.packetID++
+		if p.packetID == 0 {
+			p.packetID++
+		}
+		body = binary.BigEndian.AppendUint16(body, p.packetID)
+	}
+	body = append(body, payload...)
+
+	if err := p.write(0x30|p.QoS<<1, body); err != nil {
+		return err
+	}
+	if p.QoS == 0 {
+		return nil
+	}
+
+	for {
+		kind, ack, err := p.read()
+		if err != nil {
+			return err
+		}
+		// PUBACK of this packet, other packets are ignored
+		if kind>>4 == 4 && len(ack) == 2 && binary.BigEndian.Uint16(ack) == p.packetID {
+			return nil
+		}
+	}
+}
+
+func (p *Publisher) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if p.TLS {
+		host, _, _ := net.SplitHostPort(p.Addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.Addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", p.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	clientID, err := newClientID()
+	if err != nil {
+		return err
+	}
+
+	// protocol name and level, flags, keep alive
+	flags := byte(0x02) // clean session
+	if len(p.Username) > 0 {
+		flags |= 0x80
+		if len(p.Password) > 0 {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive/time.Second))
+	body = appendString(body, clientID)
+	if flags&0x80 != 0 {
+		body = appendString(body, p.Username)
+	}
+	if flags&0x40 != 0 {
+		body = appendString(body, p.Password)
+	}
+
+	if err := p.write(0x10, body); err != nil {
+		return err
+	}
+	kind, ack, err := p.read()
+	switch {
+	case err != nil:
+		return err
+	case kind>>4 != 2 || len(ack) != 2:
+		return fmt.Errorf("unexpected reply of type %d to CONNECT", kind>>4)
+	case ack[1] != 0:
+		return fmt.Errorf("connection refused by the broker, return code %d", ack[1])
+	}
+	return nil
+}
+
+// write sends a packet of the given type and flags
+func (p *Publisher) write(header byte, body []byte) error {
+	if len(body) > 268435455 {
+		return errors.New("MQTT packet too large")
+	}
+
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := p.conn.Write(append(packet, body...))
+	return err
+}
+
+// read returns the next packet: its type and flags, and its body
+func (p *Publisher) read() (byte, []byte, error) {
+	header, err := p.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// reset closes the connection, the next publish reconnecting
+func (p *Publisher) reset() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.r = nil, nil
+	return err
+}
+
+// appendString appends the MQTT length-prefixed string
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// newClientID returns a random client ID, of at most the 23 characters
+// all brokers accept
+func newClientID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "lock-" + hex.EncodeToString(b), nil
+}
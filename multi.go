@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AcquireAll acquires the locks with the given names, in a canonical
+// (sorted) order so that jobs needing overlapping sets of locks cannot
+// deadlock each other. If any lock cannot be acquired, those already
+// acquired are released. The configured MaxWait applies to the whole set.
+func AcquireAll(cfg *Configuration, names ...string) ([]*Lock, error) {
+	base := DefaultConfig()
+	if cfg != nil {
+		base = *cfg
+	}
+
+	// bound the whole set, unless each lock is only tried once or waited for forever
+	if base.Budget == nil && base.MaxWait > 0 {
+		base.Budget = NewBudgetClock(base.MaxWait, base.Clock)
+	}
+
+	var held []*Lock
+	for _, name := range canonicalNames(names) {
+		c := base
+		c.Name = name
+
+		lck, err := Acquire(&c)
+		if err != nil {
+			if releaseErr := releaseAll(held); releaseErr != nil {
+				err = fmt.Errorf("%v (also failed to release held locks: %v)", err, releaseErr)
+			}
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+		}
+		held = append(held, lck)
+	}
+
+	return held, nil
+}
+
+// ReleaseLocks releases all the given locks, in reverse order of acquisition
+func ReleaseLocks(locks []*Lock) error {
+	return releaseAll(locks)
+}
+
+func releaseAll(locks []*Lock) error {
+	var failed []string
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := locks[i].Release(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", locks[i].Path(), err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to release %v", failed)
+	}
+	return nil
+}
+
+// canonicalNames returns the sorted, deduplicated names
+func canonicalNames(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,163 @@
+package lock
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// derivedName builds a lock name from a readable hint and the
+// canonical form of a resource, hashed to avoid collisions.
+func derivedName(kind, hint, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	hint = strings.Trim(unsafeNameChars.ReplaceAllString(hint, "-"), "-.")
+	if len(hint) > 40 {
+		hint = hint[len(hint)-40:]
+	}
+	return fmt.Sprintf("%s-%s-%s", kind, hint, hex.EncodeToString(sum[:])[:12])
+}
+
+// NameFromPath derives a stable lock name from a filesystem path.
+// Different spellings of the same path (relative, trailing slash, symlinks)
+// give the same name.
+func NameFromPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+
+	return derivedName("path", filepath.Base(abs), abs), nil
+}
+
+// NameFromURL derives a stable lock name from a URL. Scheme and host
+// case, default ports, credentials and trailing slashes are ignored.
+func NameFromURL(u string) (string, error) {
+	canonical, hint, err := canonicalURL(u)
+	if err != nil {
+		return "", err
+	}
+	return derivedName("url", hint, canonical), nil
+}
+
+// NameFromGitRepo derives a stable lock name from the git repository
+// containing dir: its origin remote URL if any, else its top level path.
+// Clones of the same repository on different nodes thus share a name.
+func NameFromGitRepo(dir string) (string, error) {
+	top, err := gitTopLevel(dir)
+	if err != nil {
+		return "", err
+	}
+
+	remote, err := gitOriginURL(filepath.Join(top, ".git", "config"))
+	if err != nil || len(remote) == 0 {
+		return NameFromPath(top)
+	}
+
+	canonical, hint, err := canonicalURL(scpToURL(remote))
+	if err != nil {
+		// a local path remote
+		return derivedName("git", filepath.Base(remote), remote), nil
+	}
+	return derivedName("git", hint, canonical), nil
+}
+
+func canonicalURL(u string) (canonical, hint string, err error) {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL %s: %v", u, err)
+	}
+
+	if len(parsed.Host) == 0 {
+		return "", "", fmt.Errorf("invalid URL %s: no host", u)
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") || (scheme == "ssh" && port == "22") {
+		port = ""
+	}
+	if len(port) > 0 {
+		host = host + ":" + port
+	}
+
+	path := strings.TrimSuffix(strings.TrimRight(parsed.EscapedPath(), "/"), ".git")
+	canonical = host + path
+	if len(parsed.RawQuery) > 0 {
+		canonical += "?" + parsed.RawQuery
+	}
+
+	hint = filepath.Base(path)
+	if len(path) == 0 || hint == "." || hint == "/" {
+		hint = parsed.Hostname()
+	}
+	return canonical, hint, nil
+}
+
+// scpToURL converts scp-like git remotes (user@host:path) to ssh URLs
+func scpToURL(remote string) string {
+	if strings.Contains(remote, "://") {
+		return remote
+	}
+
+	at := strings.Index(remote, "@")
+	colon := strings.Index(remote, ":")
+	if colon > at {
+		return "ssh://" + remote[:colon] + "/" + remote[colon+1:]
+	}
+	return remote
+}
+
+// gitTopLevel walks up from dir to the first directory containing .git
+func gitTopLevel(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for d := abs; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return "", fmt.Errorf("%s is not inside a git repository", dir)
+		}
+	}
+}
+
+// gitOriginURL reads the url of the origin remote from a git config file
+func gitOriginURL(configPath string) (string, error) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	inOrigin := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inOrigin = line == `[remote "origin"]`
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if inOrigin && ok && strings.TrimSpace(key) == "url" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", scanner.Err()
+}
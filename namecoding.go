@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lock names are stored in entry file names, whose fields are separated
+// by a double underscore, and in directory names. To be safe whatever
+// the name, each name component is encoded reversibly: characters other
+// than ASCII letters, digits, '.', '-' and isolated underscores are
+// percent encoded, so that an encoded name never contains "__".
+
+// maxEncodedLen keeps entry file names well within common filesystem limits
+const maxEncodedLen = 150
+
+// NameError is returned for lock names which are invalid, or
+// entry names which cannot be decoded.
+type NameError struct {
+	Name   string
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("invalid lock name %q: %s", e.Name, e.Reason)
+}
+
+// ValidateName checks that the given, possibly hierarchical, lock name can be used
+func ValidateName(name string) error {
+	if len(strings.Trim(name, "/")) == 0 {
+		return &NameError{name, "empty"}
+	}
+
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		switch {
+		case part == "." || part == "..":
+			return &NameError{name, fmt.Sprintf("%q is not allowed as name component", part)}
+		case len(encodeName(part)) > maxEncodedLen:
+			return &NameError{name, "too long"}
+		}
+	}
+	return nil
+}
+
+// encodeName encodes a single lock name component for use in file names
+func encodeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			last := i == len(name)-1
+			adjacent := (!last && name[i+1] == '_') || (i > 0 && name[i-1] == '_')
+			if last || adjacent {
+				fmt.Fprintf(&b, "%%%02X", c)
+			} else {
+				b.WriteByte(c)
+			}
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '-':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	// avoid "." and ".." and hidden files
+	if strings.HasPrefix(b.String(), ".") {
+		return "%2E" + b.String()[1:]
+	}
+	return b.String()
+}
+
+// decodeName reverses encodeName
+func decodeName(encoded string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+2 >= len(encoded) {
+			return "", &NameError{encoded, "truncated escape sequence"}
+		}
+
+		v, err := strconv.ParseUint(encoded[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", &NameError{encoded, "invalid escape sequence"}
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// encodePath encodes each component of a hierarchical lock name
+func encodePath(name string) string {
+	parts := strings.Split(cleanName(name), "/")
+	for i, p := range parts {
+		parts[i] = encodeName(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// decodePath reverses encodePath
+func decodePath(encoded string) (string, error) {
+	parts := strings.Split(encoded, "/")
+	for i, p := range parts {
+		decoded, err := decodeName(p)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = decoded
+	}
+	return strings.Join(parts, "/"), nil
+}
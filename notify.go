@@ -0,0 +1,136 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// WaitAlert describes a request which waited longer than the
+// NotifyAfter of its configuration
+type WaitAlert struct {
+	Name   string
+	Dir    string
+	Waited time.Duration
+
+	// Holders are the current holders of the lock
+	Holders []Info
+
+	// Queue is the number of requests waiting, this one included
+	Queue int
+
+	Node string
+	User string
+	PID  int
+}
+
+// Subject returns the one-line summary of the alert
+func (a WaitAlert) Subject() string {
+	return fmt.Sprintf("Lock %s waited for %v", a.Name, a.Waited.Round(time.Second))
+}
+
+// String returns the text of the alert
+func (a WaitAlert) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s by %s@%s (pid %d) in %s.\n", a.Subject(), a.User, a.Node, a.PID, a.Dir)
+	if len(a.Holders) == 0 {
+		b.WriteString("Held by nobody.\n")
+	}
+	for _, h := range a.Holders {
+		fmt.Fprintf(&b, "Held by %s (%s) since %s.\n", h.Node, h.ID, h.Created.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "%d request(s) waiting.\n", a.Queue)
+	return b.String()
+}
+
+// Notifier sends the wait alerts, see Configuration.NotifyAfter
+type Notifier interface {
+	Notify(alert WaitAlert) error
+}
+
+// SlackNotifier posts the alerts to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (s SlackNotifier) Notify(alert WaitAlert) error {
+	body, err := json.Marshal(map[string]string{"text": alert.String()})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier e-mails the alerts through an SMTP server, authenticating
+// with PLAIN if a Username is set
+type SMTPNotifier struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+
+	From string
+	To   []string
+
+	Username string
+	Password string
+}
+
+func (s SMTPNotifier) Notify(alert WaitAlert) error {
+	var auth smtp.Auth
+	if len(s.Username) > 0 {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		s.From, strings.Join(s.To, ", "), alert.Subject(), strings.ReplaceAll(alert.String(), "\n", "\r\n"))
+	return smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(msg))
+}
+
+// alertWait sends the wait alert, in the background, the first time it is
+// called once the request waited for longer than NotifyAfter
+func (c *Configuration) alertWait(req *Request, started time.Time, alerted *bool) {
+	if *alerted || c.Notifier == nil || c.NotifyAfter <= 0 || c.since(started) < c.NotifyAfter {
+		return
+	}
+	*alerted = true
+
+	alert := WaitAlert{
+		Name:   c.Name,
+		Dir:    c.Dir,
+		Waited: c.since(started),
+		Queue:  len(*c.requestsNamed(c.Dir, c.Name)),
+		Node:   currentNode(),
+		User:   currentUser(),
+		PID:    os.Getpid(),
+	}
+	for _, e := range *c.locksNamed(c.Dir, c.Name) {
+		alert.Holders = append(alert.Holders, e.info(c.Dir))
+	}
+
+	c.logger().Info("lock waited for too long, notifying", "lock", c.Name, "waited", alert.Waited)
+	deliveries.Add(1)
+	go func() {
+		defer deliveries.Done()
+		if err := c.Notifier.Notify(alert); err != nil {
+			c.logger().Warn("failed to send wait alert", "lock", c.Name, "error", err)
+		}
+	}()
+}
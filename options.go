@@ -0,0 +1,74 @@
+package lock
+
+import (
+	"context"
+	"time"
+)
+
+// Option overrides a setting of the configuration of an AcquireContext call
+type Option func(*Configuration)
+
+// WithConfig uses the given configuration as the base of the call instead
+// of the package one, the name passed to AcquireContext still applying
+func WithConfig(cfg Configuration) Option {
+	return func(c *Configuration) {
+		name := c.Name
+		*c = cfg
+		c.Name = name
+	}
+}
+
+// WithDir sets the lock directory
+func WithDir(dir string) Option {
+	return func(c *Configuration) {
+		c.Dir = dir
+	}
+}
+
+// WithPollInterval sets the time between the checks of the queue and the lock
+func WithPollInterval(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.PollInterval = d
+	}
+}
+
+// WithMaxWait sets the time to wait for the lock, see Configuration.MaxWait
+func WithMaxWait(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.MaxWait = d
+	}
+}
+
+// WithPriority sets the priority of the request in the queue
+func WithPriority(p int) Option {
+	return func(c *Configuration) {
+		c.Priority = p
+	}
+}
+
+// WithMetadata sets the metadata stored in the request and lock files
+func WithMetadata(meta map[string]string) Option {
+	return func(c *Configuration) {
+		c.Metadata = meta
+	}
+}
+
+// AcquireContext acquires the named lock like Acquire, with the options
+// applied to a copy of the package configuration rather than changing it,
+// so that concurrent calls can use different settings. If the context is
+// done first, the request is removed and the error of the context returned.
+func AcquireContext(ctx context.Context, name string, opts ...Option) (*Lock, error) {
+	cfg := configCopy()
+	cfg.Name = name
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := ValidateName(cfg.Name); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return cfg.acquireWithin(ctx)
+}
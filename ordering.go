@@ -0,0 +1,27 @@
+package lock
+
+// Ordering selects the timestamp used to order requests in the queue
+type Ordering int
+
+const (
+	// OrderByCreation orders requests by the creation time embedded in their
+	// file name by the client that created them. Skewed client clocks can
+	// therefore reorder the queue.
+	OrderByCreation Ordering = iota
+
+	// OrderByFileTime orders requests by the modification time of their
+	// file as recorded by the filesystem. On a shared filesystem this is
+	// usually assigned by the file server, so all clients agree on it
+	// whatever the state of their own clock.
+	OrderByFileTime
+)
+
+// queueTime returns the timestamp used to order the entry in the queue
+func (e *entry) queueTime() int64 {
+	if e.conf().Ordering == OrderByFileTime {
+		if info, err := e.conf().filesystem().Stat(e.path); err == nil {
+			return info.ModTime().UnixNano()
+		}
+	}
+	return int64(e.created())
+}
@@ -0,0 +1,74 @@
+// Package otel adapts an OpenTelemetry tracer to the lock.Tracer interface.
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/brinick/lock"
+)
+
+// New returns a lock.Tracer starting its spans with the given
+// OpenTelemetry tracer, as children of the span in ctx if any.
+func New(ctx context.Context, tracer trace.Tracer) lock.Tracer {
+	return &tracerAdapter{ctx, tracer}
+}
+
+type tracerAdapter struct {
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+func (t *tracerAdapter) Start(name string, attrs ...lock.Attribute) lock.Span {
+	ctx, span := t.tracer.Start(t.ctx, name, trace.WithAttributes(convert(attrs)...))
+	return &spanAdapter{ctx, t.tracer, span}
+}
+
+type spanAdapter struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	span   trace.Span
+}
+
+func (s *spanAdapter) Start(name string, attrs ...lock.Attribute) lock.Span {
+	ctx, span := s.tracer.Start(s.ctx, name, trace.WithAttributes(convert(attrs)...))
+	return &spanAdapter{ctx, s.tracer, span}
+}
+
+func (s *spanAdapter) SetAttributes(attrs ...lock.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+func (s *spanAdapter) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *spanAdapter) End() {
+	s.span.End()
+}
+
+func convert(attrs []lock.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.Key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(a.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(a.Key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(a.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.Key, v))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,88 @@
+package lock
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MetaUser is the metadata key holding the name of the user owning an entry
+const MetaUser = "user"
+
+// MetaCommand is the metadata key holding the command line of the process
+// which created an entry
+const MetaCommand = "cmd"
+
+// Owner describes the process which created an entry
+type Owner struct {
+	Node    string    `json:"node"`
+	PID     int       `json:"pid,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Command string    `json:"command,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// Owner returns the process which created the entry, as recorded in it.
+// The command is only known for entries created by this version onwards.
+func (e *entry) Owner() (Owner, error) {
+	o := Owner{
+		Node:    e.node(),
+		Started: time.Unix(0, int64(e.created())),
+	}
+
+	meta, err := e.Metadata()
+	if err != nil {
+		return o, err
+	}
+	o.PID, _ = strconv.Atoi(meta[MetaPID])
+	o.User = meta[MetaUser]
+	o.Command = meta[MetaCommand]
+	return o, nil
+}
+
+// NotOwnerErr is returned when releasing a lock owned by another user
+type NotOwnerErr struct {
+	ID    string
+	Owner string
+	User  string
+}
+
+func (e *NotOwnerErr) Error() string {
+	return fmt.Sprintf("lock %s is owned by %s, not %s (force the release to override)", e.ID, e.Owner, e.User)
+}
+
+func (e *NotOwnerErr) Is(target error) bool {
+	return target == ErrNotOwner
+}
+
+func metadataOwner(e *entry) (string, error) {
+	meta, err := e.Metadata()
+	if err != nil {
+		return "", err
+	}
+	return meta[MetaUser], nil
+}
+
+// checkOwner returns a NotOwnerErr if the entry was created by another user,
+// or a SignatureErr if it fails signature verification
+func (e *entry) checkOwner() error {
+	if err := e.verify(); err != nil {
+		return err
+	}
+
+	owner, err := entryOwner(e)
+	user := currentOwner()
+	if _, local := e.conf().filesystem().(osFS); !local {
+		// the file owners of a remote filesystem are not local users
+		owner, err = metadataOwner(e)
+		user = currentUser()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(owner) > 0 && owner != user {
+		return &NotOwnerErr{e.ID(), owner, user}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package lock
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// entryOwner returns the user owning the entry file, as a UID
+func entryOwner(e *entry) (string, error) {
+	info, err := e.conf().filesystem().Stat(e.path)
+	if err != nil {
+		return "", err
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return strconv.Itoa(int(st.Uid)), nil
+	}
+	return metadataOwner(e)
+}
+
+// currentOwner returns the current user, comparable to entryOwner
+func currentOwner() string {
+	return strconv.Itoa(os.Getuid())
+}
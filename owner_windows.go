@@ -0,0 +1,13 @@
+//go:build windows
+
+package lock
+
+// entryOwner returns the user who created the entry, from its metadata
+func entryOwner(e *entry) (string, error) {
+	return metadataOwner(e)
+}
+
+// currentOwner returns the current user, comparable to entryOwner
+func currentOwner() string {
+	return currentUser()
+}
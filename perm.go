@@ -0,0 +1,157 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The process umask is applied by the OS when creating files and
+// directories, which would e.g. strip the group write permission needed
+// in shared group directories. The configured modes are therefore set
+// explicitly after creation, whatever the umask.
+
+const (
+	// DefaultFileMode is the permission of the lock files
+	DefaultFileMode os.FileMode = 0664
+
+	// DefaultDirMode is the permission of the lock directories
+	DefaultDirMode os.FileMode = 0775
+)
+
+func (c *Configuration) fileMode() os.FileMode {
+	if c.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return c.FileMode
+}
+
+func (c *Configuration) dirMode() os.FileMode {
+	if c.DirMode == 0 {
+		return DefaultDirMode
+	}
+	return c.DirMode
+}
+
+func fileMode() os.FileMode {
+	return currentConfig().fileMode()
+}
+
+func dirMode() os.FileMode {
+	return currentConfig().dirMode()
+}
+
+// writeFile writes a file, giving it the configured file mode regardless
+// of the umask if it is created. The mode of existing files, possibly
+// owned by other users, is left alone.
+func (c *Configuration) writeFile(path string, data []byte) error {
+	fsys := c.filesystem()
+	_, err := fsys.Stat(path)
+	created := os.IsNotExist(err)
+
+	f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, c.fileMode())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if created {
+		return fsys.Chmod(path, c.fileMode())
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	return currentConfig().writeFile(path, data)
+}
+
+// partialSuffix ends the temporary files written by commitFile, which are
+// never taken for entries, their file type being neither lock nor request
+const partialSuffix = ".tmp"
+
+// commitFile writes a new file atomically: the data is written to a hidden
+// temporary file of the same directory, renamed into place once complete,
+// so that a crash mid-write never leaves a truncated file at the path.
+// The file and then the directory are flushed to stable storage, so that
+// once it returns a crash cannot lose the file, e.g. a lock other nodes
+// must see.
+func (c *Configuration) commitFile(path string, data []byte) error {
+	uuid, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	fsys := c.filesystem()
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+uuid+partialSuffix)
+	f, err := fsys.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, c.fileMode())
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = syncFile(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Chmod(tmp, c.fileMode())
+	}
+	if err == nil {
+		err = fsys.Rename(tmp, path)
+	}
+	if err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	return syncDir(fsys, filepath.Dir(path))
+}
+
+// isPartial tells if the file is a temporary file of commitFile
+func isPartial(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, partialSuffix)
+}
+
+func createDir(dir string) error {
+	return currentConfig().createDir(dir)
+}
+
+// createDir creates the given directory, and its missing parents,
+// with the configured directory mode, regardless of the umask
+func (c *Configuration) createDir(dir string) error {
+	// find the directories which will be created
+	fsys := c.filesystem()
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := fsys.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	// parents first
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := fsys.Mkdir(missing[i], c.dirMode()); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("unable to create lock dir %s: %v", dir, err)
+		}
+	}
+
+	for _, d := range missing {
+		if err := fsys.Chmod(d, c.dirMode()); err != nil {
+			return fmt.Errorf("unable to set the permission of lock dir %s: %v", d, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package lock
+
+import "syscall"
+
+const processQueryLimitedInformation = 0x1000
+
+// processAlive tells if a process with the given PID runs on this node
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	const stillActive = 259
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
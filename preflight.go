@@ -0,0 +1,99 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Preflight are checks of the lock directory done before queuing, so that
+// a directory which cannot work, e.g. read-only, full or not mounted,
+// fails at once with a PreflightErr rather than after MaxWait
+type Preflight struct {
+	// FSTypes, if set, are the expected filesystem types of the lock
+	// directory, e.g. nfs or cifs, so that a share which is not mounted,
+	// leaving the local mount point, is detected
+	FSTypes []string
+
+	// MinFreeBytes is the free space needed in the lock directory
+	MinFreeBytes uint64
+
+	// MinFreeInodes is the number of free inodes needed in the lock directory
+	MinFreeInodes uint64
+}
+
+// fsStats are the statistics of a filesystem
+type fsStats struct {
+	Type       string
+	FreeBytes  uint64
+	FreeInodes uint64
+
+	// inodes tells if the filesystem reports free inodes
+	inodes bool
+}
+
+// preflight checks that the lock directory is writable and, for the local
+// filesystem, that it has the expected type and enough free space and
+// inodes. The checks the platform cannot do are skipped.
+func (c *Configuration) preflight(dir string) error {
+	p := c.Preflight
+	if p == nil {
+		return nil
+	}
+
+	if err := c.probeWrite(dir); err != nil {
+		return &PreflightErr{dir, "writable", err}
+	}
+
+	if _, ok := c.FS.(osFS); c.FS != nil && !ok {
+		// the statistics are of the local filesystem only
+		return nil
+	}
+	if len(p.FSTypes) == 0 && p.MinFreeBytes == 0 && p.MinFreeInodes == 0 {
+		return nil
+	}
+
+	stats, err := statFS(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.logger().Debug("filesystem checks not supported, skipped", "dir", dir)
+		return nil
+	}
+	if err != nil {
+		return &PreflightErr{dir, "filesystem", err}
+	}
+
+	switch {
+	case len(p.FSTypes) > 0 && !slices.Contains(p.FSTypes, stats.Type):
+		return &PreflightErr{dir, "filesystem type", fmt.Errorf("%s, expected %v", stats.Type, p.FSTypes)}
+	case stats.FreeBytes < p.MinFreeBytes:
+		return &PreflightErr{dir, "free space", fmt.Errorf("%d bytes free, %d needed", stats.FreeBytes, p.MinFreeBytes)}
+	case stats.inodes && stats.FreeInodes < p.MinFreeInodes:
+		return &PreflightErr{dir, "free inodes", fmt.Errorf("%d inodes free, %d needed", stats.FreeInodes, p.MinFreeInodes)}
+	}
+	return nil
+}
+
+// probeWrite creates and removes a temporary file in the directory
+func (c *Configuration) probeWrite(dir string) error {
+	uuid, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	fsys := c.filesystem()
+	probe := filepath.Join(dir, ".preflight."+uuid+partialSuffix)
+	f, err := fsys.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_EXCL, c.fileMode())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("preflight"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := fsys.Remove(probe); err == nil {
+		err = removeErr
+	}
+	return err
+}
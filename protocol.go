@@ -0,0 +1,178 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Protocol selects how a client makes sure it is alone creating a lock
+type Protocol int
+
+const (
+	// ProtocolCreate checks that no lock exists, then creates its own.
+	// This relies on the directory listing being up to date, which is not
+	// the case on some NFS servers.
+	ProtocolCreate Protocol = iota
+
+	// ProtocolHardlink guards the check and the creation of the lock with
+	// a mutex file taken with the classic NFS-safe protocol: a unique
+	// temporary file is hard linked to the mutex name, and the mutex is
+	// held if the temporary file then has a link count of 2, whatever the
+	// link call returned (its reply may be lost and the call retried).
+	ProtocolHardlink
+
+	// ProtocolMkdir guards the check and the creation of the lock with a
+	// mutex directory, os.Mkdir being atomic on essentially all filesystems.
+	// The directory holds the node and PID of its owner, so that a mutex
+	// left over by a dead process of the current node is removed at once.
+	ProtocolMkdir
+)
+
+const (
+	mutexFile  = ".mutex"
+	mutexDir   = ".mutex.d"
+	mutexOwner = "owner"
+)
+
+// StaleMutexAge is the age above which a mutex file is considered left
+// over by a crashed client and removed
+var StaleMutexAge = time.Minute
+
+// errMutexBusy is returned when another client holds the lock dir mutex
+var errMutexBusy = fmt.Errorf("lock directory mutex held by another client")
+
+// takeMutex takes the mutex of the lock name directory with the configured
+// protocol, returning the function releasing it. No mutex is used by
+// ProtocolCreate.
+func (c *Configuration) takeMutex(ndir string) (unlock func(), err error) {
+	switch c.Protocol {
+	case ProtocolHardlink:
+		return c.linkMutex(ndir)
+	case ProtocolMkdir:
+		return c.mkdirMutex(ndir)
+	default:
+		return func() {}, nil
+	}
+}
+
+// linkMutex takes the mutex of the lock name directory using the hard link
+// protocol, returning the function releasing it
+func (c *Configuration) linkMutex(ndir string) (unlock func(), err error) {
+	fsys := c.filesystem()
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := filepath.Join(ndir, fmt.Sprintf("%s.%s.%s", mutexFile, currentNode(), uuid))
+	if err := c.writeFile(tmp, []byte(fmt.Sprintf("%d\n", os.Getpid()))); err != nil {
+		return nil, fmt.Errorf("unable to create mutex file %s: %v", tmp, err)
+	}
+	defer fsys.Remove(tmp)
+
+	mutex := filepath.Join(ndir, mutexFile)
+
+	// the result of link is unreliable over NFS, only the link count is trusted
+	_ = fsys.Link(tmp, mutex)
+
+	tmpInfo, err := fsys.Stat(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	if !linked(fsys, tmpInfo, mutex) {
+		if c.mutexAge(mutex) > StaleMutexAge {
+			c.breakStaleMutex(mutex)
+		}
+		return nil, errMutexBusy
+	}
+
+	return func() {
+		// only remove the mutex if it was not broken as stale in the meantime
+		if info, err := fsys.Stat(mutex); err == nil && os.SameFile(info, tmpInfo) {
+			fsys.Remove(mutex)
+		}
+	}, nil
+}
+
+// linked tells if the temporary file was linked to the mutex
+func linked(fsys FS, tmpInfo os.FileInfo, mutex string) bool {
+	if n, ok := linkCount(tmpInfo); ok {
+		return n == 2
+	}
+
+	// no link count on this platform
+	info, err := fsys.Stat(mutex)
+	return err == nil && os.SameFile(info, tmpInfo)
+}
+
+// mkdirMutex takes the mutex of the lock name directory by creating the
+// mutex directory, returning the function releasing it
+func (c *Configuration) mkdirMutex(ndir string) (unlock func(), err error) {
+	fsys := c.filesystem()
+	mutex := filepath.Join(ndir, mutexDir)
+
+	if err := fsys.Mkdir(mutex, c.dirMode()); err != nil {
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to create mutex directory %s: %v", mutex, err)
+		}
+
+		if c.mutexAge(mutex) > StaleMutexAge || mutexOwnerDead(fsys, mutex) {
+			c.breakStaleMutex(mutex)
+		}
+		return nil, errMutexBusy
+	}
+
+	// unique, so that a mutex taken again after being broken is told apart
+	uuid, err := newUUID()
+	if err != nil {
+		removeAll(fsys, mutex)
+		return nil, err
+	}
+	owner := fmt.Sprintf("%s %d %s\n", currentNode(), os.Getpid(), uuid)
+	ownerPath := filepath.Join(mutex, mutexOwner)
+	if err := c.writeFile(ownerPath, []byte(owner)); err != nil {
+		removeAll(fsys, mutex)
+		return nil, fmt.Errorf("unable to record the mutex owner in %s: %v", mutex, err)
+	}
+
+	return func() {
+		// only remove the mutex if it was not broken as stale in the meantime
+		if data, err := fsys.ReadFile(ownerPath); err == nil && string(data) == owner {
+			removeAll(fsys, mutex)
+		}
+	}, nil
+}
+
+// mutexOwnerDead tells if the mutex directory is owned by
+// a process of the current node which is no longer running
+func mutexOwnerDead(fsys FS, mutex string) bool {
+	data, err := fsys.ReadFile(filepath.Join(mutex, mutexOwner))
+	if err != nil {
+		return false
+	}
+
+	var node string
+	var pid int
+	if _, err := fmt.Sscanf(string(data), "%s %d", &node, &pid); err != nil {
+		return false
+	}
+	return node == currentNode() && !processAlive(pid)
+}
+
+// mutexAge returns the age of the mutex, 0 if it does not exist
+func (c *Configuration) mutexAge(mutex string) time.Duration {
+	info, err := c.filesystem().Stat(mutex)
+	if err != nil {
+		return 0
+	}
+	return c.since(info.ModTime())
+}
+
+// breakStaleMutex removes a mutex left over by a crashed client
+func (c *Configuration) breakStaleMutex(mutex string) {
+	c.logger().Warn("removing stale lock directory mutex", "path", mutex, "age", c.mutexAge(mutex))
+	removeAll(c.filesystem(), mutex)
+}
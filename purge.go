@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PurgeOptions select the entries removed by Purge
+type PurgeOptions struct {
+	// OlderThan is the minimum age of the removed entries, counted from
+	// the last renewal of the locks and the last heartbeat of the requests
+	OlderThan time.Duration
+
+	// Locks and Requests select the removed file types, both if none is set
+	Locks    bool
+	Requests bool
+
+	// DryRun only reports the entries which would be removed
+	DryRun bool
+}
+
+// Purge removes the locks and requests of the whole lock directory not
+// renewed for longer than OlderThan, e.g. for nightly hygiene of a shared
+// directory. It returns the removed entries, and keeps going on failure,
+// reporting all of them. The temporary files of entries left behind by a
+// crash mid-write are removed too, past the same age.
+func Purge(dir string, opts PurgeOptions) ([]Info, error) {
+	if opts.OlderThan <= 0 {
+		return nil, fmt.Errorf("purge needs a positive age, got %v", opts.OlderThan)
+	}
+
+	both := !opts.Locks && !opts.Requests
+	selected := entriesTree(dir).filter(func(e entry) bool {
+		switch e.filetype() {
+		case lockFileType:
+			return both || opts.Locks
+		case requestFileType:
+			return both || opts.Requests
+		default:
+			return false
+		}
+	})
+
+	var purged []Info
+	var errs []error
+	if !opts.DryRun {
+		errs = purgePartial(dir, opts.OlderThan)
+	}
+	for _, e := range *selected {
+		if !e.valid() {
+			continue
+		}
+
+		seen, err := e.lastRenewed()
+		if err != nil || since(seen) <= opts.OlderThan {
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := e.Remove(); err != nil {
+				errs = append(errs, fmt.Errorf("unable to remove %s: %w", e.path, err))
+				continue
+			}
+			if e.filetype() == lockFileType {
+				detail := fmt.Sprintf("purged by %s@%s, not renewed for %v", currentUser(), currentNode(), since(seen).Round(time.Second))
+				if err := writeAudit(e.dir(), AuditBreak, e.name(), e.ID(), detail); err != nil {
+					logger().Warn("failed to write audit log", "dir", e.dir(), "error", err)
+				}
+			}
+		}
+		purged = append(purged, e.info(dir))
+	}
+	return purged, errors.Join(errs...)
+}
+
+// lastRenewed returns when the entry was last known alive: the last
+// heartbeat of a request, the last renewal of a lock, also told by its
+// expiry if it has one
+func (e *entry) lastRenewed() (time.Time, error) {
+	if e.filetype() == requestFileType {
+		r := Request{*e}
+		return r.lastSeen()
+	}
+
+	info, err := e.conf().filesystem().Stat(e.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	renewed := info.ModTime()
+	if _, _, exp, err := e.readBody(); err == nil && !exp.At.IsZero() {
+		if at := exp.At.Add(-exp.TTL); at.After(renewed) {
+			renewed = at
+		}
+	}
+	return renewed, nil
+}
+
+// purgePartial removes the temporary files of commitFile older than the given age
+func purgePartial(dir string, olderThan time.Duration) []error {
+	var errs []error
+	for _, e := range *entriesTree(dir) {
+		if !isPartial(e.path) {
+			continue
+		}
+
+		info, err := fsys().Stat(e.path)
+		if err != nil || since(info.ModTime()) <= olderThan {
+			continue
+		}
+		if err := fsys().Remove(e.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("unable to remove %s: %w", e.path, err))
+			continue
+		}
+		logger().Info("removed partially written entry", "path", e.path)
+	}
+	return errs
+}
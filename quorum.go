@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QuorumLock is a lock held in a majority of independent lock
+// directories, e.g. on different file servers, so that one of them being
+// down or flaky neither blocks the lock nor lets two holders in
+type QuorumLock struct {
+	// Locks are the locks held, one per directory of the majority
+	Locks []*Lock
+
+	// Skipped are the failures of the directories which could not be
+	// used, by directory
+	Skipped map[string]error
+
+	// needed is the number of locks making a majority
+	needed int
+}
+
+// QuorumErr is returned when the lock could not be acquired in a
+// majority of the directories
+type QuorumErr struct {
+	Acquired int
+	Needed   int
+
+	// Errs are the failures, by directory
+	Errs map[string]error
+}
+
+func (e *QuorumErr) Error() string {
+	var dirs []string
+	for dir := range e.Errs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var failures []string
+	for _, dir := range dirs {
+		failures = append(failures, fmt.Sprintf("%s: %v", dir, e.Errs[dir]))
+	}
+	return fmt.Sprintf("lock acquired in %d directories, %d needed for a majority (%s)",
+		e.Acquired, e.Needed, strings.Join(failures, "; "))
+}
+
+// Unwrap returns the failures, so that errors.Is matches e.g. ErrTimeout
+// if any directory timed out
+func (e *QuorumErr) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// AcquireQuorum acquires the configured lock in a majority of the given
+// directories. They are tried one at a time, in a canonical (sorted)
+// order so that concurrent clients cannot deadlock each other, until a
+// majority is held. A directory failing with a backend error, e.g.
+// unreachable, is skipped; any other failure, e.g. a timeout, releases
+// the locks already held. The configured MaxWait applies to the whole
+// acquisition. All the clients of the lock must give the same directories.
+func AcquireQuorum(cfg *Configuration, dirs ...string) (*QuorumLock, error) {
+	base := DefaultConfig()
+	if cfg != nil {
+		base = *cfg
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("no lock directories given for the quorum")
+	}
+
+	// bound the whole set, unless each lock is only tried once or waited for forever
+	if base.Budget == nil && base.MaxWait > 0 {
+		base.Budget = NewBudgetClock(base.MaxWait, base.Clock)
+	}
+
+	dirs = canonicalNames(dirs)
+	needed := len(dirs)/2 + 1
+	q := &QuorumLock{Skipped: map[string]error{}, needed: needed}
+
+	for i, dir := range dirs {
+		if len(q.Locks)+len(dirs)-i < needed {
+			// a majority cannot be reached anymore
+			break
+		}
+
+		c := base
+		c.Dir = dir
+		lck, err := Acquire(&c)
+		if err == nil {
+			q.Locks = append(q.Locks, lck)
+			if len(q.Locks) == needed {
+				return q, nil
+			}
+			continue
+		}
+
+		q.Skipped[dir] = err
+		if !errors.Is(err, ErrBackend) {
+			break
+		}
+		c.logger().Warn("skipping lock directory for the quorum", "lock", c.Name, "dir", dir, "error", err)
+	}
+
+	err := error(&QuorumErr{len(q.Locks), needed, q.Skipped})
+	if releaseErr := releaseAll(q.Locks); releaseErr != nil {
+		err = fmt.Errorf("%w (also failed to release held locks: %v)", err, releaseErr)
+	}
+	return nil, err
+}
+
+// Release releases the locks of the quorum
+func (q *QuorumLock) Release() error {
+	return releaseAll(q.Locks)
+}
+
+// StillHeld tells if the lock is still held in a majority of the
+// directories, the ones which cannot be checked counting as lost
+func (q *QuorumLock) StillHeld() bool {
+	held := 0
+	for _, lck := range q.Locks {
+		if ok, err := lck.StillHeld(); err == nil && ok {
+			held++
+		}
+	}
+	return held >= q.needed
+}
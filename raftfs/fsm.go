@@ -0,0 +1,373 @@
+package raftfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/brinick/lock"
+)
+
+// command is an operation on the replicated filesystem. The reads are run
+// on the leader, the writes are committed to the Raft log and applied by
+// every node, their Time being set by the leader so that all apply the
+// same modification time.
+type command struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	To   string      `json:"to,omitempty"`
+	Flag int         `json:"flag,omitempty"`
+	Mode fs.FileMode `json:"mode,omitempty"`
+	Data []byte      `json:"data,omitempty"`
+	Time time.Time   `json:"time"`
+}
+
+// isRead tells if the command does not change the filesystem
+func (c *command) isRead() bool {
+	switch c.Op {
+	case "stat", "read", "readdir":
+		return true
+	default:
+		return false
+	}
+}
+
+// response is the result of a command
+type response struct {
+	Stat  *stat  `json:"stat,omitempty"`
+	Stats []stat `json:"stats,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+	Err   *opErr `json:"err,omitempty"`
+}
+
+func (r *response) err() error {
+	if r.Err == nil {
+		return nil
+	}
+	return r.Err
+}
+
+// opErr is a failed command, its Kind telling the fs package error it
+// matches, so that it survives being forwarded to another node
+type opErr struct {
+	Kind string `json:"kind,omitempty"`
+	Msg  string `json:"msg"`
+}
+
+var errRetry = errors.New("no Raft leader available")
+
+var kinds = map[string]error{
+	"exist":    fs.ErrExist,
+	"notexist": fs.ErrNotExist,
+	"invalid":  fs.ErrInvalid,
+	"retry":    errRetry,
+	"backend":  lock.ErrBackend,
+}
+
+func (e *opErr) Error() string {
+	return e.Msg
+}
+
+func (e *opErr) Unwrap() error {
+	return kinds[e.Kind]
+}
+
+func failed(kind, msg string) *response {
+	return &response{Err: &opErr{kind, msg}}
+}
+
+// stat describes a file
+type stat struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+func (s stat) info() fs.FileInfo {
+	return fileInfo{s}
+}
+
+type fileInfo struct {
+	s stat
+}
+
+func (i fileInfo) Name() string       { return i.s.Name }
+func (i fileInfo) Size() int64        { return i.s.Size }
+func (i fileInfo) Mode() fs.FileMode  { return i.s.Mode }
+func (i fileInfo) ModTime() time.Time { return i.s.ModTime }
+func (i fileInfo) IsDir() bool        { return i.s.Mode.IsDir() }
+func (i fileInfo) Sys() any           { return nil }
+
+// node is a file or directory of the replicated filesystem
+type node struct {
+	Data    []byte      `json:"data,omitempty"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// fsm is the replicated filesystem, the nodes being keyed by their
+// clean absolute path. The root directory always exists.
+type fsm struct {
+	mu    sync.RWMutex
+	nodes map[string]*node
+}
+
+var _ raft.FSM = (*fsm)(nil)
+
+func newFSM() *fsm {
+	return &fsm{nodes: map[string]*node{}}
+}
+
+// clean returns the key of the path
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (m *fsm) lookup(name string) (*node, bool) {
+	if name == "/" {
+		return &node{Mode: fs.ModeDir | 0755}, true
+	}
+	n, ok := m.nodes[name]
+	return n, ok
+}
+
+// children returns the paths of the entries of the directory, sorted
+func (m *fsm) children(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var paths []string
+	for p := range m.nodes {
+		if rest, ok := strings.CutPrefix(p, prefix); ok && !strings.Contains(rest, "/") {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// checkParent fails unless the parent of the path is a directory
+func (m *fsm) checkParent(name string) *response {
+	parent, ok := m.lookup(path.Dir(name))
+	switch {
+	case !ok:
+		return failed("notexist", "no such file or directory")
+	case !parent.Mode.IsDir():
+		return failed("", "not a directory")
+	default:
+		return nil
+	}
+}
+
+func (m *fsm) stat(name string) (stat, bool) {
+	n, ok := m.lookup(name)
+	if !ok {
+		return stat{}, false
+	}
+	return stat{path.Base(name), int64(len(n.Data)), n.Mode, n.ModTime}, true
+}
+
+// read runs a command not changing the filesystem
+func (m *fsm) read(c *command) *response {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name := clean(c.Path)
+	n, ok := m.lookup(name)
+	if !ok {
+		return failed("notexist", "no such file or directory")
+	}
+
+	switch c.Op {
+	case "stat":
+		s, _ := m.stat(name)
+		return &response{Stat: &s}
+	case "read":
+		if n.Mode.IsDir() {
+			return failed("", "is a directory")
+		}
+		return &response{Data: append([]byte(nil), n.Data...)}
+	case "readdir":
+		if !n.Mode.IsDir() {
+			return failed("", "not a directory")
+		}
+		var stats []stat
+		for _, p := range m.children(name) {
+			s, _ := m.stat(p)
+			stats = append(stats, s)
+		}
+		return &response{Stats: stats}
+	default:
+		return failed("invalid", fmt.Sprintf("unknown operation %s", c.Op))
+	}
+}
+
+// Apply applies a committed write command
+func (m *fsm) Apply(l *raft.Log) any {
+	var c command
+	if err := json.Unmarshal(l.Data, &c); err != nil {
+		return failed("invalid", fmt.Sprintf("invalid command: %v", err))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := clean(c.Path)
+	n, exists := m.lookup(name)
+
+	switch c.Op {
+	case "open":
+		if exists {
+			switch {
+			case c.Flag&os.O_EXCL != 0:
+				return failed("exist", "file exists")
+			case n.Mode.IsDir():
+				return failed("", "is a directory")
+			case c.Flag&os.O_TRUNC != 0:
+				n.Data, n.ModTime = nil, c.Time
+			}
+			return &response{}
+		}
+		if c.Flag&os.O_CREATE == 0 {
+			return failed("notexist", "no such file or directory")
+		}
+		if r := m.checkParent(name); r != nil {
+			return r
+		}
+		m.nodes[name] = &node{Mode: c.Mode.Perm(), ModTime: c.Time}
+
+	case "write":
+		// written after being removed, as to an unlinked file
+		if !exists || n.Mode.IsDir() {
+			return &response{}
+		}
+		if c.Flag&os.O_APPEND != 0 {
+			n.Data = append(n.Data, c.Data...)
+		} else {
+			if len(c.Data) > len(n.Data) {
+				n.Data = append(n.Data, make([]byte, len(c.Data)-len(n.Data))...)
+			}
+			copy(n.Data, c.Data)
+		}
+		n.ModTime = c.Time
+
+	case "mkdir":
+		if exists {
+			return failed("exist", "file exists")
+		}
+		if r := m.checkParent(name); r != nil {
+			return r
+		}
+		m.nodes[name] = &node{Mode: fs.ModeDir | c.Mode.Perm(), ModTime: c.Time}
+
+	case "remove":
+		switch {
+		case !exists:
+			return failed("notexist", "no such file or directory")
+		case name == "/":
+			return failed("invalid", "cannot remove the root directory")
+		case n.Mode.IsDir() && len(m.children(name)) > 0:
+			return failed("", "directory not empty")
+		}
+		delete(m.nodes, name)
+
+	case "rename":
+		to := clean(c.To)
+		if !exists {
+			return failed("notexist", "no such file or directory")
+		}
+		if r := m.checkParent(to); r != nil {
+			return r
+		}
+		if target, ok := m.lookup(to); ok {
+			switch {
+			case name == to:
+				return &response{}
+			case target.Mode.IsDir() != n.Mode.IsDir():
+				return failed("", "file type mismatch")
+			case target.Mode.IsDir() && len(m.children(to)) > 0:
+				return failed("", "directory not empty")
+			}
+		}
+		if strings.HasPrefix(to, name+"/") {
+			return failed("invalid", "cannot move a directory into itself")
+		}
+
+		moved := map[string]*node{to: n}
+		for p, child := range m.nodes {
+			if rest, ok := strings.CutPrefix(p, name+"/"); ok {
+				moved[to+"/"+rest] = child
+				delete(m.nodes, p)
+			}
+		}
+		delete(m.nodes, name)
+		for p, child := range moved {
+			m.nodes[p] = child
+		}
+
+	case "chmod":
+		if !exists || name == "/" {
+			return failed("notexist", "no such file or directory")
+		}
+		n.Mode = n.Mode.Type() | c.Mode.Perm()
+
+	case "chtimes":
+		if !exists || name == "/" {
+			return failed("notexist", "no such file or directory")
+		}
+		n.ModTime = c.Time
+
+	default:
+		return failed("invalid", fmt.Sprintf("unknown operation %s", c.Op))
+	}
+	return &response{}
+}
+
+// Snapshot returns a copy of the filesystem
+func (m *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	nodes := make(map[string]node, len(m.nodes))
+	for p, n := range m.nodes {
+		nodes[p] = node{append([]byte(nil), n.Data...), n.Mode, n.ModTime}
+	}
+	return snapshot(nodes), nil
+}
+
+// Restore replaces the filesystem by the snapshot
+func (m *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+
+	nodes := map[string]*node{}
+	if err := json.NewDecoder(r).Decode(&nodes); err != nil {
+		return fmt.Errorf("invalid snapshot: %v", err)
+	}
+
+	m.mu.Lock()
+	m.nodes = nodes
+	m.mu.Unlock()
+	return nil
+}
+
+type snapshot map[string]node
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
@@ -0,0 +1,621 @@
+// Package raftfs provides the lock directory filesystem replicated by Raft
+// among a small group of nodes, typically 3 to 5 `lock serve` instances,
+// so that they grant locks by consensus without a shared filesystem.
+//
+// Every change, e.g. the creation of a request or of the mkdir mutex, is
+// committed to the Raft log before it returns, and the reads are run on
+// the leader: the followers forward the operations to it over the Raft
+// port. The group keeps working while a majority of its nodes are up.
+//
+// The connections between the nodes, Raft and forwarded operations alike,
+// are authenticated with a secret shared by the nodes, by a challenge and
+// response, but are not encrypted: the Raft port must not be exposed
+// beyond the network of the nodes.
+// Hard links are not available, so the mkdir mutex protocol is the one
+// to use.
+package raftfs
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+
+	"github.com/brinick/lock"
+)
+
+// Timeout is the time an operation may wait for a leader to be elected
+// and for its change to be committed
+var Timeout = 10 * time.Second
+
+// Config is the configuration of a node
+type Config struct {
+	// ID identifies the node in the group
+	ID string
+
+	// Addr is the host:port the node listens on, reachable by the other
+	// nodes, for Raft and the forwarded operations
+	Addr string
+
+	// Dir is the directory holding the Raft log and snapshots of the node
+	Dir string
+
+	// Peers are the addresses of the nodes of the group by ID, this one
+	// included, used to bootstrap the group on the first start
+	Peers map[string]string
+
+	// Secret is shared by the nodes of the group, which only accept the
+	// connections of the nodes knowing it
+	Secret []byte
+}
+
+// FS is a lock.FS replicated by Raft
+type FS struct {
+	raft      *raft.Raft
+	store     *raftboltdb.BoltStore
+	transport *raft.NetworkTransport
+	stream    *streamLayer
+	fsm       *fsm
+
+	// ready is set once the leader applied the entries of the previous terms
+	ready atomic.Bool
+	done  chan struct{}
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+var _ lock.FS = (*FS)(nil)
+
+// Open starts the node, bootstrapping the group with the peers if the node
+// has no Raft state yet
+func Open(cfg Config) (*FS, error) {
+	if len(cfg.ID) == 0 {
+		return nil, fmt.Errorf("no Raft node ID")
+	}
+	if len(cfg.Secret) == 0 {
+		return nil, fmt.Errorf("no Raft secret, needed to authenticate the nodes")
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil || advertise.IP == nil || advertise.IP.IsUnspecified() {
+		return nil, fmt.Errorf("invalid Raft address %q, expected a host:port reachable by the other nodes", cfg.Addr)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Warn, Output: os.Stderr})
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the Raft log: %v", err)
+	}
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(cfg.Dir, 2, logger)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	lis, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	f := &FS{store: store, fsm: newFSM(), done: make(chan struct{}), idle: map[string][]*conn{}}
+	stream := &streamLayer{Listener: lis, addr: advertise, secret: cfg.Secret, raft: make(chan net.Conn), forward: f.serve, done: f.done}
+	f.stream = stream
+	go stream.accept()
+	f.transport = raft.NewNetworkTransportWithLogger(stream, 3, Timeout, logger)
+
+	leader := make(chan bool, 1)
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.ID)
+	conf.Logger = logger
+	conf.NotifyCh = leader
+
+	fail := func(err error) (*FS, error) {
+		close(f.done)
+		f.transport.Close()
+		store.Close()
+		return nil, err
+	}
+
+	existing, err := raft.HasExistingState(store, store, snapshots)
+	if err != nil {
+		return fail(err)
+	}
+	if !existing {
+		var servers []raft.Server
+		for id, addr := range cfg.Peers {
+			servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+		}
+		if _, ok := cfg.Peers[cfg.ID]; !ok {
+			servers = append(servers, raft.Server{ID: conf.LocalID, Address: raft.ServerAddress(cfg.Addr)})
+		}
+		err := raft.BootstrapCluster(conf, store, store, snapshots, f.transport, raft.Configuration{Servers: servers})
+		if err != nil {
+			return fail(fmt.Errorf("unable to bootstrap the Raft group: %v", err))
+		}
+	}
+
+	if f.raft, err = raft.NewRaft(conf, f.fsm, store, store, snapshots, f.transport); err != nil {
+		return fail(err)
+	}
+	go f.watchLeadership(leader)
+	return f, nil
+}
+
+// watchLeadership marks the node ready to serve reads once, leader, it
+// applied all the entries committed before
+func (f *FS) watchLeadership(leader <-chan bool) {
+	for {
+		select {
+		case isLeader := <-leader:
+			f.ready.Store(false)
+			if isLeader && f.raft.Barrier(Timeout).Error() == nil {
+				f.ready.Store(true)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// Close stops the node
+func (f *FS) Close() error {
+	close(f.done)
+	err := f.raft.Shutdown().Error()
+	f.transport.Close()
+
+	f.mu.Lock()
+	for _, conns := range f.idle {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	f.idle = nil
+	f.mu.Unlock()
+
+	if closeErr := f.store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	resp, err := f.do(&command{Op: "stat", Path: name})
+	if err != nil {
+		return nil, pathErr("stat", name, err)
+	}
+	return resp.Stat.info(), nil
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	resp, err := f.do(&command{Op: "read", Path: name})
+	if err != nil {
+		return nil, pathErr("read", name, err)
+	}
+	return resp.Data, nil
+}
+
+// OpenFile opens the file, creating or truncating it at once if asked to.
+// The data written is committed when the file is closed.
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (lock.File, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		_, err := f.do(&command{Op: "open", Path: name, Flag: flag, Mode: perm})
+		if err != nil {
+			return nil, pathErr("open", name, err)
+		}
+		return &file{fs: f, name: name, flag: flag}, nil
+	}
+
+	resp, err := f.do(&command{Op: "read", Path: name})
+	if err != nil {
+		return nil, pathErr("open", name, err)
+	}
+	return &file{fs: f, name: name, flag: flag, data: resp.Data}, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	resp, err := f.do(&command{Op: "readdir", Path: name})
+	if err != nil {
+		return nil, pathErr("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(resp.Stats))
+	for _, s := range resp.Stats {
+		entries = append(entries, fs.FileInfoToDirEntry(s.info()))
+	}
+	return entries, nil
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	_, err := f.do(&command{Op: "mkdir", Path: name, Mode: perm})
+	return pathErr("mkdir", name, err)
+}
+
+func (f *FS) Remove(name string) error {
+	_, err := f.do(&command{Op: "remove", Path: name})
+	return pathErr("remove", name, err)
+}
+
+func (f *FS) Rename(oldpath, newpath string) error {
+	_, err := f.do(&command{Op: "rename", Path: oldpath, To: newpath})
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: kind(err)}
+	}
+	return nil
+}
+
+// Link is not supported, use the mkdir mutex protocol
+func (f *FS) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	_, err := f.do(&command{Op: "chmod", Path: name, Mode: mode})
+	return pathErr("chmod", name, err)
+}
+
+// KeepsAccessTime tells the access time is not kept, only the
+// modification time being replicated
+func (f *FS) KeepsAccessTime() bool {
+	return false
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	_, err := f.do(&command{Op: "chtimes", Path: name, Time: mtime})
+	return pathErr("chtimes", name, err)
+}
+
+// do runs the command on the leader, waiting up to the Timeout for one
+func (f *FS) do(c *command) (*response, error) {
+	deadline := time.Now().Add(Timeout)
+	for {
+		resp, err := f.try(c)
+		if !errors.Is(err, errRetry) {
+			return resp, err
+		}
+		if time.Now().After(deadline) {
+			return nil, &lock.BackendErr{Err: err}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (f *FS) try(c *command) (*response, error) {
+	if f.raft.State() == raft.Leader {
+		resp := f.local(c)
+		return resp, resp.err()
+	}
+
+	addr, _ := f.raft.LeaderWithID()
+	if len(addr) == 0 {
+		return nil, errRetry
+	}
+	return f.forward(string(addr), c)
+}
+
+// local runs the command on this node, which should be the leader
+func (f *FS) local(c *command) *response {
+	if c.isRead() {
+		if f.raft.State() != raft.Leader || !f.ready.Load() {
+			return failed("retry", errRetry.Error())
+		}
+		// a deposed leader, e.g. partitioned, must not serve stale entries
+		if err := f.raft.VerifyLeader().Error(); err != nil {
+			return failed("retry", errRetry.Error())
+		}
+		return f.fsm.read(c)
+	}
+
+	if c.Op != "chtimes" {
+		c.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return failed("invalid", err.Error())
+	}
+
+	future := f.raft.Apply(data, Timeout)
+	switch err := future.Error(); {
+	case errors.Is(err, raft.ErrNotLeader):
+		return failed("retry", errRetry.Error())
+	case err != nil:
+		// the change may or may not have been committed
+		return failed("backend", fmt.Sprintf("Raft commit failed: %v", err))
+	}
+	return future.Response().(*response)
+}
+
+// forward runs the command on the leader at the given address
+func (f *FS) forward(addr string, c *command) (*response, error) {
+	cn, err := f.conn(addr)
+	if err != nil {
+		return nil, errRetry
+	}
+
+	cn.SetDeadline(time.Now().Add(2 * Timeout))
+	var resp response
+	if err := cn.enc.Encode(c); err == nil {
+		err = cn.dec.Decode(&resp)
+	}
+	if err != nil {
+		cn.Close()
+		if c.isRead() {
+			return nil, errRetry
+		}
+		return nil, &lock.BackendErr{Err: fmt.Errorf("Raft leader %s unreachable: %v", addr, err)}
+	}
+
+	f.release(addr, cn)
+	return &resp, resp.err()
+}
+
+// forwardByte starts the connections of forwarded operations, the Raft
+// ones starting with an RPC type, a small integer
+const forwardByte = 0xf0
+
+// conn is a connection to the leader for forwarded operations
+type conn struct {
+	net.Conn
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+// conn returns an idle connection to the address, or a new one
+func (f *FS) conn(addr string) (*conn, error) {
+	f.mu.Lock()
+	if conns := f.idle[addr]; len(conns) > 0 {
+		cn := conns[len(conns)-1]
+		f.idle[addr] = conns[:len(conns)-1]
+		f.mu.Unlock()
+		return cn, nil
+	}
+	f.mu.Unlock()
+
+	nc, err := f.stream.Dial(raft.ServerAddress(addr), Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := nc.Write([]byte{forwardByte}); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &conn{nc, json.NewEncoder(nc), json.NewDecoder(nc)}, nil
+}
+
+// release keeps the connection for the next operations
+func (f *FS) release(addr string, cn *conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.idle != nil && len(f.idle[addr]) < 8 {
+		f.idle[addr] = append(f.idle[addr], cn)
+		return
+	}
+	cn.Close()
+}
+
+// serve runs the operations forwarded by another node
+func (f *FS) serve(nc net.Conn) {
+	defer nc.Close()
+
+	dec := json.NewDecoder(nc)
+	enc := json.NewEncoder(nc)
+	for {
+		var c command
+		if err := dec.Decode(&c); err != nil {
+			return
+		}
+		var resp *response
+		if f.raft.State() == raft.Leader {
+			resp = f.local(&c)
+		} else {
+			resp = failed("retry", errRetry.Error())
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// streamLayer shares the listener between Raft and the forwarded
+// operations, told apart by the first byte of the connections, once
+// authenticated
+type streamLayer struct {
+	net.Listener
+	addr    net.Addr
+	secret  []byte
+	raft    chan net.Conn
+	forward func(net.Conn)
+	done    chan struct{}
+}
+
+func (s *streamLayer) accept() {
+	for {
+		nc, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.dispatch(nc)
+	}
+}
+
+func (s *streamLayer) dispatch(nc net.Conn) {
+	r := bufio.NewReader(nc)
+	nc.SetDeadline(time.Now().Add(Timeout))
+	err := s.challenge(nc, r)
+	var first []byte
+	if err == nil {
+		first, err = r.Peek(1)
+	}
+	nc.SetDeadline(time.Time{})
+	if err != nil {
+		nc.Close()
+		return
+	}
+
+	bc := &bufferedConn{nc, r}
+	if first[0] == forwardByte {
+		r.Discard(1)
+		s.forward(bc)
+		return
+	}
+	select {
+	case s.raft <- bc:
+	case <-s.done:
+		nc.Close()
+	}
+}
+
+// Accept returns the next Raft connection
+func (s *streamLayer) Accept() (net.Conn, error) {
+	select {
+	case nc := <-s.raft:
+		return nc, nil
+	case <-s.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (s *streamLayer) Addr() net.Addr {
+	return s.addr
+}
+
+// Dial connects to the node, answering its challenge
+func (s *streamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	nc, err := net.DialTimeout("tcp", string(addr), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	nc.SetDeadline(time.Now().Add(timeout))
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(nc, nonce); err == nil {
+		_, err = nc.Write(s.mac(nonce))
+	}
+	nc.SetDeadline(time.Time{})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("unable to authenticate to Raft node %s: %v", addr, err)
+	}
+	return nc, nil
+}
+
+// nonceSize is the size of the challenges sent to the connecting nodes
+const nonceSize = 32
+
+// challenge sends a random nonce to the connecting node, which must answer
+// with its HMAC under the shared secret, so that answers cannot be replayed
+func (s *streamLayer) challenge(nc net.Conn, r io.Reader) error {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := nc.Write(nonce); err != nil {
+		return err
+	}
+
+	answer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, answer); err != nil {
+		return err
+	}
+	if !hmac.Equal(answer, s.mac(nonce)) {
+		return fmt.Errorf("Raft connection from %s failed authentication", nc.RemoteAddr())
+	}
+	return nil
+}
+
+// mac returns the HMAC-SHA256 of the nonce under the shared secret
+func (s *streamLayer) mac(nonce []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// bufferedConn reads a connection through the reader that peeked it
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+// file is a file opened with OpenFile
+type file struct {
+	fs   *FS
+	name string
+	flag int
+	data []byte
+	off  int
+	buf  []byte
+}
+
+func (d *file) Read(p []byte) (int, error) {
+	if d.off >= len(d.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[d.off:])
+	d.off += n
+	return n, nil
+}
+
+func (d *file) Write(p []byte) (int, error) {
+	if d.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, pathErr("write", d.name, fs.ErrPermission)
+	}
+	d.buf = append(d.buf, p...)
+	return len(p), nil
+}
+
+// Close commits the data written
+func (d *file) Close() error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	_, err := d.fs.do(&command{Op: "write", Path: d.name, Flag: d.flag & os.O_APPEND, Data: d.buf})
+	d.buf = nil
+	return pathErr("write", d.name, err)
+}
+
+// pathErr returns the error as an *fs.PathError, as returned by the os
+// package, so that os.IsNotExist and os.IsExist work with it
+func pathErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: kind(err)}
+}
+
+// kind returns the fs package error of a failed operation, if any, which
+// os.IsExist and os.IsNotExist only recognize unwrapped
+func kind(err error) error {
+	var o *opErr
+	if errors.As(err, &o) {
+		switch o.Kind {
+		case "exist", "notexist", "invalid":
+			return kinds[o.Kind]
+		case "backend":
+			return &lock.BackendErr{Err: o}
+		}
+	}
+	return err
+}
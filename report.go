@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"sort"
+	"time"
+)
+
+// OwnerReport summarises the lock usage of a single owner node
+type OwnerReport struct {
+	Node string
+
+	// Held are the names of the locks currently held by the node
+	Held []string
+
+	// HoldCount is the number of past holds recorded for the node
+	HoldCount int
+
+	// HoldTime is the total time the node held locks for in the past
+	HoldTime time.Duration
+}
+
+// ReportByOwner aggregates the currently held locks and the recorded
+// hold time of all locks in the directory, per owner node.
+// The result is sorted by descending total hold time.
+func ReportByOwner(dir string) ([]OwnerReport, error) {
+	byNode := map[string]*OwnerReport{}
+	get := func(node string) *OwnerReport {
+		r, ok := byNode[node]
+		if !ok {
+			r = &OwnerReport{Node: node}
+			byNode[node] = r
+		}
+		return r
+	}
+
+	for _, lck := range *entriesTree(dir).withFiletype(lockFileType) {
+		r := get(lck.node())
+		r.Held = append(r.Held, lck.qualifiedName(dir))
+	}
+
+	names, err := statsNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		stats, err := ReadStats(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for node, hold := range stats.HoldByNode {
+			r := get(node)
+			r.HoldCount += hold.Count
+			r.HoldTime += hold.Total
+		}
+	}
+
+	var reports []OwnerReport
+	for _, r := range byNode {
+		sort.Strings(r.Held)
+		reports = append(reports, *r)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].HoldTime != reports[j].HoldTime {
+			return reports[i].HoldTime > reports[j].HoldTime
+		}
+		return reports[i].Node < reports[j].Node
+	})
+	return reports, nil
+}
@@ -0,0 +1,151 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Request is a lock request file waiting in the queue for the lock
+type Request struct {
+	entry
+}
+
+// Enqueue joins the queue of the configured lock without waiting, the
+// caller then waiting for the lock with Wait, or leaving the queue with
+// Cancel. A copy of cfg is used, or of the package configuration if nil.
+func Enqueue(cfg *Configuration) (*Request, error) {
+	c := configCopy()
+	if cfg != nil {
+		c = *cfg
+	}
+
+	if err := ValidateName(c.Name); err != nil {
+		return nil, err
+	}
+	req, err := c.enqueue()
+	if err == nil {
+		// no wait in progress until Wait is called
+		trackRequest(req.path, false)
+	}
+	return req, err
+}
+
+// Wait waits for the request to be first in queue and the lock free, and
+// takes it, the request leaving the queue. If the context is done first,
+// its error is returned and the request stays queued, so that Wait can be
+// called again later. The MaxWait of the configuration does not apply.
+func (r *Request) Wait(ctx context.Context) (*Lock, error) {
+	c := r.conf()
+	span := c.startSpan("lock.wait",
+		Attribute{"lock.name", c.Name},
+		Attribute{"lock.dir", c.Dir},
+	)
+	defer span.End()
+
+	never := func() bool { return false }
+	lck, err := r.wait(span, never, ctx.Done(), ctx.Err)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return lck, err
+}
+
+// Cancel leaves the queue, removing the request
+func (r *Request) Cancel() error {
+	r.conf().logger().Info("lock request canceled", "lock", r.name(), "request", r.path)
+	return r.Remove()
+}
+
+// Position returns the 1-based place of the request in the queue,
+// 1 meaning it is next in line to take the lock.
+func (r *Request) Position() int {
+	return r.position()
+}
+
+// lastSeen returns when the request was last known alive: its creation,
+// or its last heartbeat, which is recorded in the access time so that the
+// modification time used by OrderByFileTime is left alone
+func (r *Request) lastSeen() (time.Time, error) {
+	info, err := r.conf().filesystem().Stat(r.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seen := info.ModTime()
+	if at := accessTime(info); at.After(seen) {
+		seen = at
+	}
+	return seen, nil
+}
+
+// expired tells if the request was not seen alive for longer than RequestTTL
+func (r *Request) expired() bool {
+	c := r.conf()
+	if c.RequestTTL <= 0 {
+		return false
+	}
+
+	seen, err := r.lastSeen()
+	return err == nil && c.since(seen) > c.RequestTTL
+}
+
+// skipped tells if the request is ignored by the queue, not having been
+// seen alive within RequestTTL or SkipAfterPolls poll intervals
+func (r *Request) skipped() bool {
+	c := r.conf()
+	if c.RequestTTL <= 0 && c.SkipAfterPolls <= 0 {
+		return false
+	}
+
+	seen, err := r.lastSeen()
+	if err != nil {
+		return false
+	}
+
+	age := c.since(seen)
+	if c.RequestTTL > 0 && age > c.RequestTTL {
+		return true
+	}
+	return c.SkipAfterPolls > 0 && age > time.Duration(c.SkipAfterPolls)*c.PollInterval
+}
+
+// heartbeat signals that the waiter is alive, at every poll, recreating
+// the request in its place in the queue if it was reaped in the meantime
+func (r *Request) heartbeat() error {
+	c := r.conf()
+	info, err := c.filesystem().Stat(r.path)
+	if os.IsNotExist(err) {
+		c.logger().Warn("lock request was removed while waiting, recreating it", "lock", c.Name, "request", r.path)
+		return r.createWithMetadata(c.Metadata)
+	}
+	if err != nil {
+		return err
+	}
+	return c.filesystem().Chtimes(r.path, c.clock().Now(), info.ModTime())
+}
+
+// reapExpiredRequests removes the requests of the configured name
+// not seen alive for longer than RequestTTL, other than own
+func (c *Configuration) reapExpiredRequests(own *Request) {
+	if c.RequestTTL <= 0 {
+		return
+	}
+
+	for _, e := range *c.requestsNamed(c.Dir, c.Name) {
+		r := Request{e}
+		if r.path == own.path || !r.expired() {
+			continue
+		}
+
+		c.logger().Warn("removing expired lock request", "lock", c.Name, "request", r.path, "ttl", c.RequestTTL)
+		if c.OnStaleDetected != nil {
+			c.OnStaleDetected(r.path)
+		}
+		if err := r.Remove(); err != nil && !os.IsNotExist(err) {
+			c.logger().Error("failed to remove expired lock request", "path", r.path, "error", err)
+			continue
+		}
+		c.metrics().StaleRemoved(c.Name)
+	}
+}
@@ -0,0 +1,65 @@
+package lock
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how long to wait before checking again whether the
+// request is first in queue, or whether the lock can be created
+type RetryPolicy interface {
+	// Delay returns the time to wait after the given failed attempt, counted from 1
+	Delay(attempt int) time.Duration
+}
+
+// RetryPolicyFunc adapts a function to a RetryPolicy
+type RetryPolicyFunc func(attempt int) time.Duration
+
+func (f RetryPolicyFunc) Delay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// FixedInterval waits the same time between all attempts
+type FixedInterval time.Duration
+
+func (f FixedInterval) Delay(int) time.Duration {
+	return time.Duration(f)
+}
+
+// ExponentialBackoff waits Initial after the first attempt, then multiplies
+// the wait by Multiplier (2 if not set) after each attempt, up to Max if set.
+// Jitter, between 0 and 1, randomly shortens each wait by up to that
+// fraction so that clients started together don't retry together.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d -= d * b.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// retryPolicy returns the configured RetryPolicy, waiting
+// PollInterval between attempts if not set
+func (c *Configuration) retryPolicy() RetryPolicy {
+	if c.Retry == nil {
+		return FixedInterval(c.PollInterval)
+	}
+	return c.Retry
+}
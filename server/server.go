@@ -0,0 +1,267 @@
+// Package server exposes the locks of a lock directory over HTTP,
+// with JSON bodies, for clients without access to the filesystem.
+//
+//	POST   /locks             acquire a lock, blocking until granted or timed out
+//	GET    /locks             list the held locks
+//	GET    /requests          list the pending requests, in queue order
+//	POST   /locks/{id}/renew  renew a held lock
+//	DELETE /locks/{id}        release a held lock
+//
+// The lock IDs are only returned to the clients acquiring the locks, the
+// listings leaving them out, so that only these clients can renew and
+// release them. If the server has a Token, the clients must present it.
+//
+// It also serves the state locking of the Terraform HTTP backend, with
+// its LOCK and UNLOCK methods, at /terraform/{state}, the state itself
+// being stored at the backend address:
+//
+//	terraform {
+//	  backend "http" {
+//	    address        = "https://state-store/prod"
+//	    lock_address   = "http://lock-server:8080/terraform/prod"
+//	    unlock_address = "http://lock-server:8080/terraform/prod"
+//	    password       = "<token>"
+//	  }
+//	}
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// AcquireRequest is the body of an acquire call, durations being in
+// seconds. A negative MaxWait waits forever, 0 the server default.
+type AcquireRequest struct {
+	Name         string            `json:"name"`
+	PollInterval int               `json:"poll_interval,omitempty"`
+	MaxWait      int               `json:"max_wait,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// ErrorResponse is the body of failed calls
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the locks of a lock directory over HTTP. Each call works
+// on its own copy of Config, so that acquisitions run concurrently.
+type Server struct {
+	// Config is the base configuration of the acquired locks,
+	// its Dir is the lock directory served
+	Config lock.Configuration
+
+	// Token, if set, must be sent by the clients as a bearer token, or as
+	// the password of basic authentication, e.g. by Terraform
+	Token string
+}
+
+// New returns a Server for the locks in the given directory
+func New(dir string) *Server {
+	cfg := lock.DefaultConfig()
+	cfg.Dir = dir
+	return &Server{Config: cfg}
+}
+
+// ListenAndServe serves the locks at the given address
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+// ListenAndServeUnix serves the locks on a Unix socket at the given path,
+// for fast IPC with the local processes.
+func (s *Server) ListenAndServeUnix(path string) error {
+	lis, err := ListenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+	return s.Serve(lis)
+}
+
+// ListenUnix listens on a Unix socket at the given path, usable by the
+// processes of the group. A leftover socket file from a previous run is replaced.
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0660); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+// Serve serves the locks on the given listener
+func (s *Server) Serve(lis net.Listener) error {
+	return http.Serve(lis, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="lock"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "locks" && r.Method == http.MethodGet:
+		s.list(w, func(m *lock.Manager) ([]lock.Entry, error) {
+			held, err := m.Locks()
+			return entryList(held), err
+		})
+	case len(parts) == 1 && parts[0] == "locks" && r.Method == http.MethodPost:
+		s.acquire(w, r)
+	case len(parts) == 1 && parts[0] == "requests" && r.Method == http.MethodGet:
+		s.list(w, func(m *lock.Manager) ([]lock.Entry, error) {
+			reqs, err := m.Requests()
+			return entryList(reqs), err
+		})
+	case len(parts) == 2 && parts[0] == "locks" && r.Method == http.MethodDelete:
+		s.withLock(w, parts[1], (*lock.Lock).Release)
+	case len(parts) == 3 && parts[0] == "locks" && parts[2] == "renew" && r.Method == http.MethodPost:
+		s.withLock(w, parts[1], (*lock.Lock).Renew)
+	case len(parts) > 1 && parts[0] == terraformPrefix:
+		s.terraform(w, r, strings.Join(parts[1:], "/"))
+	case parts[0] == "locks" || parts[0] == "requests":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such resource %s", r.URL.Path))
+	}
+}
+
+// authorized tells if the request carries the Token, if any
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.Token) == 0 {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *Server) acquire(w http.ResponseWriter, r *http.Request) {
+	var req AcquireRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid acquire request: %v", err))
+		return
+	}
+
+	cfg := s.Config
+	if len(req.Name) > 0 {
+		cfg.Name = req.Name
+	}
+	if req.PollInterval > 0 {
+		cfg.PollInterval = time.Duration(req.PollInterval) * time.Second
+	}
+	if req.MaxWait != 0 {
+		// negative waits forever
+		cfg.MaxWait = time.Duration(req.MaxWait) * time.Second
+	}
+	cfg.Priority = req.Priority
+	cfg.Metadata = req.Metadata
+
+	lck, err := lock.AcquireContext(r.Context(), cfg.Name, lock.WithConfig(cfg))
+
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusConflict), err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, lck.Info(s.Config.Dir))
+}
+
+// manager returns a Manager of the lock directory with a copy of Config
+func (s *Server) manager() *lock.Manager {
+	cfg := s.Config
+	return &lock.Manager{Config: &cfg}
+}
+
+func (s *Server) list(w http.ResponseWriter, read func(*lock.Manager) ([]lock.Entry, error)) {
+	m := s.manager()
+	found, err := read(m)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	infos := []lock.Info{}
+	for _, e := range found {
+		// the IDs, also in the paths, are left to the acquirers
+		info := m.Info(e)
+		info.ID, info.Path = "", ""
+		infos = append(infos, info)
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// entryList returns the locks or requests as entries
+func entryList[E lock.Entry](items []E) []lock.Entry {
+	var out []lock.Entry
+	for _, e := range items {
+		out = append(out, e)
+	}
+	return out
+}
+
+func (s *Server) withLock(w http.ResponseWriter, id string, action func(*lock.Lock) error) {
+	lck, err := s.manager().Attach(id)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusNotFound), err)
+		return
+	}
+
+	if err := action(lck); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// errorStatus returns the HTTP status matching the lock error,
+// the given default one for other errors
+func errorStatus(err error, default_ int) int {
+	switch {
+	case errors.Is(err, lock.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, lock.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, lock.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, lock.ErrBackend):
+		return http.StatusServiceUnavailable
+	default:
+		return default_
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{err.Error()})
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brinick/lock"
+)
+
+// Methods of the Terraform HTTP backend, its default lock_method and unlock_method
+const (
+	MethodLock   = "LOCK"
+	MethodUnlock = "UNLOCK"
+)
+
+// terraformPrefix is the path of the Terraform state locks, and the
+// parent of their lock names
+const terraformPrefix = "terraform"
+
+// Metadata keys of the Terraform lock info stored in the locks
+const (
+	metaTFID        = "terraform_id"
+	metaTFOperation = "terraform_operation"
+	metaTFInfo      = "terraform_info"
+	metaTFWho       = "terraform_who"
+	metaTFVersion   = "terraform_version"
+	metaTFPath      = "terraform_path"
+)
+
+// TerraformLockInfo is the lock info sent by the Terraform HTTP backend,
+// and returned to it for the lock in the way
+type TerraformLockInfo struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+}
+
+// terraform serves the state locking of the Terraform HTTP backend for
+// the state with the given name, its lock being terraform/<state>:
+//
+//	LOCK   /terraform/{state}  lock the state, 423 with the holder if locked
+//	UNLOCK /terraform/{state}  unlock the state
+func (s *Server) terraform(w http.ResponseWriter, r *http.Request, state string) {
+	info, err := readTerraformLockInfo(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid lock info: %v", err))
+		return
+	}
+
+	name := terraformPrefix + "/" + state
+	switch r.Method {
+	case MethodLock:
+		s.terraformLock(w, r, name, info)
+	case MethodUnlock:
+		s.terraformUnlock(w, name, info)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// terraformLock takes the lock of the state without waiting, Terraform
+// retrying by itself until its -lock-timeout
+func (s *Server) terraformLock(w http.ResponseWriter, r *http.Request, name string, info TerraformLockInfo) {
+	if len(info.ID) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing lock ID"))
+		return
+	}
+
+	cfg := s.Config
+	cfg.Name = name
+	cfg.MaxWait = 0
+	cfg.Metadata = map[string]string{
+		metaTFID:        info.ID,
+		metaTFOperation: info.Operation,
+		metaTFInfo:      info.Info,
+		metaTFWho:       info.Who,
+		metaTFVersion:   info.Version,
+		metaTFPath:      info.Path,
+	}
+
+	_, err := lock.AcquireContext(r.Context(), cfg.Name, lock.WithConfig(cfg))
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, info)
+	case errors.Is(err, lock.ErrWouldBlock):
+		s.writeTerraformHolder(w, name, err)
+	default:
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+	}
+}
+
+// terraformUnlock releases the lock of the state held with the given lock
+// ID, or the first one whatever its ID if none is given, e.g. by
+// force-unlock
+func (s *Server) terraformUnlock(w http.ResponseWriter, name string, info TerraformLockInfo) {
+	m := s.manager()
+	held, err := m.Holders(name)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	if len(held) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("state %s is not locked", strings.TrimPrefix(name, terraformPrefix+"/")))
+		return
+	}
+
+	var holder *lock.Lock
+	if len(info.ID) == 0 {
+		holder = held[0]
+	}
+	for _, l := range held {
+		if holder != nil {
+			break
+		}
+		if terraformHolder(m, l).ID == info.ID {
+			holder = l
+		}
+	}
+	if holder == nil {
+		writeJSON(w, http.StatusConflict, terraformHolder(m, held[0]))
+		return
+	}
+
+	lck, err := m.Attach(holder.ID())
+	if err == nil {
+		err = lck.Release()
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeTerraformHolder answers 423 with the lock info of the holder of
+// the state, as expected by Terraform to report who holds it
+func (s *Server) writeTerraformHolder(w http.ResponseWriter, name string, err error) {
+	m := s.manager()
+	held, herr := m.Holders(name)
+	if herr != nil || len(held) == 0 {
+		// released meanwhile, Terraform retries
+		writeError(w, http.StatusLocked, err)
+		return
+	}
+	writeJSON(w, http.StatusLocked, terraformHolder(m, held[0]))
+}
+
+// terraformHolder returns the Terraform lock info of the held lock, made
+// up from the lock itself if it was not taken by Terraform, without its
+// ID which is left to its acquirer
+func terraformHolder(m *lock.Manager, held *lock.Lock) TerraformLockInfo {
+	i := m.Info(held)
+	info := TerraformLockInfo{
+		Who:     i.Node,
+		Created: i.Created,
+	}
+
+	meta, err := held.Metadata()
+	if err != nil || len(meta[metaTFID]) == 0 {
+		return info
+	}
+	info.ID = meta[metaTFID]
+	info.Operation = meta[metaTFOperation]
+	info.Info = meta[metaTFInfo]
+	info.Who = meta[metaTFWho]
+	info.Version = meta[metaTFVersion]
+	info.Path = meta[metaTFPath]
+	return info
+}
+
+// readTerraformLockInfo decodes the lock info, empty if there is no body
+func readTerraformLockInfo(body io.Reader) (TerraformLockInfo, error) {
+	var info TerraformLockInfo
+	err := json.NewDecoder(body).Decode(&info)
+	if err == io.EOF {
+		err = nil
+	}
+	return info, err
+}
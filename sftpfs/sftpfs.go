@@ -0,0 +1,269 @@
+// Package sftpfs provides the lock directory filesystem over SFTP, so that
+// machines without a shared mount can coordinate through a single storage
+// host reachable with SSH. The hard link mutex protocol is not supported,
+// the link counts not being available over SFTP: use the mkdir one.
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/brinick/lock"
+)
+
+// FS is a lock.FS over an SFTP connection
+type FS struct {
+	client *sftp.Client
+	conn   io.Closer
+}
+
+var _ lock.FS = (*FS)(nil)
+
+// New returns the FS of an established SFTP client
+func New(client *sftp.Client) *FS {
+	return &FS{client: client}
+}
+
+// Dial connects to the host of the sftp://[user@]host[:port]/path URL,
+// returning its FS and the path of the lock directory on the host. The
+// user is authenticated with the SSH agent and the unencrypted keys of
+// ~/.ssh, and the host key checked against ~/.ssh/known_hosts.
+func Dial(rawurl string) (*FS, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "sftp" || len(u.Host) == 0 {
+		return nil, "", fmt.Errorf("invalid SFTP lock directory %s, expected sftp://[user@]host[:port]/path", rawurl)
+	}
+	if len(u.Path) == 0 {
+		return nil, "", fmt.Errorf("no lock directory path in %s", rawurl)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, "", err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read the known SSH host keys: %v", err)
+	}
+
+	name := u.User.Username()
+	if len(name) == 0 {
+		current, err := user.Current()
+		if err != nil {
+			return nil, "", err
+		}
+		name = current.Username
+	}
+
+	addr := u.Host
+	if len(u.Port()) == 0 {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            authMethods(home),
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, "", &lock.BackendErr{Err: fmt.Errorf("unable to connect to %s: %v", addr, err)}
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", &lock.BackendErr{Err: fmt.Errorf("unable to start SFTP on %s: %v", addr, err)}
+	}
+
+	return &FS{client, conn}, u.Path, nil
+}
+
+// authMethods returns the SSH agent, if running, and the unencrypted private keys
+func authMethods(home string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, key := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", key))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods
+}
+
+// Close closes the SFTP session and its SSH connection
+func (f *FS) Close() error {
+	err := f.client.Close()
+	if f.conn != nil {
+		if closeErr := f.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.client.Stat(name)
+	return info, pathErr("stat", name, err)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.client.Open(name)
+	if err != nil {
+		return nil, pathErr("open", name, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	return data, pathErr("read", name, err)
+}
+
+// OpenFile opens the file with the given flags. The permissions are those
+// given by the server to new files: callers change them with Chmod.
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (lock.File, error) {
+	file, err := f.client.OpenFile(name, flag)
+	if err != nil {
+		// SFTP v3 has no specific status for an existing file
+		if flag&os.O_EXCL != 0 && f.exists(name) {
+			err = fs.ErrExist
+		}
+		return nil, pathErr("open", name, err)
+	}
+
+	if flag&os.O_APPEND != 0 {
+		// the writes are at the file offset, which starts at 0
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return nil, pathErr("seek", name, err)
+		}
+	}
+	return &sftpFile{file}, nil
+}
+
+// sftpFile is a file opened over SFTP
+type sftpFile struct {
+	*sftp.File
+}
+
+// Sync flushes the file on the server, if it supports the OpenSSH
+// fsync extension, else fails with errors.ErrUnsupported
+func (f *sftpFile) Sync() error {
+	return pathErr("sync", f.Name(), f.File.Sync())
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := f.client.ReadDir(name)
+	if err != nil {
+		return nil, pathErr("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	err := f.client.Mkdir(name)
+	if err != nil && f.exists(name) {
+		err = fs.ErrExist
+	}
+	return pathErr("mkdir", name, err)
+}
+
+func (f *FS) Remove(name string) error {
+	return pathErr("remove", name, f.client.Remove(name))
+}
+
+// Rename replaces newpath if it exists, as os.Rename, if the server
+// supports it: plain SFTP v3 renames fail on existing targets
+func (f *FS) Rename(oldpath, newpath string) error {
+	if _, ok := f.client.HasExtension("posix-rename@openssh.com"); ok {
+		return linkErr("rename", oldpath, newpath, f.client.PosixRename(oldpath, newpath))
+	}
+	return linkErr("rename", oldpath, newpath, f.client.Rename(oldpath, newpath))
+}
+
+func (f *FS) Link(oldname, newname string) error {
+	return linkErr("link", oldname, newname, f.client.Link(oldname, newname))
+}
+
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	return pathErr("chmod", name, f.client.Chmod(name, mode))
+}
+
+// KeepsAccessTime tells the access time is not kept, Stat only reporting
+// the modification time
+func (f *FS) KeepsAccessTime() bool {
+	return false
+}
+
+func (f *FS) Chtimes(name string, atime, mtime time.Time) error {
+	return pathErr("chtimes", name, f.client.Chtimes(name, atime, mtime))
+}
+
+func (f *FS) exists(name string) bool {
+	_, err := f.client.Stat(name)
+	return err == nil
+}
+
+// pathErr returns the error as an *fs.PathError, as returned by the os
+// package, so that os.IsNotExist and os.IsExist work with it
+func pathErr(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: path, Err: kind(err)}
+}
+
+func linkErr(op, oldname, newname string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: kind(err)}
+}
+
+// kind returns the fs package error matching the SFTP status, if any
+func kind(err error) error {
+	var status *sftp.StatusError
+	if !errors.As(err, &status) {
+		return err
+	}
+	switch status.FxCode() {
+	case sftp.ErrSSHFxNoSuchFile:
+		return fs.ErrNotExist
+	case sftp.ErrSSHFxPermissionDenied:
+		return fs.ErrPermission
+	case sftp.ErrSSHFxOpUnsupported:
+		return errors.ErrUnsupported
+	default:
+		return err
+	}
+}
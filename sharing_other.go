@@ -0,0 +1,15 @@
+//go:build !windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+// sharingViolation tells if the operation failed because the file is
+// opened by another client without sharing it, which the Linux CIFS
+// client reports as EBUSY
+func sharingViolation(err error) bool {
+	return errors.Is(err, syscall.EBUSY)
+}
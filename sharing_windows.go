@@ -0,0 +1,19 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"syscall"
+)
+
+// sharingViolation tells if the operation failed because the file is
+// opened by another process without sharing it
+func sharingViolation(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	// ERROR_SHARING_VIOLATION and ERROR_LOCK_VIOLATION
+	return errno == 32 || errno == 33
+}
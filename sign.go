@@ -0,0 +1,110 @@
+package lock
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetaSignature is the metadata key holding the HMAC signature of an entry
+const MetaSignature = "sig"
+
+// SignatureErr is returned when an entry is unsigned or its signature
+// does not match its contents, i.e. it was forged or tampered with
+type SignatureErr struct {
+	Path   string
+	Reason string
+}
+
+func (e *SignatureErr) Error() string {
+	return fmt.Sprintf("entry %s failed signature verification: %s", e.Path, e.Reason)
+}
+
+// LoadSigningKeyFile reads the shared secret used to sign entries from the
+// given file, ignoring surrounding whitespace
+func LoadSigningKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key file %s: %v", path, err)
+	}
+
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("signing key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// SetSigningKey sets the key used to verify entries outside of Acquire,
+// e.g. by Attach or Inspect.
+func SetSigningKey(key []byte) {
+	setConfig(func(c *Configuration) { c.SigningKey = key })
+}
+
+// signature returns the hex encoded HMAC-SHA256 of the entry name, ID,
+// node, creation time, expiry if any and metadata, the signature itself
+// excluded
+func (e *entry) signature(key []byte, meta map[string]string, exp expiry) string {
+	mac := hmac.New(sha256.New, key)
+
+	fields := []string{e.name(), e.ID(), e.node(), strconv.Itoa(e.created())}
+	// the entries without expiry keep the signature they had before it existed
+	if exp != (expiry{}) {
+		fields = append(fields, strconv.FormatInt(exp.At.UnixNano(), 10), strconv.FormatInt(int64(exp.TTL), 10))
+	}
+	for _, field := range fields {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		if k != MetaSignature {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		mac.Write([]byte(k))
+		mac.Write([]byte{0})
+		mac.Write([]byte(meta[k]))
+		mac.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns a SignatureErr if a signing key is configured and the
+// entry is not signed with it
+func (e *entry) verify() error {
+	key := e.conf().SigningKey
+	if len(key) == 0 {
+		return nil
+	}
+
+	meta, _, exp, err := e.readBody()
+	if err != nil {
+		return err
+	}
+	return e.verifyBody(key, meta, exp)
+}
+
+// verifyBody returns a SignatureErr if the entry content read is not
+// signed with the key
+func (e *entry) verifyBody(key []byte, meta map[string]string, exp expiry) error {
+	sig, ok := meta[MetaSignature]
+	if !ok {
+		return &SignatureErr{e.path, "entry is not signed"}
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(e.signature(key, meta, exp))) {
+		return &SignatureErr{e.path, "signature does not match"}
+	}
+	return nil
+}
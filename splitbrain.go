@@ -0,0 +1,63 @@
+package lock
+
+// When clients race on a filesystem without atomic creation, e.g. with
+// mismatched protocols, more locks than MaxLocks can end up held on a
+// name: a split brain. The holders resolve it deterministically, without
+// talking to each other: the locks are ordered by creation time, then ID,
+// the first MaxLocks are kept and the others released by their holders.
+// Meanwhile the waiters keep waiting for the locks to converge.
+
+// SetMaxLocks sets the number of locks which can be held on a name, see
+// Configuration.MaxLocks
+func SetMaxLocks(n int) {
+	setConfig(func(c *Configuration) { c.MaxLocks = n })
+}
+
+// Resolve checks for a split brain on the name of the lock and, if the
+// lock is not amongst the MaxLocks oldest ones, or if shared, if an older
+// exclusive lock is held, releases it and returns an error matching
+// ErrSplitBrain. All the holders must use the same MaxLocks.
+func (l *Lock) Resolve() error {
+	c := l.conf()
+	items, err := c.readEntries(l.dir())
+	if err != nil {
+		return err
+	}
+
+	held := items.withFiletype(lockFileType).withName(l.name())
+	ahead, exclusive, found := 0, 0, false
+	for _, other := range *held {
+		switch {
+		case other.path == l.path:
+			found = true
+		case other.precedes(l.entry):
+			ahead++
+			if !other.shared() {
+				exclusive++
+			}
+		}
+	}
+	if !found || (l.shared() && exclusive == 0) || (!l.shared() && ahead < c.maxLocks()) {
+		// already gone, or kept
+		return nil
+	}
+
+	c.logger().Warn("split brain, releasing the newer lock", "lock", l.name(), "id", l.ID(), "locks", len(*held), "max", c.maxLocks())
+	if err := l.ForceRelease(); err != nil {
+		return err
+	}
+	return &SplitBrainErr{l.ID(), len(*held), c.maxLocks()}
+}
+
+// precedes tells if the lock wins over the other in the split-brain
+// resolution: oldest first, then lowest ID. Unlike the queue order,
+// it does not depend on the Ordering of each holder.
+func (e *entry) precedes(other entry) bool {
+	if e.created() != other.created() {
+		return e.created() < other.created()
+	}
+	if e.ID() != other.ID() {
+		return e.ID() < other.ID()
+	}
+	return e.path < other.path
+}
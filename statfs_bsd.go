@@ -0,0 +1,22 @@
+//go:build darwin || freebsd
+
+package lock
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// statFS returns the statistics of the filesystem of the path
+func statFS(path string) (fsStats, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+
+	return fsStats{
+		Type:       unix.ByteSliceToString(st.Fstypename[:]),
+		FreeBytes:  uint64(st.Bavail) * uint64(st.Bsize),
+		FreeInodes: uint64(st.Ffree),
+		inodes:     st.Files > 0,
+	}, nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package lock
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsTypes are the names of the filesystem magic numbers of statfs(2)
+var fsTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0xef53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x2fc12fc1: "zfs",
+	0x01021994: "tmpfs",
+	0x794c7630: "overlay",
+	0x65735546: "fuse",
+	0x00c36400: "ceph",
+	0x47504653: "gpfs",
+	0x0bd00bd0: "lustre",
+}
+
+// statFS returns the statistics of the filesystem of the path, ext2 and
+// ext3 being reported as ext4, and unknown types by their magic number
+func statFS(path string) (fsStats, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+
+	name, ok := fsTypes[uint32(st.Type)]
+	if !ok {
+		name = fmt.Sprintf("0x%x", uint32(st.Type))
+	}
+	return fsStats{
+		Type:       name,
+		FreeBytes:  uint64(st.Bavail) * uint64(st.Bsize),
+		FreeInodes: uint64(st.Ffree),
+		inodes:     st.Files > 0,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package lock
+
+import "errors"
+
+// statFS is not supported on this platform
+func statFS(path string) (fsStats, error) {
+	return fsStats{}, errors.ErrUnsupported
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package lock
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// statFS returns the statistics of the volume of the path, e.g. NTFS,
+// which has no inode limit
+func statFS(path string) (fsStats, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return fsStats{}, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return fsStats{}, err
+	}
+
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(path) + `\`)
+	if err != nil {
+		return fsStats{}, err
+	}
+	name := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumeInformation(root, nil, 0, nil, nil, nil, &name[0], uint32(len(name))); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{Type: windows.UTF16ToString(name), FreeBytes: free}, nil
+}
@@ -0,0 +1,200 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	releasedFileType = ".released"
+	statsFileType    = ".stats"
+)
+
+// LatencyStats summarises a set of durations measured for a lock
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Total time.Duration `json:"total"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Last  time.Duration `json:"last"`
+}
+
+// Mean returns the average duration
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+func (s *LatencyStats) add(d time.Duration) {
+	if s.Count == 0 || d < s.Min {
+		s.Min = d
+	}
+	if d > s.Max {
+		s.Max = d
+	}
+	s.Count++
+	s.Total += d
+	s.Last = d
+}
+
+// Stats holds the statistics recorded for a lock name
+type Stats struct {
+	// Handoff is the time between a release and the next acquisition
+	Handoff LatencyStats `json:"handoff"`
+
+	// Hold is the time the lock was held for
+	Hold LatencyStats `json:"hold"`
+
+	// HoldByNode is the time the lock was held for, per holder node
+	HoldByNode map[string]LatencyStats `json:"hold_by_node,omitempty"`
+}
+
+// ReadStats returns the recorded statistics for the lock
+// with the given name in the given directory.
+func ReadStats(dir, name string) (Stats, error) {
+	return currentConfig().readStats(nameDir(dir, name))
+}
+
+// readStats reads the stats kept in the directory of a lock name
+func (c *Configuration) readStats(ndir string) (Stats, error) {
+	var stats Stats
+	data, err := c.filesystem().ReadFile(statsPath(ndir))
+	switch {
+	case os.IsNotExist(err):
+		return stats, nil
+	case err != nil:
+		return stats, err
+	}
+
+	// stats are only informative: a corrupt file is started afresh
+	if err := json.Unmarshal(data, &stats); err != nil {
+		c.logger().Warn("ignoring corrupt lock stats", "path", statsPath(ndir), "error", err)
+		return Stats{}, nil
+	}
+	return stats, nil
+}
+
+// statsMu serialises the stats updates of the process, the mutex of the
+// lock name directory those of the other clients
+var statsMu sync.Mutex
+
+// statsMutexAttempts is how many times the mutex of the lock name directory
+// is tried before giving up updating the stats
+const statsMutexAttempts = 10
+
+// updateStats applies the update to the stats of the lock name directory
+// under its mutex, as taken to create the locks, so that concurrent
+// releases do not lose updates, and replaces the stats file at once
+func (c *Configuration) updateStats(ndir string, update func(*Stats)) error {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	unlock, err := c.takeMutex(ndir)
+	for attempt := 1; err == errMutexBusy && attempt < statsMutexAttempts; attempt++ {
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+		unlock, err = c.takeMutex(ndir)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stats, err := c.readStats(ndir)
+	if err != nil {
+		return err
+	}
+	update(&stats)
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return c.commitFile(statsPath(ndir), data)
+}
+
+// recordHold updates the stats of the lock name directory with the time it was
+// held for by the given node. It is called by the holder just before releasing the lock.
+func (c *Configuration) recordHold(ndir, node string, held time.Duration) error {
+	return c.updateStats(ndir, func(stats *Stats) {
+		stats.Hold.add(held)
+
+		if stats.HoldByNode == nil {
+			stats.HoldByNode = map[string]LatencyStats{}
+		}
+		byNode := stats.HoldByNode[node]
+		byNode.add(held)
+		stats.HoldByNode[node] = byNode
+	})
+}
+
+// statsNames returns the, possibly hierarchical, names of
+// all locks with recorded stats in the directory tree
+func statsNames(dir string) ([]string, error) {
+	var names []string
+	err := walkDir(fsys(), dir, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			// unreadable parts of the tree are skipped
+			return nil
+		case d.IsDir() || d.Name() != statsFileType:
+			return nil
+		}
+
+		// the stats file is named after its type, the name being its directory
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil || rel == "." {
+			return nil
+		}
+		name, err := decodePath(filepath.ToSlash(rel))
+		if err != nil {
+			name = filepath.ToSlash(rel)
+		}
+		names = append(names, name)
+		return nil
+	})
+	return names, err
+}
+
+// markReleased records the time at which the lock of the name directory was released
+func (c *Configuration) markReleased(ndir string) error {
+	stamp := strconv.FormatInt(c.clock().Now().UnixNano(), 10)
+	return c.writeFile(releasedPath(ndir), []byte(stamp))
+}
+
+// recordHandoff updates the stats of the lock name directory with the time
+// elapsed since it was last released. It is called by the new holder.
+func (c *Configuration) recordHandoff(ndir string) error {
+	data, err := c.filesystem().ReadFile(releasedPath(ndir))
+	switch {
+	case os.IsNotExist(err):
+		// never released before, nothing to measure
+		return nil
+	case err != nil:
+		return err
+	}
+
+	released, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid release marker %s: %v", releasedPath(ndir), err)
+	}
+
+	handoff := time.Duration(c.clock().Now().UnixNano() - released)
+	return c.updateStats(ndir, func(stats *Stats) { stats.Handoff.add(handoff) })
+}
+
+func releasedPath(ndir string) string {
+	return filepath.Join(ndir, releasedFileType)
+}
+
+func statsPath(ndir string) string {
+	return filepath.Join(ndir, statsFileType)
+}
@@ -0,0 +1,103 @@
+package lock
+
+import (
+	"sync"
+	"time"
+)
+
+// EventExpired is sent by Subscribe when a lock goes past its expiry
+const EventExpired EventType = "expired"
+
+// subscribeInterval is how often Subscribe scans the lock name directory,
+// in addition to when it is notified of local changes
+var subscribeInterval = time.Second
+
+// Subscribe sends an Event when a lock of the given name is acquired,
+// released or goes past its expiry, and when a request is queued or
+// dequeued, until the returned function is called, which closes the
+// channel. Local changes are reported at once where the directory can
+// be watched (Linux), others within a second. Entries existing when
+// Subscribe is called are not reported.
+func (m *Manager) Subscribe(name string) (<-chan Event, func()) {
+	events := make(chan Event, 16)
+	stop := make(chan struct{})
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(stop) }) }
+
+	ndir := nameDir(m.Config.Dir, name)
+	changed, unwatch := watchDir(ndir)
+	known, _ := m.named(name)
+
+	go func() {
+		defer close(events)
+		defer func() { unwatch() }()
+
+		ticker := time.NewTicker(subscribeInterval)
+		defer ticker.Stop()
+
+		expired := map[string]bool{}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-changed:
+			}
+
+			// the directory is only created with the first request
+			if changed == nil {
+				unwatch()
+				changed, unwatch = watchDir(ndir)
+			}
+
+			current, err := m.named(name)
+			if err != nil {
+				// unknown rather than vanished
+				continue
+			}
+			now := time.Now()
+			var found []Event
+
+			for path, e := range current {
+				if _, ok := known[path]; !ok {
+					found = append(found, Event{now, appearedEvent(e), e.info(m.Config.Dir)})
+				}
+				if e.filetype() == lockFileType && !expired[path] && e.lapsed() {
+					expired[path] = true
+					found = append(found, Event{now, EventExpired, e.info(m.Config.Dir)})
+				}
+			}
+
+			for path, e := range known {
+				if _, ok := current[path]; !ok {
+					delete(expired, path)
+					found = append(found, Event{now, vanishedEvent(e), e.info(m.Config.Dir)})
+				}
+			}
+			known = current
+
+			for _, ev := range found {
+				select {
+				case events <- ev:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// named returns the locks and requests of the name by path
+func (m *Manager) named(name string) (map[string]entry, error) {
+	items, err := m.Config.readEntries(nameDir(m.Config.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]entry{}
+	for _, e := range *items.withFiletype(lockFileType).extend(items.withFiletype(requestFileType)).withName(leafName(name)) {
+		found[e.path] = e
+	}
+	return found, nil
+}
@@ -0,0 +1,136 @@
+package lock
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Summary aggregates the usage of a lock name, now and, from its audit
+// log, over a time window
+type Summary struct {
+	Name string `json:"name"`
+
+	// Holders and Waiters are the numbers of locks and requests now
+	Holders int `json:"holders"`
+	Waiters int `json:"waiters"`
+
+	// Acquisitions is the number of times the lock was acquired in the window
+	Acquisitions int `json:"acquisitions"`
+
+	// Timeouts is the number of acquisitions given up in the window
+	Timeouts int `json:"timeouts"`
+
+	// Contention is the fraction of the acquisitions of the window
+	// for which the lock had to be waited for
+	Contention float64 `json:"contention"`
+
+	// HoldMean and HoldP95 are the average and 95th percentile of the
+	// hold times of the locks released in the window
+	HoldMean time.Duration `json:"hold_mean"`
+	HoldP95  time.Duration `json:"hold_p95"`
+}
+
+// Summarize returns the Summary of every lock name of the directory with
+// a lock, a request or an audit log, sorted by name, over the window
+// ending now. Holds, timeouts and contention are only known for the
+// names audited during the window.
+func Summarize(dir string, window time.Duration) ([]Summary, error) {
+	byName := map[string]*Summary{}
+	get := func(name string) *Summary {
+		s, ok := byName[name]
+		if !ok {
+			s = &Summary{Name: name}
+			byName[name] = s
+		}
+		return s
+	}
+
+	for _, e := range *entriesTree(dir) {
+		switch {
+		case e.filetype() == lockFileType && e.valid():
+			get(e.qualifiedName(dir)).Holders++
+		case e.filetype() == requestFileType && e.valid():
+			get(e.qualifiedName(dir)).Waiters++
+		case filepath.Base(e.path) == auditFileName && e.dir() != filepath.Clean(dir):
+			get(e.qualifiedName(dir))
+		}
+	}
+
+	var out []Summary
+	for name, s := range byName {
+		records, err := History(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		s.summarizeAudit(records, clock().Now().Add(-window))
+		out = append(out, *s)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// SummarizeName returns the Summary of the given lock name over the window
+// ending now
+func SummarizeName(dir, name string, window time.Duration) (Summary, error) {
+	s := Summary{
+		Name:    name,
+		Holders: len(*locksNamed(dir, name)),
+		Waiters: len(*requestsNamed(dir, name)),
+	}
+
+	records, err := History(dir, name)
+	if err != nil {
+		return s, err
+	}
+	s.summarizeAudit(records, clock().Now().Add(-window))
+	return s, nil
+}
+
+// summarizeAudit aggregates the audit records, oldest first, since the given time
+func (s *Summary) summarizeAudit(records []AuditRecord, since time.Time) {
+	acquired := map[string]time.Time{}
+	var holds []time.Duration
+	contended := 0
+
+	for _, r := range records {
+		switch r.Event {
+		case AuditAcquire:
+			acquired[r.ID] = r.Time
+			if r.Time.Before(since) {
+				continue
+			}
+			s.Acquisitions++
+			if r.Waited > 0 {
+				contended++
+			}
+		case AuditRelease, AuditBreak, AuditStale:
+			start, ok := acquired[r.ID]
+			if !ok || r.Time.Before(since) {
+				continue
+			}
+			delete(acquired, r.ID)
+			holds = append(holds, r.Time.Sub(start))
+		case AuditTimeout:
+			if !r.Time.Before(since) {
+				s.Timeouts++
+			}
+		}
+	}
+
+	if s.Acquisitions > 0 {
+		s.Contention = float64(contended) / float64(s.Acquisitions)
+	}
+	if len(holds) == 0 {
+		return
+	}
+
+	sort.Slice(holds, func(i, j int) bool { return holds[i] < holds[j] })
+	var total time.Duration
+	for _, h := range holds {
+		total += h
+	}
+	s.HoldMean = total / time.Duration(len(holds))
+	s.HoldP95 = holds[(len(holds)*95+99)/100-1]
+}
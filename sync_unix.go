@@ -0,0 +1,19 @@
+//go:build !windows
+
+package lock
+
+import "os"
+
+// SyncDir flushes the directory, i.e. the names of its entries, to
+// stable storage
+func (osFS) SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
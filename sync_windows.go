@@ -0,0 +1,9 @@
+//go:build windows
+
+package lock
+
+// SyncDir does nothing, directories cannot be flushed on Windows, where
+// NTFS journals the changes of names
+func (osFS) SyncDir(name string) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// journalSocket is the socket of the native journal protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalAvailable tells if the systemd journal runs on this host
+func JournalAvailable() bool {
+	_, err := os.Stat(journalSocket)
+	return err == nil
+}
+
+// Journal sends an entry to the systemd journal with the given fields,
+// e.g. MESSAGE, PRIORITY and SYSLOG_IDENTIFIER. The field names are
+// uppercase letters, digits and underscores, and must not start with an
+// underscore. The entry must fit in a datagram.
+func Journal(fields map[string]string) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, k := range keys {
+		v := fields[k]
+		if !strings.Contains(v, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", k, v)
+			continue
+		}
+		// values on several lines are sent with their size
+		b.WriteString(k)
+		b.WriteByte('\n')
+		binary.Write(&b, binary.LittleEndian, uint64(len(v)))
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("unable to connect to the systemd journal: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("unable to write to the systemd journal: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package lock
+
+import (
+	"os"
+	"time"
+)
+
+// Touch signals that the holder of the entry is alive, without the
+// Heartbeat goroutine: it updates the modification time of the entry file
+// and, if the entry has an expiry, pushes it back by its TTL.
+// The expiry is rewritten in place, so that an entry removed meanwhile
+// is not recreated.
+func (e *entry) Touch() error {
+	meta, format, exp, err := e.readBody()
+	if err != nil {
+		return err
+	}
+
+	fsys := e.conf().filesystem()
+	now := e.conf().clock().Now()
+	if exp.TTL <= 0 {
+		return fsys.Chtimes(e.path, now, now)
+	}
+
+	// the expiry is signed: a tampered entry must not get signed again
+	key := e.conf().SigningKey
+	if len(key) > 0 {
+		if err := e.verifyBody(key, meta, exp); err != nil {
+			return err
+		}
+	}
+
+	exp.At = now.Add(exp.TTL)
+	if len(key) > 0 {
+		meta[MetaSignature] = e.signature(key, meta, exp)
+	}
+	data, err := e.sealBody(format, meta, exp)
+	if err != nil {
+		return err
+	}
+
+	f, err := fsys.OpenFile(e.path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	// a single write, so that readers rarely see the file partially written
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return fsys.Chtimes(e.path, now, now)
+}
+
+// Expiry returns when the entry lapses unless touched, false if it
+// has no expiry or it could not be read
+func (e *entry) Expiry() (time.Time, bool) {
+	_, _, exp, err := e.readBody()
+	if err != nil || exp.At.IsZero() {
+		return time.Time{}, false
+	}
+	return exp.At, true
+}
+
+// TTLRemaining returns the time left before the entry lapses, negative
+// if it already has, false if it has no expiry
+func (e *entry) TTLRemaining() (time.Duration, bool) {
+	at, ok := e.Expiry()
+	if !ok {
+		return 0, false
+	}
+	return at.Sub(e.conf().clock().Now()), true
+}
+
+// lapsed tells if the entry has an expiry which is past
+func (e *entry) lapsed() bool {
+	left, ok := e.TTLRemaining()
+	return ok && left < 0
+}
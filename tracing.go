@@ -0,0 +1,37 @@
+package lock
+
+// Attribute is a key/value pair attached to a tracing span
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Tracer starts the tracing spans of the lock operations,
+// see the otel subpackage for an OpenTelemetry implementation.
+type Tracer interface {
+	Start(name string, attrs ...Attribute) Span
+}
+
+// Span is a traced operation
+type Span interface {
+	// Start starts a child span
+	Start(name string, attrs ...Attribute) Span
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+type nopSpan struct{}
+
+func (s nopSpan) Start(string, ...Attribute) Span { return s }
+func (nopSpan) SetAttributes(...Attribute)        {}
+func (nopSpan) RecordError(error)                 {}
+func (nopSpan) End()                              {}
+
+// startSpan starts a span with the configured Tracer, if any
+func (c *Configuration) startSpan(name string, attrs ...Attribute) Span {
+	if c.Tracer == nil {
+		return nopSpan{}
+	}
+	return c.Tracer.Start(name, attrs...)
+}
@@ -1,27 +1,23 @@
 package lock
 
 import (
-	"fmt"
 	"os"
-	"os/exec"
 	"strings"
-	"time"
 )
 
+// currentNode returns the short host name of the current node,
+// without any domain, which is used to identify the entry owner.
 func currentNode() string {
-	name, _ := os.Hostname()
-	return strings.Replace(name, ".cern.ch", "", -1)
-}
+	name, err := os.Hostname()
+	if err != nil || len(name) == 0 {
+		return "unknown"
+	}
 
-func currentEpoch() int64 {
-	return time.Now().UnixNano()
+	name = strings.SplitN(name, ".", 2)[0]
+	// the double underscore separates the fields of entry file names
+	return strings.ReplaceAll(name, "__", "_")
 }
 
-func newUUID() (string, error) {
-	value, err := exec.Command("uuidgen").Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to generate UUID: %v", err)
-	}
-
-	return strings.TrimSpace(string(value)), nil
+func currentEpoch() int64 {
+	return clock().Now().UnixNano()
 }
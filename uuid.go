@@ -0,0 +1,19 @@
+package lock
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newUUID generates a random (version 4) RFC 4122 UUID in process,
+// as minimal images and Windows lack uuidgen
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
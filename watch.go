@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"time"
+)
+
+// EventType is the kind of change seen in a lock directory
+type EventType string
+
+const (
+	EventAcquired EventType = "acquired"
+	EventReleased EventType = "released"
+	EventQueued   EventType = "queued"
+	EventDequeued EventType = "dequeued"
+	EventStale    EventType = "stale"
+	EventRenewed  EventType = "renewed"
+)
+
+// Event is a change seen in a lock directory
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+	Info
+}
+
+// WatchOptions configure Watch
+type WatchOptions struct {
+	// Interval between scans of the directory, 1s if not set
+	Interval time.Duration
+
+	// StaleAfter, if set, reports held locks not renewed for that long as stale
+	StaleAfter time.Duration
+}
+
+// Watch scans the lock directory every interval and sends an Event for each
+// lock acquired, released or stale and each request queued or dequeued,
+// until stop is closed, at which point the returned channel is closed.
+// Entries existing when Watch is called are not reported.
+func Watch(dir string, opts WatchOptions, stop <-chan struct{}) <-chan Event {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		known := snapshot(dir)
+		stale := map[string]bool{}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			current := snapshot(dir)
+			var found []Event
+
+			for path, e := range current {
+				if _, ok := known[path]; !ok {
+					found = append(found, Event{now, appearedEvent(e), e.info(dir)})
+				}
+
+				if opts.StaleAfter > 0 && e.filetype() == lockFileType && !stale[path] {
+					if info, err := fsys().Stat(path); err == nil && now.Sub(info.ModTime()) > opts.StaleAfter {
+						stale[path] = true
+						found = append(found, Event{now, EventStale, e.info(dir)})
+					}
+				}
+			}
+
+			for path, e := range known {
+				if _, ok := current[path]; !ok {
+					delete(stale, path)
+					found = append(found, Event{now, vanishedEvent(e), e.info(dir)})
+				}
+			}
+			known = current
+
+			for _, ev := range found {
+				select {
+				case events <- ev:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// WatchLock watches the locks held on the given name, sending an Event when
+// one is acquired, released, renewed or, with StaleAfter, goes stale, until
+// stop is closed, at which point the returned channel is closed. It lets
+// waiters react to the lock becoming free without polling the directory.
+// Locks held when WatchLock is called are not reported as acquired.
+func WatchLock(dir, name string, opts WatchOptions, stop <-chan struct{}) <-chan Event {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		known := renewals(dir, name)
+		stale := map[string]bool{}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			current := renewals(dir, name)
+			var found []Event
+
+			for path, r := range current {
+				prev, ok := known[path]
+				switch {
+				case !ok:
+					found = append(found, Event{now, EventAcquired, r.entry.info(dir)})
+				case r.renewed.After(prev.renewed):
+					delete(stale, path)
+					found = append(found, Event{now, EventRenewed, r.entry.info(dir)})
+				}
+
+				if opts.StaleAfter > 0 && !stale[path] && now.Sub(r.renewed) > opts.StaleAfter {
+					stale[path] = true
+					found = append(found, Event{now, EventStale, r.entry.info(dir)})
+				}
+			}
+
+			for path, r := range known {
+				if _, ok := current[path]; !ok {
+					delete(stale, path)
+					found = append(found, Event{now, EventReleased, r.entry.info(dir)})
+				}
+			}
+			known = current
+
+			for _, ev := range found {
+				select {
+				case events <- ev:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
+
+type renewal struct {
+	entry   entry
+	renewed time.Time
+}
+
+// renewals returns the locks held on the name by path, with their last renewal
+func renewals(dir, name string) map[string]renewal {
+	found := map[string]renewal{}
+	for _, e := range *locksNamed(dir, name) {
+		if info, err := fsys().Stat(e.path); err == nil {
+			found[e.path] = renewal{e, info.ModTime()}
+		}
+	}
+	return found
+}
+
+// snapshot returns the lock and request entries of the directory tree by path
+func snapshot(dir string) map[string]entry {
+	found := map[string]entry{}
+	for _, e := range *entriesTree(dir) {
+		if ft := e.filetype(); ft == lockFileType || ft == requestFileType {
+			found[e.path] = e
+		}
+	}
+	return found
+}
+
+func appearedEvent(e entry) EventType {
+	if e.filetype() == lockFileType {
+		return EventAcquired
+	}
+	return EventQueued
+}
+
+func vanishedEvent(e entry) EventType {
+	if e.filetype() == lockFileType {
+		return EventReleased
+	}
+	return EventDequeued
+}
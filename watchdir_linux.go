@@ -0,0 +1,44 @@
+//go:build linux
+
+package lock
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchDir returns a channel receiving a value when entries of the
+// directory are created, removed or modified locally, nil if it cannot be
+// watched. Changes made by other NFS clients are not seen, the directory
+// must still be scanned regularly. The returned function stops watching.
+func watchDir(dir string) (<-chan struct{}, func()) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, func() {}
+	}
+
+	mask := uint32(syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+		syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, func() {}
+	}
+
+	// the non blocking descriptor is handled by the runtime poller,
+	// so that closing the file interrupts the read
+	f := os.NewFile(uintptr(fd), "inotify:"+dir)
+	changed := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed, func() { f.Close() }
+}
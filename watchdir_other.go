@@ -0,0 +1,8 @@
+//go:build !linux
+
+package lock
+
+// watchDir returns nil, the directory being only scanned regularly
+func watchDir(dir string) (<-chan struct{}, func()) {
+	return nil, func() {}
+}
@@ -0,0 +1,139 @@
+package lock
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhook is an HTTP endpoint notified of the lock events with a POST of
+// their EventLogRecord as JSON
+type Webhook struct {
+	URL string
+
+	// Events are the events notified, e.g. AuditAcquire, AuditRelease,
+	// AuditTimeout, AuditBreak, AuditStale or AuditTakeover, all of them if empty
+	Events []string
+
+	// Secret, if set, signs the payloads: the X-Lock-Signature header is
+	// sha256= followed by the hex HMAC-SHA256 of the body with the secret
+	Secret string
+}
+
+var (
+	// WebhookRetries is the number of attempts of a webhook delivery
+	// failing with a network error or a 429 or 5xx status
+	WebhookRetries = 3
+
+	// webhookClient sends the webhooks
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// deliveries are the webhook and event sink deliveries and the wait
+	// alerts in progress
+	deliveries sync.WaitGroup
+)
+
+// SetWebhooks sets the webhooks, see Configuration.Webhooks
+func SetWebhooks(hooks []Webhook) {
+	setConfig(func(c *Configuration) { c.Webhooks = hooks })
+}
+
+// WaitNotifications waits up to the timeout for the webhook and event
+// sink deliveries and the wait alerts in progress, telling if all completed. Short-lived
+// processes call it before exiting so that their last events are not lost.
+func WaitNotifications(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		deliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// fireWebhooks delivers the record to the webhooks notified of its event,
+// in the background. Failures are logged but not returned, notifications
+// should not prevent locking.
+func (c *Configuration) fireWebhooks(ndir string, rec AuditRecord) {
+	var body []byte
+	for _, hook := range c.Webhooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, rec.Event) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(EventLogRecord{rec, ndir}); err != nil {
+				c.logger().Warn("failed to encode webhook payload", "error", err)
+				return
+			}
+		}
+
+		deliveries.Add(1)
+		go func(hook Webhook) {
+			defer deliveries.Done()
+			if err := deliver(hook, rec.Event, body); err != nil {
+				c.logger().Warn("failed to deliver webhook", "url", hook.URL, "event", rec.Event, "error", err)
+			}
+		}(hook)
+	}
+}
+
+// deliver posts the body to the webhook, retrying failures which may be
+// transient
+func deliver(hook Webhook, event string, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= WebhookRetries; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+
+		var retry bool
+		if retry, err = post(hook, event, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends the body once, telling if a failure is worth retrying
+func post(hook Webhook, event string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Lock-Event", event)
+	if len(hook.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Lock-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}